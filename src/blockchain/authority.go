@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"crypto/dsa"
+	"errors"
+	"github.com/CPSSD/voting/src/crypto"
+	"log"
+	"math/big"
+	"time"
+)
+
+var (
+	NoAuthoritiesError = errors.New("Proof of authority needs at least one authority.")
+)
+
+// AuthorityConsensus is the Consensus of proof of authority, where
+// blocks are signed in turn by the keys of the authorities. Each
+// authority may only sign one of any signerLimit blocks in a row,
+// and a block signed in turn has more work than one which is not.
+type AuthorityConsensus struct {
+	authorities []dsa.PublicKey
+	key         *dsa.PrivateKey
+	me          int
+}
+
+// NewAuthorityConsensus returns an AuthorityConsensus for the
+// authorities. The node signs blocks with key if it is the key of
+// one of the authorities.
+func NewAuthorityConsensus(authorities []dsa.PublicKey, key *dsa.PrivateKey) (a *AuthorityConsensus, err error) {
+
+	if len(authorities) == 0 {
+		return nil, NoAuthoritiesError
+	}
+	a = &AuthorityConsensus{authorities: authorities, key: key, me: -1}
+	if key == nil || key.Y == nil {
+		return a, nil
+	}
+	for i := range authorities {
+		if sameKey(&authorities[i], &key.PublicKey) {
+			a.me = i
+		}
+	}
+	return a, nil
+}
+
+// Name returns ProofOfAuthority.
+func (a *AuthorityConsensus) Name() string {
+	return ProofOfAuthority
+}
+
+// CanSeal returns true if this node is one of the authorities.
+func (a *AuthorityConsensus) CanSeal() bool {
+	return a.me >= 0
+}
+
+// sameKey returns true if a and b are the same DSA public key.
+func sameKey(a, b *dsa.PublicKey) bool {
+	for _, v := range [][2]*big.Int{{a.P, b.P}, {a.Q, b.Q}, {a.G, b.G}, {a.Y, b.Y}} {
+		if v[0] == nil || v[1] == nil || v[0].Cmp(v[1]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// inTurn returns the index of the authority whose turn it is to
+// sign the block at the given height of a chain with n authorities.
+func inTurn(height, n int) int {
+	return (height - 1) % n
+}
+
+// signerLimit returns the number of consecutive blocks of which an
+// authority may only sign one, when there are n authorities. This
+// stops a minority of authorities from taking over the chain.
+func signerLimit(n int) int {
+	return n/2 + 1
+}
+
+// signedRecently returns true if the authority signer has signed
+// one of the blocks before the given height, so that it may not
+// sign the block at that height.
+func signedRecently(blocks []Block, height, signer, n int) bool {
+	for j := height - 1; j >= 1 && j > height-signerLimit(n); j-- {
+		if int(blocks[j].Header.Signer) == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// Work returns the work of a block signed by an authority at the
+// given height. A block signed in turn has more work, so that the
+// chain signed in turn is chosen when authorities race.
+func (a *AuthorityConsensus) Work(h *BlockHeader, height int) *big.Int {
+	if int(h.Signer) == inTurn(height, len(a.authorities)) {
+		return big.NewInt(2)
+	}
+	return big.NewInt(1)
+}
+
+// Seal will sign the block b on top of the chain of blocks with
+// this node's authority key. When it is not this node's turn, it
+// waits for the authorities before it, in case they sign the block
+// first. A signal may be received on the channel stop to indicate
+// that the function should exit early.
+func (a *AuthorityConsensus) Seal(b *Block, blocks []Block, stop chan bool) (stopped bool) {
+
+	n := len(a.authorities)
+	height := len(blocks)
+	if !a.CanSeal() || signedRecently(blocks, height, a.me, n) {
+		log.Println("Waiting for another authority to sign a block")
+		<-stop
+		return true
+	}
+
+	if turn := inTurn(height, n); turn != a.me {
+		delay := authorityDelay * time.Duration((a.me-turn+n)%n)
+		log.Println("Not our turn to sign a block, waiting for", delay)
+		select {
+		case <-stop:
+			log.Println("Interrupting signing of block")
+			return true
+		case <-time.After(delay):
+		}
+	}
+
+	b.getMerkleHash()
+	b.Header.Timestamp = uint32(time.Now().Unix())
+	if height > 1 && b.Header.Timestamp < blocks[height-1].Header.Timestamp {
+		b.Header.Timestamp = blocks[height-1].Header.Timestamp
+	}
+	b.Header.Signer = uint32(a.me)
+	b.Proof = b.Header.Hash()
+	b.Signature = *crypto.SignHash(a.key, &b.Proof)
+
+	log.Println("Signed a block as authority", a.me)
+	return false
+}
+
+// VerifySeal will check that the block at index i of a chain was
+// signed by an authority which was allowed to sign it.
+func (a *AuthorityConsensus) VerifySeal(blocks []Block, i int) bool {
+
+	h := &blocks[i].Header
+	if signedRecently(blocks, i, int(h.Signer), len(a.authorities)) {
+		log.Println("Block is signed by an authority which signed recently")
+		return false
+	}
+	if i > 1 && h.Timestamp < blocks[i-1].Header.Timestamp {
+		log.Println("Block has a timestamp before its parent")
+		return false
+	}
+	if int64(h.Timestamp) > time.Now().Add(maxClockDrift).Unix() {
+		log.Println("Block has a timestamp too far in the future")
+		return false
+	}
+	return a.VerifyBlockSeal(&blocks[i])
+}
+
+// VerifyBlockSeal will check that a block is signed by the
+// authority it names, without checking its place in the chain.
+func (a *AuthorityConsensus) VerifyBlockSeal(bl *Block) bool {
+
+	h := &bl.Header
+	if int(h.Signer) >= len(a.authorities) || h.Target != *new([32]byte) ||
+		h.Nonce != 0 || h.ExtraNonce != 0 {
+		log.Println("Block is not signed by an authority")
+		return false
+	}
+	hash := h.Hash()
+	if hash != bl.Proof {
+		return false
+	}
+	if !crypto.Verify(&a.authorities[h.Signer], &hash, &bl.Signature) {
+		log.Println("Block has an invalid authority signature")
+		return false
+	}
+	return true
+}
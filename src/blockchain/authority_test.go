@@ -0,0 +1,209 @@
+package blockchain
+
+import (
+	"crypto/dsa"
+	"crypto/rand"
+	"github.com/CPSSD/voting/src/crypto"
+	"strconv"
+	"testing"
+)
+
+func TestAuthorityBlocks(t *testing.T) {
+
+	// authorities which are not in turn do not wait in the test
+	delay := authorityDelay
+	authorityDelay = 0
+	defer func() { authorityDelay = delay }()
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewTestAuthorities(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+
+	chains := make([]*Chain, len(keys))
+	for i := range keys {
+		conf.AuthorityKey = keys[i]
+		if chains[i], err = NewTestChainFrom(conf, NewMemoryStore()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := chains[0]
+
+	blocks := c.blocks()
+
+	// the first block is signed in turn by the first authority
+	b1, err := NewTestSignedBlock(chains[0], blocks, "token0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks = append(blocks, *b1)
+	if valid, _ := c.validate(&blocks); !valid {
+		t.Fatal("Expected a block signed by an authority in turn to be valid")
+	}
+
+	// the first authority may not sign the next block as well
+	bl := NewBlock()
+	stop := make(chan bool, 1)
+	stop <- true
+	if stopped := chains[0].consensus.Seal(bl, blocks, stop); !stopped {
+		t.Error("Expected an authority not to sign two blocks in a row\n")
+	}
+
+	var tests = []struct {
+		name   string
+		signer int
+		tamper func(bl *Block) error
+		valid  bool
+	}{
+		{"in turn", 1, func(bl *Block) error { return nil }, true},
+		{"out of turn", 2, func(bl *Block) error { return nil }, true},
+		{"signed twice in a row", 1, func(bl *Block) error {
+			bl.Header.Signer = 0
+			bl.Proof = bl.Header.Hash()
+			bl.Signature = *crypto.SignHash(&keys[0], &bl.Proof)
+			return nil
+		}, false},
+		{"changed timestamp", 1, func(bl *Block) error {
+			bl.Header.Timestamp++
+			return nil
+		}, false},
+		{"changed signer", 1, func(bl *Block) error {
+			bl.Header.Signer = 2
+			bl.Proof = bl.Header.Hash()
+			return nil
+		}, false},
+		{"unknown signer", 1, func(bl *Block) error {
+			bl.Header.Signer = 3
+			bl.Proof = bl.Header.Hash()
+			return nil
+		}, false},
+		{"signed by a voter", 1, func(bl *Block) error {
+			bl.Signature = *crypto.SignHash(&conf.PrivateKey, &bl.Proof)
+			return nil
+		}, false},
+		{"proof of work", 1, func(bl *Block) error {
+			bl.Header.Signer = 0
+			bl.createProof(maxTarget, 0, make(chan bool))
+			return nil
+		}, false},
+	}
+
+	for _, test := range tests {
+		bl, err := NewTestSignedBlock(chains[test.signer], blocks, "token1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = test.tamper(bl); err != nil {
+			t.Fatal(err)
+		}
+		chain := append(blocks[:len(blocks):len(blocks)], *bl)
+		if valid, _ := c.validate(&chain); valid != test.valid {
+			t.Error("For input", test.name, "expected the block to be valid:", test.valid, "\n")
+		}
+	}
+
+	// a block signed in turn has more work than one signed out of turn
+	inTurn, err := NewTestSignedBlock(chains[1], blocks, "token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outOfTurn, err := NewTestSignedBlock(chains[2], blocks, "token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := append(blocks[:len(blocks):len(blocks)], *inTurn)
+	b := append(blocks[:len(blocks):len(blocks)], *outOfTurn)
+	if c.chainWork(a).Cmp(c.chainWork(b)) <= 0 {
+		t.Error("Expected the chain signed in turn to have more work\n")
+	}
+}
+
+func TestAuthorityConfiguration(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewTestAuthorities(&conf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only authorities may add blocks
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.consensus.CanSeal() {
+		t.Error("Expected a node without an authority key not to add blocks\n")
+	}
+	conf.AuthorityKey = keys[0]
+	if c, err = NewTestChainFrom(conf, NewMemoryStore()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.consensus.CanSeal() {
+		t.Error("Expected an authority to add blocks\n")
+	}
+
+	var tests = []struct {
+		name      string
+		consensus string
+		auths     int
+		err       error
+	}{
+		{"proof of work", ProofOfWork, 0, nil},
+		{"default", "", 0, nil},
+		{"no authorities", ProofOfAuthority, 0, NoAuthoritiesError},
+		{"unknown mode", "vote", 0, UnknownConsensusError},
+	}
+	for _, test := range tests {
+		conf.Consensus = test.consensus
+		conf.Authorities = conf.Authorities[:test.auths]
+		if _, err = NewTestChainFrom(conf, NewMemoryStore()); err != test.err {
+			t.Error("For input", test.name, "expected", test.err, "got", err, "\n")
+		}
+	}
+}
+
+// NewTestAuthorities sets the configuration conf to use proof of
+// authority with n new authorities, and returns their keys.
+func NewTestAuthorities(conf *Configuration, n int) (keys []dsa.PrivateKey, err error) {
+
+	keys = make([]dsa.PrivateKey, n)
+	conf.Consensus = ProofOfAuthority
+	conf.Authorities = make([]dsa.PublicKey, n)
+	for i := range keys {
+		keys[i].PublicKey.Parameters = conf.PrivateKey.PublicKey.Parameters
+		if err = dsa.GenerateKey(&keys[i], rand.Reader); err != nil {
+			return nil, err
+		}
+		conf.Authorities[i] = keys[i].PublicKey
+	}
+	return keys, nil
+}
+
+// NewTestSignedBlock returns a block on top of the chain of blocks,
+// signed by the authority of c, containing a vote for the vote
+// token vt.
+func NewTestSignedBlock(c *Chain, blocks []Block, vt string) (bl *Block, err error) {
+
+	tr, err := NewTestTransactionFor(c, vt)
+	if err != nil {
+		return nil, err
+	}
+
+	bl = NewBlock()
+	bl.addTransaction(tr)
+	bl.setParent(&blocks[len(blocks)-1])
+	if stopped := c.consensus.Seal(bl, blocks, make(chan bool)); stopped {
+		return nil, InvalidChainError
+	}
+	return bl, nil
+}
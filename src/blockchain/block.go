@@ -1,43 +1,68 @@
 package blockchain
 
 import (
-	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
+	"github.com/CPSSD/voting/src/crypto"
 	"log"
+	"math/big"
 	"reflect"
+	"runtime"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 // Block contains a set of transactions, a proof of work, and
-// a header with additional information.
+// a header with additional information. With proof of authority,
+// the proof is instead the hash of the header, which is signed
+// by an authority. Only the genesis block of the chain contains
+// a Manifest.
 type Block struct {
 	Transactions []Transaction
 	Header       BlockHeader
 	Proof        [32]byte
+	Signature    crypto.Signature
+	Manifest     *Manifest `json:",omitempty"`
 }
 
-// BlockHeader contains the hash of the block's transactions,
-// the hash of its parent block, a timestamp and the nonce used
-// in the creation of the proof of work.
+// BlockHeader contains the version of the header format, the
+// height of the block in the chain, the hash of the block's
+// transactions, the hash of its parent block, a timestamp, the
+// target which the proof of work must not exceed, and the extra
+// nonce and nonce used in the creation of the proof of work. With
+// proof of authority, it contains the index of the authority which
+// signed the block instead of a target and nonces. The hash of its
+// binary encoding is the block's proof.
 type BlockHeader struct {
+	Version    uint32
+	Height     uint32
 	MerkleHash [32]byte
 	ParentHash [32]byte
 	Timestamp  uint32
+	Target     [32]byte
 	Nonce      uint32
+	Signer     uint32
+	ExtraNonce uint32
 }
 
 // NewBlock returns an empty initalized block.
 func NewBlock() (b *Block) {
 	b = &Block{
-		Transactions: make([]Transaction, 0, blockSize),
+		Transactions: make([]Transaction, 0),
+		Header:       BlockHeader{Version: headerVersion},
 	}
 	return b
 }
 
+// setParent will set the block to follow the block parent in
+// the chain.
+func (b *Block) setParent(parent *Block) {
+	b.Header.ParentHash = parent.Proof
+	b.Header.Height = parent.Header.Height + 1
+}
+
 // String representation of a block
 func (b Block) String() (str string) {
 	//str = str + "\n // Time:          " + fmt.Sprint(b.Header.Timestamp)
@@ -53,54 +78,100 @@ func (b Block) String() (str string) {
 }
 
 // addTransaction will add a transaction to a block.
-func (b *Block) addTransaction(t *Transaction) (isFull bool) {
+func (b *Block) addTransaction(t *Transaction) {
 	log.Println("Adding transaction")
 	b.Transactions = append(b.Transactions, *t)
-	return len(b.Transactions) == cap(b.Transactions)
 }
 
 // createProof will perform the computations required to generate a
-// proof of work for a block. A signal may be received on the channel
-// stop to indicate that the function should exit early.
-func (b *Block) createProof(prefixLen int, stop chan bool) (stopped bool) {
+// proof of work for a block which does not exceed the target. The
+// search is split across workers goroutines, or one for each
+// processor if workers is not positive. A signal may be received
+// on the channel stop to indicate that the function should exit
+// early. The block is given the current time as its timestamp,
+// unless it already has a later one.
+func (b *Block) createProof(target *big.Int, workers int, stop chan bool) (stopped bool) {
 
 	start := time.Now()
-	log.Println("Starting POW")
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	log.Println("Starting POW with", workers, "workers")
 
-	merkle := b.getMerkleHash()
-	altB := b
-	prefix := strings.Repeat("0", prefixLen)
+	b.getMerkleHash()
+	if now := uint32(time.Now().Unix()); now > b.Header.Timestamp {
+		b.Header.Timestamp = now
+	}
+	b.Header.Target = encodeTarget(target)
+	b.Header.ExtraNonce = 0
+	b.Header.Nonce = 0
+
+	// closing done tells the workers to return
+	done := make(chan struct{})
+	found := make(chan BlockHeader, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		h := b.Header
+		h.ExtraNonce = uint32(w)
+		go func() {
+			defer wg.Done()
+			mineHeader(h, uint32(workers), target, done, found)
+		}()
+	}
+
+	select {
+	case <-stop:
+		close(done)
+		wg.Wait()
+		log.Println("Interrupting POW after", time.Since(start))
+		return true
+	case h := <-found:
+		close(done)
+		wg.Wait()
+		b.Header = h
+		b.Proof = h.Hash()
+	}
+	log.Println("Finishing POW, took", time.Since(start))
+	log.Println("Created hash is:", hex.EncodeToString(b.Proof[:]))
+	return false
+}
+
+// mineHeader will search for a nonce which gives the header h a
+// hash which does not exceed the target, and send the header on
+// found. When every nonce up to maxNonce has been tried, the extra
+// nonce is increased by step, so that workers which start from
+// different extra nonces never try the same header. The worker
+// returns when done is closed, which is checked every
+// miningCheckInterval attempts.
+func mineHeader(h BlockHeader, step uint32, target *big.Int, done chan struct{}, found chan BlockHeader) {
 
-	b.Header.Timestamp = uint32(time.Now().Unix())
-	data := make([]byte, 0)
-	hash := *new([32]byte)
-loop:
 	for {
-		select {
-		case <-stop:
-			log.Println("Interrupting POW after", time.Since(start))
-			return true
-		default:
-			var buf bytes.Buffer
-			enc := json.NewEncoder(&buf)
-			err := enc.Encode(&altB.Header)
-			if err != nil {
-				log.Fatalln(err)
+		// the header is encoded once for each extra nonce, and
+		// only the nonce at the end of the encoding changes
+		// between attempts
+		data := h.Bytes()
+		nonce := data[headerSize-4:]
+		for n := uint32(0); ; n++ {
+			if n%miningCheckInterval == 0 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+			binary.BigEndian.PutUint32(nonce, n)
+			if checkProof(sha256.Sum256(data), target) {
+				h.Nonce = n
+				found <- h
+				return
 			}
-			data = append(merkle, buf.Bytes()...)
-			hash = sha256.Sum256(data)
-			if checkProof(prefix, prefixLen, hash) {
-				b.Proof = hash
-				break loop
+			if n == maxNonce {
+				break
 			}
-			altB.Header.Nonce++
 		}
+		h.ExtraNonce += step
 	}
-	log.Println("Finishing POW, took", time.Since(start))
-
-	log.Println("Created data is:", hex.EncodeToString(data))
-	log.Println("Created hash is:", hex.EncodeToString(hash[:]))
-	return false
 }
 
 // MerkleHash will get the hash of the transactions in a block.
@@ -110,17 +181,6 @@ func (b *Block) getMerkleHash() (hash []byte) {
 	return h[:]
 }
 
-// merkleHash will get the hash of a slice of transactions.
-func merkleHash(trs []Transaction) (hash [32]byte) {
-	l := len(trs)
-	if l == 1 {
-		return trs[0].Header.BallotHash
-	}
-	hl := merkleHash(trs[:l/2])
-	hr := merkleHash(trs[l/2:])
-	return sha256.Sum256(append(hl[:], hr[:]...))
-}
-
 func (b *Block) contains(t *Transaction) bool {
 	for _, tr := range b.Transactions {
 		if reflect.DeepEqual(t.Header.VoteToken, tr.Header.VoteToken) {
@@ -130,39 +190,23 @@ func (b *Block) contains(t *Transaction) bool {
 	return false
 }
 
-// validate will validate the proof of work of a block
-// against its parent's hash.
-func (bl *Block) validate(parent [32]byte) (isValid bool, hash [32]byte) {
-
-	prefixLen := proofDifficultyBl
-	prefix := strings.Repeat("0", prefixLen)
-
-	merkle := merkleHash(bl.Transactions)
-
-	tmpBl := &Block{
-		Header: BlockHeader{
-			MerkleHash: merkle,
-			ParentHash: parent,
-			Timestamp:  bl.Header.Timestamp,
-			Nonce:      bl.Header.Nonce,
-		},
-	}
-
-	var data []byte
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	err := enc.Encode(&tmpBl.Header)
-	if err != nil {
+// validate will check that proof is a valid proof of work for
+// the header, meeting the header's own target, without needing
+// the transactions of the block. The header is only valid for a
+// block whose transactions have the header's merkle hash, and
+// whose target and height are the ones expected at its place in
+// the chain. The hash of the header is returned.
+func (h *BlockHeader) validate(proof [32]byte) (isValid bool, hash [32]byte) {
+
+	// timestamps are used to set the target, so may not be
+	// set far in the future
+	if int64(h.Timestamp) > time.Now().Add(maxClockDrift).Unix() {
+		log.Println("Block has a timestamp too far in the future")
 		return false, hash
 	}
 
-	data = append(merkle[:], buf.Bytes()...)
-	log.Println("Recreated data is: ")
-	log.Println(hex.EncodeToString(data))
-	hash = sha256.Sum256(data)
-	log.Println("Recreated hash is: ")
-	log.Println(hex.EncodeToString(hash[:]))
-	if !checkProof(prefix, prefixLen, hash) || hash != bl.Proof {
+	hash = h.Hash()
+	if !checkProof(hash, h.target()) || hash != proof {
 		return false, hash
 	}
 
@@ -172,16 +216,9 @@ func (bl *Block) validate(parent [32]byte) (isValid bool, hash [32]byte) {
 // extractTransactions will gather all the transactions in a
 // slice of blocks.
 func extractTransactions(blocks *[]Block) *[]Transaction {
-	trs := make([]Transaction, len(*blocks)*blockSize)
-	var i int
+	trs := make([]Transaction, 0)
 	for _, bl := range *blocks {
-		i += copy(trs[i:], bl.Transactions)
+		trs = append(trs, bl.Transactions...)
 	}
 	return &trs
 }
-
-// checkProof will check for a partial hash collision.
-func checkProof(prefix string, len int, hash [32]byte) bool {
-	s := hex.EncodeToString(hash[:])
-	return s[:len] == prefix
-}
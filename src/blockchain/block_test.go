@@ -0,0 +1,198 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestHeaderEncoding(t *testing.T) {
+
+	h := NewTestHeader()
+	if n := len(h.Bytes()); n != headerSize {
+		t.Error("Expected the header encoding to have", headerSize, "bytes, got", n, "\n")
+	}
+
+	// every field of the header changes its hash
+	var tests = []struct {
+		name   string
+		change func(h *BlockHeader)
+	}{
+		{"version", func(h *BlockHeader) { h.Version++ }},
+		{"height", func(h *BlockHeader) { h.Height++ }},
+		{"parent hash", func(h *BlockHeader) { h.ParentHash[31]++ }},
+		{"merkle hash", func(h *BlockHeader) { h.MerkleHash[31]++ }},
+		{"timestamp", func(h *BlockHeader) { h.Timestamp++ }},
+		{"target", func(h *BlockHeader) { h.Target[31]++ }},
+		{"signer", func(h *BlockHeader) { h.Signer++ }},
+		{"extra nonce", func(h *BlockHeader) { h.ExtraNonce++ }},
+		{"nonce", func(h *BlockHeader) { h.Nonce++ }},
+	}
+	for _, test := range tests {
+		changed := NewTestHeader()
+		test.change(&changed)
+		if changed.Hash() == h.Hash() {
+			t.Error("For input", test.name, "expected the hash of the header to change\n")
+		}
+	}
+
+	// the nonce is at the end of the encoding
+	changed := NewTestHeader()
+	changed.Nonce = 0x01020304
+	if enc := changed.Bytes(); !bytes.Equal(enc[headerSize-4:], []byte{1, 2, 3, 4}) {
+		t.Error("Expected the nonce at the end of the encoding\n")
+	}
+}
+
+func TestBlockHeight(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := c.blocks()
+
+	var tests = []struct {
+		name   string
+		change func(bl *Block)
+		valid  bool
+	}{
+		{"expected header", func(bl *Block) {}, true},
+		{"wrong height", func(bl *Block) { bl.Header.Height = 2 }, false},
+		{"wrong version", func(bl *Block) { bl.Header.Version = headerVersion + 1 }, false},
+	}
+
+	for _, test := range tests {
+		tr, err := NewTestTransaction(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl := NewBlock()
+		bl.addTransaction(tr)
+		bl.setParent(&blocks[0])
+		test.change(bl)
+		bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+
+		chain := []Block{blocks[0], *bl}
+		if valid, _ := c.validate(&chain); valid != test.valid {
+			t.Error("For input", test.name, "expected the block to be valid:", test.valid, "\n")
+		}
+	}
+}
+
+func TestParallelMining(t *testing.T) {
+
+	// a small nonce space makes the workers use their extra nonces
+	nonces := maxNonce
+	maxNonce = 3
+	defer func() { maxNonce = nonces }()
+
+	target := new(big.Int).Rsh(maxTarget, 8)
+	for _, workers := range []int{1, 2, 8} {
+		bl := NewBlock()
+		bl.Header.Height = uint32(workers)
+		if stopped := bl.createProof(target, workers, make(chan bool)); stopped {
+			t.Fatal("For input", workers, "expected the proof to be found")
+		}
+		if valid, _ := bl.Header.validate(bl.Proof); !valid {
+			t.Error("For input", workers, "expected a valid proof of work\n")
+		}
+		if bl.Header.Nonce > maxNonce {
+			t.Error("For input", workers, "expected the nonce to be at most", maxNonce, "got", bl.Header.Nonce, "\n")
+		}
+	}
+
+	// mining which can not succeed stops promptly when signalled
+	bl := NewBlock()
+	stop := make(chan bool, 1)
+	done := make(chan bool)
+	go func() {
+		done <- bl.createProof(big.NewInt(1), 4, stop)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stop <- true
+	select {
+	case stopped := <-done:
+		if !stopped {
+			t.Error("Expected mining to be stopped\n")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected mining to stop within a second\n")
+	}
+}
+
+// NewTestHeader returns a header with every field set.
+func NewTestHeader() BlockHeader {
+	return BlockHeader{
+		Version:    headerVersion,
+		Height:     7,
+		ParentHash: sha256.Sum256([]byte("parent")),
+		MerkleHash: sha256.Sum256([]byte("merkle")),
+		Timestamp:  1500000000,
+		Target:     encodeTarget(initialTarget(testDifficulty)),
+		Signer:     1,
+		ExtraNonce: 3,
+		Nonce:      42,
+	}
+}
+
+// BenchmarkHeaderHash measures the hash rate of mining, where only
+// the nonce of the encoded header changes between attempts.
+func BenchmarkHeaderHash(b *testing.B) {
+	h := NewTestHeader()
+	data := h.Bytes()
+	for i := 0; i < b.N; i++ {
+		data[headerSize-1] = byte(i)
+		sha256.Sum256(data)
+	}
+}
+
+// BenchmarkHeaderHashEncode measures the hash rate when the whole
+// header is encoded for each attempt, as when validating a block.
+func BenchmarkHeaderHashEncode(b *testing.B) {
+	h := NewTestHeader()
+	for i := 0; i < b.N; i++ {
+		h.Nonce++
+		h.Hash()
+	}
+}
+
+// BenchmarkHeaderHashJSON measures the hash rate of the previous
+// json encoding of headers, for comparison.
+func BenchmarkHeaderHashJSON(b *testing.B) {
+	h := NewTestHeader()
+	for i := 0; i < b.N; i++ {
+		h.Nonce++
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(&h); err != nil {
+			b.Fatal(err)
+		}
+		sha256.Sum256(append(h.MerkleHash[:], buf.Bytes()...))
+	}
+}
+
+// BenchmarkCreateProof measures the time taken to mine a block
+// at the initial target with one worker for each processor.
+func BenchmarkCreateProof(b *testing.B) {
+	MineTestBlocks(b, 0)
+}
+
+// BenchmarkCreateProofOneWorker measures the time taken to mine a
+// block at the initial target with one worker.
+func BenchmarkCreateProofOneWorker(b *testing.B) {
+	MineTestBlocks(b, 1)
+}
+
+// MineTestBlocks mines blocks with the number of workers.
+// Each block has a different height, so that a different proof is
+// found.
+func MineTestBlocks(b *testing.B, workers int) {
+	bl := NewBlock()
+	for i := 0; i < b.N; i++ {
+		bl.Header.Height = uint32(i)
+		bl.createProof(initialTarget(testDifficulty), workers, make(chan bool))
+	}
+}
@@ -1,56 +1,100 @@
 package blockchain
 
 import (
+	"context"
+	"errors"
 	"github.com/CPSSD/voting/src/crypto"
 	"log"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ChainRanError        = errors.New("Chain has already been run.")
+	ChainNotRunningError = errors.New("Chain is not running.")
+)
+
 // Chain contains all of the information required for the system
 // to function.
 type Chain struct {
-	Peers               chan map[string]bool
-	TransactionPool     chan []Transaction
 	TransactionsReady   chan []Transaction
 	CurrentTransactions chan []Transaction
 	BlockUpdate         chan BlockUpdate
-	KeyShares           chan map[string]ElectionSecret
-	SeenTrs             chan map[string]bool
+	Reorgs              chan ReorgEvent
 	head                *Block
-	blocks              chan []Block
+	state               *stateManager
+	store               BlockStore
 	conf                Configuration
+	consensus           Consensus
+	customConsensus     bool
+
+	// the mining process is paused with a signal on stopMining,
+	// which it confirms on confirmStopped, and resumed with a
+	// signal on startMining
+	stopMining     chan bool
+	startMining    chan bool
+	confirmStopped chan bool
+
+	// the lifecycle of the chain: the RPC functions are served by
+	// the transport on listener, the election key is generated
+	// while generating is set, which must finish before the chain
+	// is run, quit is closed once the chain is told to stop, and
+	// stopped once Run has returned runErr
+	transport  Transport
+	listener   Listener
+	mu         sync.Mutex
+	generating bool
+	ran        bool
+	quit       chan struct{}
+	stopOnce   sync.Once
+	stopped    chan struct{}
+	runErr     error
 }
 
+// NewChain returns a new Chain, which uses the consensus mode set
+// in the election manifest.
 func NewChain() (c *Chain, err error) {
+	return NewChainWith(nil)
+}
+
+// NewChainWith returns a new Chain which uses the Consensus cons to
+// seal and verify blocks and to choose between chains. If cons is
+// nil, the consensus mode set in the election manifest is used.
+func NewChainWith(cons Consensus) (c *Chain, err error) {
 	c = &Chain{
-		Peers:               make(chan map[string]bool, 1),
-		TransactionPool:     make(chan []Transaction, 1),
 		TransactionsReady:   make(chan []Transaction, 1),
 		CurrentTransactions: make(chan []Transaction, 1),
 		BlockUpdate:         make(chan BlockUpdate, 1),
-		KeyShares:           make(chan map[string]ElectionSecret, 1),
-		SeenTrs:             make(chan map[string]bool, 1),
+		Reorgs:              make(chan ReorgEvent, 16),
 		head:                NewBlock(),
-		blocks:              make(chan []Block, 1),
-	}
-	pool := make([]Transaction, 0)
-	c.TransactionPool <- pool
-	seenTrs := make(map[string]bool, 0)
-	c.SeenTrs <- seenTrs
-	keyShares := make(map[string]ElectionSecret, 0)
-	c.KeyShares <- keyShares
-	blocks := make([]Block, 0)
-	c.blocks <- blocks
+		state:               newStateManager(),
+		store:               NewMemoryStore(),
+		consensus:           cons,
+		customConsensus:     cons != nil,
+		stopMining:          make(chan bool),
+		startMining:         make(chan bool, 1),
+		confirmStopped:      make(chan bool),
+		transport:           HTTPTransport{},
+		quit:                make(chan struct{}),
+		stopped:             make(chan struct{}),
+	}
+	if cons == nil {
+		c.consensus = new(WorkConsensus)
+	}
 	return c, nil
 }
 
 // ReconstructElectionKey will attempt to reconstruct the
 // election key from the shares currently available to a node.
 func (c *Chain) ReconstructElectionKey() {
-	shares := <-c.KeyShares
-	c.KeyShares <- shares
+	if c.conf.ThresholdDecryption {
+		log.Println("The election key is not reconstructed in threshold decryption mode")
+		return
+	}
+
+	shares := c.keyShares()
 
 	lambdaShares := make([]crypto.Share, len(shares))
 	muShares := make([]crypto.Share, len(shares))
@@ -79,45 +123,41 @@ func (c *Chain) ReconstructElectionKey() {
 }
 
 // String representation of a Chain.
-func (c Chain) String() (str string) {
-	blocks := <-c.blocks
-	c.blocks <- blocks
-	for i, b := range blocks {
+func (c *Chain) String() (str string) {
+	for i, b := range c.blocks() {
 		str = str + "Block " + strconv.Itoa(i) + ": \b" + b.String() + "\n"
 	}
 	return "Chain:\n " + str
 }
 
 // schedulePeerSync will regularly sync peer lists with its known
-// peers.
-func (c *Chain) schedulePeerSync(syncDelay int, quit chan bool, wg *sync.WaitGroup) {
+// peers, until done is closed.
+func (c *Chain) schedulePeerSync(syncDelay int, done <-chan struct{}) {
 	timer := time.NewTimer(time.Second)
-loop:
+	defer timer.Stop()
 	for {
 		select {
-		case <-quit:
+		case <-done:
 			log.Println("Peer syncing process received signal to shutdown")
-			quit <- true
-			wg.Done()
-			break loop
+			return
 		case <-timer.C:
 			log.Println("About to sync peers")
 			c.syncPeers()
-			timer = time.NewTimer(time.Second * time.Duration(syncDelay))
+			timer.Reset(time.Second * time.Duration(syncDelay))
 		}
 	}
 }
 
 // removeSeenTransactions will return an array of transactions which do not
-// occur in the map of seen transaction tokens
+// occur in the map of seen transaction tokens, which is updated with their
+// tokens. The transactions must already have been validated, as they are
+// checked while the state of the chain is locked.
 func (c *Chain) removeSeenTransactions(trs []Transaction, seen map[string]bool) (out []Transaction) {
 
 	for _, tr := range trs {
 		if _, ok := seen[tr.Header.VoteToken]; !ok {
-			if valid := c.ValidateSignature(&tr); valid {
-				out = append(out, tr)
-				seen[tr.Header.VoteToken] = true
-			}
+			out = append(out, tr)
+			seen[tr.Header.VoteToken] = true
 		}
 	}
 
@@ -125,53 +165,77 @@ func (c *Chain) removeSeenTransactions(trs []Transaction, seen map[string]bool)
 }
 
 // scheduleMining is responsible for the logic of creating new
-// blocks in the chain.
-func (c *Chain) scheduleMining(quit, stopMining, startMining, confirmStopped chan bool, wg *sync.WaitGroup) {
+// blocks in the chain, until done is closed. Mining is paused
+// while the chain is reorganized, by a signal on stopMining,
+// which is confirmed on confirmStopped, and resumed by a signal
+// on startMining.
+func (c *Chain) scheduleMining(done <-chan struct{}) {
 	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+
+	// pendingSince is when the pool was last seen to become
+	// non-empty, so that partial blocks can be mined after waiting
+	var pendingSince time.Time
 start:
 
 	log.Println("Waiting for the signal to start mining")
-	_ = <-startMining
+	select {
+	case <-c.startMining:
+	case <-done:
+		log.Println("Mining process received signal to shutdown")
+		return
+	}
 	log.Println("Got the signal, about to start mining")
 
-loop:
 	for {
 		select {
 
-		default:
-			// By default, we wait for timer to expire, then we will check
-			// to see if there are enough transactions in the pool that we
-			// can create a block from.
-			_ = <-timer.C
-
-			// Get the pool and see if it is longer than the constant blockSize
-			pool := <-c.TransactionPool
-			if len(pool) >= blockSize {
-				// if so, we will put blockSize worth of transactions into
-				// the TransactionsReady channel, and replace the rest of the
-				// transactions
-				c.TransactionsReady <- pool[:blockSize]
-				c.TransactionPool <- pool[blockSize:]
-			} else {
-				c.TransactionPool <- pool
+		case <-timer.C:
+			// When the timer expires, we will check to see if there
+			// are enough transactions in the pool that we can create
+			// a block from.
+
+			// Get the pool and see if it is longer than the BlockSize,
+			// or has waited long enough to mine a partial block
+			// only authorities may add blocks with proof of authority
+			var ready []Transaction
+			c.update(func(tx *stateTx) error {
+				if len(tx.Pool) == 0 {
+					pendingSince = time.Time{}
+				} else if pendingSince.IsZero() {
+					pendingSince = time.Now()
+				}
+				n := 0
+				if c.consensus.CanSeal() {
+					n = c.readyTransactions(tx.Pool, time.Since(pendingSince))
+				}
+				// the pool is not saved, so that the transactions
+				// being mined are still in the store if the node
+				// stops before their block is added
+				ready, tx.Pool = tx.Pool[:n:n], tx.Pool[n:]
+				return nil
+			})
+			if len(ready) > 0 {
+				// if so, we will put up to BlockSize worth of transactions
+				// into the TransactionsReady channel
+				c.TransactionsReady <- ready
 			}
 			// Reset the timer
-			timer = time.NewTimer(time.Second * time.Duration(hashingDelay))
+			timer.Reset(time.Second * time.Duration(c.conf.Params.HashingDelay))
 
-		case <-quit:
+		case <-done:
 			log.Println("Mining process received signal to shutdown")
-			quit <- true
-			wg.Done()
-			break loop
+			return
 
-		case <-stopMining:
+		case <-c.stopMining:
 			log.Println("Mining process received signal to stop activities")
-			c.CurrentTransactions <- make([]Transaction, 0)
-			confirmStopped <- true
+			if !c.confirmStop(make([]Transaction, 0), done) {
+				return
+			}
 			goto start
 
 		case blockPool := <-c.TransactionsReady:
-			log.Println("We have enough transactions to create a block")
+			log.Println("We have", len(blockPool), "transactions to create a block")
 			// make a backup in case we need to stop mining
 			tmpTrs := blockPool
 
@@ -180,182 +244,392 @@ loop:
 				c.head.addTransaction(&tr)
 			}
 
-			blocks := <-c.blocks
-			c.blocks <- blocks
+			blocks := c.blocks()
 
 			if len(blocks) != 0 {
-				c.head.Header.ParentHash = blocks[len(blocks)-1].Proof
+				c.head.setParent(&blocks[len(blocks)-1])
 			} else {
 				c.head.Header.ParentHash = *new([32]byte)
 			}
 
-			// compute block hash until created or stopped by new longest chain
-			stopped := c.head.createProof(proofDifficultyBl, stopMining)
+			// compute block hash until created or stopped by new longest
+			// chain, or by the chain shutting down
+			stopSeal := make(chan bool, 1)
+			sealed := make(chan bool, 1)
+			go func() {
+				sealed <- c.consensus.Seal(c.head, blocks, stopSeal)
+			}()
+
+			var stopped, stopRequested bool
+			select {
+			case stopped = <-sealed:
+			case <-c.stopMining:
+				stopSeal <- true
+				stopped, stopRequested = <-sealed, true
+			case <-done:
+				stopSeal <- true
+				<-sealed
+				log.Println("Mining process received signal to shutdown")
+				c.returnToPool(tmpTrs)
+				c.head = NewBlock()
+				return
+			}
 
 			if stopped {
-
 				log.Println("Mining process received signal to stop activities")
+			} else {
+				log.Println("Mining process created a block")
 
-				// notify what transactions we were working with
-				c.CurrentTransactions <- tmpTrs
-				c.head = NewBlock()
-				confirmStopped <- true
+				bl := *c.head
+				if c.addMinedBlock(&bl) {
+					go c.sendBlock(&bl)
+				}
+				// the transactions are in the block, rather than
+				// being mined
+				tmpTrs = make([]Transaction, 0)
+			}
+			c.head = NewBlock()
 
+			if stopRequested {
+				// notify what transactions we were working with
+				if !c.confirmStop(tmpTrs, done) {
+					return
+				}
 				goto start
+			}
+		}
+	}
+}
 
-			} else {
-
-				log.Println("Mining process created a block")
+// confirmStop will notify the chain update process that mining has
+// stopped, along with the transactions which were being mined. It
+// returns false if done was closed first.
+func (c *Chain) confirmStop(trs []Transaction, done <-chan struct{}) bool {
+	select {
+	case c.CurrentTransactions <- trs:
+	case <-done:
+		c.returnToPool(trs)
+		return false
+	}
+	select {
+	case c.confirmStopped <- true:
+		return true
+	case <-done:
+		return false
+	}
+}
 
-				seenTrs := <-c.SeenTrs
-				for _, tr := range c.head.Transactions {
-					seenTrs[tr.Header.VoteToken] = true
-				}
-				c.SeenTrs <- seenTrs
+// returnToPool will add transactions which were taken from the pool
+// to be mined back to it, unless they are now seen in the chain or
+// are already in the pool.
+func (c *Chain) returnToPool(trs []Transaction) {
+	c.update(func(tx *stateTx) error {
+		pool := append(tx.Pool[:len(tx.Pool):len(tx.Pool)], trs...)
+		tx.setPool(c.removeSeenTransactions(pool, tx.copySeen()))
+		return nil
+	})
+}
 
-				blocks := <-c.blocks
-				c.blocks <- append(blocks, *c.head)
+// addMinedBlock will add the block bl, which this node mined, to
+// the end of the chain, mark its transactions as seen, and save
+// the pool without them, all in one transaction. If the chain no
+// longer ends with the parent of the block, the block is dropped,
+// its transactions are returned to the pool, and false is returned.
+func (c *Chain) addMinedBlock(bl *Block) (added bool) {
+
+	c.update(func(tx *stateTx) error {
+		n := len(tx.Blocks)
+		seen := tx.copySeen()
+
+		if n == 0 || tx.Blocks[n-1].Proof != bl.Header.ParentHash {
+			log.Println("The chain changed while mining, returning the transactions to the pool")
+			pool := append(tx.Pool[:len(tx.Pool):len(tx.Pool)], bl.Transactions...)
+			tx.setPool(c.removeSeenTransactions(pool, seen))
+			return nil
+		}
 
-				bl := *c.head
-				c.head = NewBlock()
+		for _, tr := range bl.Transactions {
+			seen[tr.Header.VoteToken] = true
+		}
+		tx.setBlocks(append(tx.Blocks[:n:n], *bl))
+		tx.setSeen(seen)
+		tx.setPool(tx.Pool)
+		added = true
+		return nil
+	})
+	return added
+}
 
-				go c.sendBlock(&bl)
-			}
+// readyTransactions returns the number of transactions from the
+// start of a pool of pending transactions, which have waited for
+// the duration waited, to put into the next block. A block is
+// mined when there are enough transactions to fill it, by number
+// or by size, or with fewer transactions once they have waited
+// the maximum wait for a block, or once the pool has been flushed
+// because the election is closed.
+func (c *Chain) readyTransactions(pool []Transaction, waited time.Duration) int {
+
+	p := &c.conf.Params
+	n, size := 0, 0
+	for ; n < len(pool) && n < p.BlockSize; n++ {
+		if size += pool[n].size(); size > p.MaxBlockBytes {
+			break
 		}
 	}
+	if n == 0 {
+		return 0
+	}
+	if n == p.BlockSize || n < len(pool) {
+		return n
+	}
+	wait := time.Duration(p.MaxBlockWait) * time.Second
+	if waited >= wait || c.flushing() {
+		log.Println("Mining a partial block of", n, "transactions")
+		return n
+	}
+	return 0
 }
 
-// Start will begin some of the background routines required for the running
-// of the blockchain such as searching for new peers, mining blocks, handling
-// chain updates, and listening for new key shares.
-func (c *Chain) Start(delay int, quit, stop, start, confirm chan bool, w *sync.WaitGroup) {
+// Flush will make every pending transaction be mined into a block
+// without waiting for blocks to be full, as when the election has
+// closed. The pool is flushed automatically after VotingEnd.
+func (c *Chain) Flush() {
+	log.Println("Flushing the pool of pending transactions")
+	atomic.StoreInt32(&c.state.flushed, 1)
+}
+
+// flushing returns true if the pool has been flushed, or the
+// voting window of the election has ended.
+func (c *Chain) flushing() bool {
 
-	// check for new peers every "delay" seconds
-	log.Println("Starting peer syncing process...")
-	go c.schedulePeerSync(delay, quit, w)
+	if atomic.LoadInt32(&c.state.flushed) != 0 {
+		return true
+	}
+	return c.conf.VotingEnd != 0 && uint32(time.Now().Unix()) > c.conf.VotingEnd
+}
+
+// Run will begin the background routines required for the running
+// of the blockchain such as searching for new peers, mining blocks,
+// handling chain updates, and broadcasting key shares. It blocks
+// until ctx is cancelled or Stop is called, and then waits for the
+// routines to return, puts the transactions which were being mined
+// back into the pool, saves the state of the chain, and closes the
+// RPC listener opened by Init and the block store. The first error
+// in doing so is returned. A Chain can only be run once, and not
+// while the election key is being generated.
+func (c *Chain) Run(ctx context.Context) (err error) {
+
+	c.mu.Lock()
+	if c.ran {
+		c.mu.Unlock()
+		return ChainRanError
+	}
+	if c.generating {
+		c.mu.Unlock()
+		return KeyGenRunningError
+	}
+	c.ran = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.runErr = err
+		close(c.stopped)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := ctx.Done()
+
+	var wg sync.WaitGroup
+	run := func(name string, f func()) {
+		log.Println("Starting", name, "process...")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	// check for new peers every SyncDelay seconds
+	delay := c.conf.Params.SyncDelay
+	run("peer syncing", func() { c.schedulePeerSync(delay, done) })
 
 	// be processing transactions aka making blocks
-	log.Println("Starting mining process...")
-	go c.scheduleMining(quit, stop, start, confirm, w)
+	c.startMining <- true
+	run("mining", func() { c.scheduleMining(done) })
 
 	// be ready to process new blocks and consensus forming
-	log.Println("Starting chain management process...")
-	go c.scheduleChainUpdates(quit, stop, start, confirm, w)
+	run("chain management", func() { c.scheduleChainUpdates(done) })
+
+	// be broadcasting our shares
+	run("key share broadcasting", func() { c.scheduleKeyShareBroadcasting(delay, done) })
+
+	select {
+	case <-done:
+	case <-c.quit:
+	}
+	log.Println("Shutting down the chain")
+	c.stopOnce.Do(func() { close(c.quit) })
+	cancel()
+	wg.Wait()
+
+	return c.shutdown()
+}
+
+// Stop will stop a running chain, and wait for Run to return. It
+// returns the error returned by Run, or ChainNotRunningError if Run
+// has not been called, in which case a later call of Run returns
+// straight away.
+func (c *Chain) Stop() (err error) {
+
+	c.stopOnce.Do(func() { close(c.quit) })
+
+	c.mu.Lock()
+	ran := c.ran
+	c.mu.Unlock()
+	if !ran {
+		return ChainNotRunningError
+	}
+	<-c.stopped
+	return c.runErr
+}
 
-	// be listening for new shares
-	log.Println("Starting key share collection process...")
-	go c.scheduleKeyShareBroadcasting(delay, quit, w)
+// shutdown will put the transactions which were waiting to be mined
+// or to be returned to the pool back into it, save the pool, and
+// close the RPC listener and the block store. The routines of the
+// chain must have returned.
+func (c *Chain) shutdown() (err error) {
+
+	pending := make([]Transaction, 0)
+	select {
+	case trs := <-c.TransactionsReady:
+		pending = append(pending, trs...)
+	default:
+	}
+	select {
+	case trs := <-c.CurrentTransactions:
+		pending = append(pending, trs...)
+	default:
+	}
+	c.returnToPool(pending)
+
+	if c.listener != nil {
+		if lerr := c.listener.Close(); lerr != nil {
+			err = lerr
+		}
+	}
+	if serr := c.store.Close(); serr != nil && err == nil {
+		err = serr
+	}
+	log.Println("The chain has shut down")
+	return err
 }
 
 // scheduleKeyShareBroadcasting will regularly broadcast the
-// list of currently known key shares to the network.
-func (c *Chain) scheduleKeyShareBroadcasting(delay int, quit chan bool, wg *sync.WaitGroup) {
+// list of currently known key shares and partial tallies to
+// the network, until done is closed.
+func (c *Chain) scheduleKeyShareBroadcasting(delay int, done <-chan struct{}) {
 	timer := time.NewTimer(time.Second * time.Duration(delay))
-loop:
+	defer timer.Stop()
 	for {
 		select {
-		case <-quit:
-			log.Println("Key share collection process received signal to shutdown")
-			quit <- true
-			wg.Done()
-			break loop
+		case <-done:
+			log.Println("Key share broadcasting process received signal to shutdown")
+			return
 
 		case <-timer.C:
 			log.Println("About to broadcast key shares")
 			c.broadcastKeyShares()
-			timer = time.NewTimer(time.Second * time.Duration(delay))
+			c.broadcastPartialTallies()
+			timer.Reset(time.Second * time.Duration(delay))
 
 		}
 	}
 }
 
 // scheduleChainUpdates will handle new block updates, and communicates
-// with the mining process to help manage when and what to mine.
-func (c *Chain) scheduleChainUpdates(quit, stopMining, startMining, confirmStopped chan bool, wg *sync.WaitGroup) {
-loop:
+// with the mining process to help manage when and what to mine, until
+// done is closed.
+func (c *Chain) scheduleChainUpdates(done <-chan struct{}) {
 	for {
 		select {
-		case <-quit:
+		case <-done:
 			log.Println("Chain update process received signal to shutdown")
-			quit <- true
-			wg.Done()
-			break loop
+			return
 
 		case blu := <-c.BlockUpdate:
 			log.Println("Handling block update")
 
-			blocks := <-c.blocks
-			c.blocks <- blocks
-			newBlocks := append(blocks, blu.LatestBlock)
+			state := c.snapshot()
+			blocks, side := state.Blocks, state.Side
 
-			// validate the proposed new chain
-			valid, seen := c.validate(&newBlocks)
-
-			if valid {
+			if isKnown(blocks, side, blu.LatestBlock.Proof) {
+				log.Println("Update contains a block we already have")
+				continue
+			}
 
-				log.Println("Update contains valid next block")
+			var valid bool
+			var seen map[string]bool
+			work := c.chainWork(blocks)
 
-			} else if !valid && blu.ChainLength > uint32(len(blocks)) {
+			newBlocks, fork, known := branchTo(blocks, side, &blu.LatestBlock)
+			if known {
 
-				log.Println("Possible new longer chain;", blu.ChainLength, "vs", uint32(len(blocks)))
-				log.Println("Getting alt chain")
-				altChain, err := c.getChainUpdateFrom(blu.Peer)
-				if err != nil {
-					log.Println("There was a problem getting the alt chain")
+				// the chain with the most work is chosen, so a
+				// branch which does not have more work is kept
+				// in case it does later
+				if c.chainWork(newBlocks).Cmp(work) <= 0 {
+					c.addSideBlock(newBlocks)
 					continue
 				}
 
-				// make sure it is longer
-				if len(*altChain) < len(blocks) {
-					log.Println("Alt chain is shorter")
-					continue
+				// validate the new blocks of the branch
+				valid, seen = c.validateFrom(&newBlocks, fork+1, seenIn(newBlocks[:fork+1]))
+				if valid && fork == len(blocks)-1 {
+					log.Println("Update contains valid next block")
+				} else if valid {
+					log.Println("Update completes a valid branch with more work")
 				}
 
-				// validate the new chain
-				newBlocks = *altChain
+			} else if blu.ChainWork != nil && blu.ChainWork.Cmp(work) > 0 {
 
-				valid, seen = c.validate(altChain)
-				if valid {
-					log.Println("Alt chain is valid")
+				log.Println("Possible new chain with more work;", blu.ChainWork, "vs", work)
+				log.Println("Syncing with alt chain")
+				var err error
+				newBlocks, seen, err = c.syncFrom(blu.Peer, blocks)
+				if err != nil {
+					log.Println("There was a problem syncing with the alt chain:", err)
+					continue
 				}
+				log.Println("Alt chain is valid")
+				valid = true
 			}
 
 			// if newBlocks is a valid chain...
 			if valid {
 
 				log.Println("Sending signal to stop mining")
-				stopMining <- true
+				select {
+				case c.stopMining <- true:
+				case <-done:
+					return
+				}
 
-				_ = <-confirmStopped
+				select {
+				case <-c.confirmStopped:
+				case <-done:
+					return
+				}
 				log.Println("We have stopped mining")
 
-				// set the new chain of blocks
-				oldBlocks := <-c.blocks
-				c.blocks <- newBlocks
-
-				// set the new map of seen transactions
-				_ = <-c.SeenTrs
-				c.SeenTrs <- seen
-
-				// set the new pool of transactions still to be mined
-				oldPool := <-c.TransactionPool
-				currentTrs := <-c.CurrentTransactions
-
-				oldChainTrs := extractTransactions(&oldBlocks)
-
-				allTrs := append(oldPool, currentTrs...)
-				allTrs = append(allTrs, *oldChainTrs...)
-
-				newPool := c.removeSeenTransactions(allTrs, seen)
-
-                go c.broadcastOldTransactions(&newPool)
-
-				c.TransactionPool <- newPool
-
-				go c.sendBlock(&blu.LatestBlock)
+				if err := c.reorganize(newBlocks, seen); err != nil {
+					log.Println("The chain was not reorganized:", err)
+				} else {
+					go c.sendBlock(&blu.LatestBlock)
+				}
 
 				log.Println("Sending signal to start mining again")
-				startMining <- true
+				c.startMining <- true
 			} else {
 				log.Println("Alt chain was not valid")
 			}
@@ -374,12 +648,40 @@ func (c *Chain) broadcastOldTransactions(trs *[]Transaction) {
 }
 
 // validate will validate a set of blocks and their transactions.
+// The first block must be the genesis block of this election.
 func (c *Chain) validate(blocks *[]Block) (valid bool, seen map[string]bool) {
 
 	seen = make(map[string]bool, 0)
-	parent := *new([32]byte)
 
-	for _, bl := range *blocks {
+	genesis := c.GenesisHash()
+	if len(*blocks) == 0 || genesis == *new([32]byte) {
+		log.Println("Invalid chain - no genesis block")
+		return false, seen
+	}
+	if valid, hash := (*blocks)[0].validateGenesis(); !valid || hash != genesis {
+		log.Println("Invalid chain - wrong genesis block")
+		return false, seen
+	}
+	return c.validateFrom(blocks, 1, seen)
+}
+
+// validateFrom will validate the blocks of a chain from the
+// index from onwards, where the blocks before it are already
+// known to be valid, and seen contains the vote tokens in them.
+// The map seen is updated with the vote tokens in the new blocks.
+func (c *Chain) validateFrom(blocks *[]Block, from int, seen map[string]bool) (valid bool, _ map[string]bool) {
+
+	if from < 1 || from > len(*blocks) {
+		log.Println("Invalid chain - no valid blocks to build on")
+		return false, seen
+	}
+
+	for i, bl := range (*blocks)[from:] {
+
+		if !c.blockFits(&bl) {
+			log.Println("Invalid chain - block is larger than the chain parameters allow")
+			return false, seen
+		}
 
 		// validate the transactions in the block
 		for _, tr := range bl.Transactions {
@@ -394,29 +696,71 @@ func (c *Chain) validate(blocks *[]Block) (valid bool, seen map[string]bool) {
 			seen[tr.Header.VoteToken] = true
 		}
 
-		valid, hash := bl.validate(parent)
-
-		if !valid {
+		if !c.validateBlock(*blocks, from+i) {
 			log.Println("Invalid chain - bad hash of block to parent")
 			return false, seen
 		}
-		parent = hash
 	}
 	return true, seen
 }
 
+// blockFits returns true if the block has no more transactions
+// and bytes of transactions than the chain parameters allow.
+func (c *Chain) blockFits(bl *Block) bool {
+
+	if len(bl.Transactions) > c.conf.Params.BlockSize {
+		return false
+	}
+	size := 0
+	for i := range bl.Transactions {
+		size += bl.Transactions[i].size()
+	}
+	return size <= c.conf.Params.MaxBlockBytes
+}
+
+// validateBlock will check the block at index i of a chain against
+// its parent, using the Consensus of the chain.
+func (c *Chain) validateBlock(blocks []Block, i int) bool {
+
+	if blocks[i].Header.MerkleHash != merkleHash(blocks[i].Transactions) {
+		return false
+	}
+	return c.validateHeader(blocks, i)
+}
+
+// validateHeader will check the header of the block at index i of
+// a chain against its parent, without needing its transactions.
+// The seal of the block is checked by the Consensus of the chain.
+func (c *Chain) validateHeader(blocks []Block, i int) bool {
+
+	h := &blocks[i].Header
+	if h.Version != headerVersion || h.Height != uint32(i) || h.ParentHash != blocks[i-1].Proof {
+		return false
+	}
+	return c.consensus.VerifySeal(blocks, i)
+}
+
+// seenIn returns the vote tokens of the transactions in blocks.
+// The blocks are not validated.
+func seenIn(blocks []Block) (seen map[string]bool) {
+	seen = make(map[string]bool, 0)
+	for _, bl := range blocks {
+		for _, tr := range bl.Transactions {
+			seen[tr.Header.VoteToken] = true
+		}
+	}
+	return seen
+}
+
 // TODO: check the chain in reverse order ie. most
 // recent blocks first: hypothesis is that if a
 // transaction has been seen before, it will be
 // seen more recently.
 func (c *Chain) contains(t *Transaction) bool {
-	blocks := <-c.blocks
-	for _, b := range blocks {
+	for _, b := range c.blocks() {
 		if b.contains(t) {
-			c.blocks <- blocks
 			return true
 		}
 	}
-	c.blocks <- blocks
 	return false
 }
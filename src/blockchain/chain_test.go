@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPartialBlocks(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	p := c.conf.Params
+	wait := time.Duration(p.MaxBlockWait) * time.Second
+	size := NewMerkleTestTransactions(1)[0].size()
+
+	var tests = []struct {
+		name     string
+		pending  int
+		waited   time.Duration
+		wait     int
+		bytes    int
+		end      uint32
+		flush    bool
+		expected int
+	}{
+		{"empty pool", 0, wait, 0, 0, 0, true, 0},
+		{"full block", p.BlockSize + 1, 0, 0, 0, 0, false, p.BlockSize},
+		{"partial block", p.BlockSize - 1, 0, 0, 0, 0, false, 0},
+		{"partial block after waiting", p.BlockSize - 1, wait, 0, 0, 0, false, p.BlockSize - 1},
+		{"configured wait", 1, 10 * time.Second, 5, 0, 0, false, 1},
+		{"before configured wait", 1, 10 * time.Second, 20, 0, 0, false, 0},
+		{"block full of bytes", 3, 0, 0, 2*size + 1, 0, false, 2},
+		{"flushed pool", 1, 0, 0, 0, 0, true, 1},
+		{"flushed full block", p.BlockSize + 1, 0, 0, 0, 0, true, p.BlockSize},
+		{"election closed", 1, 0, 0, 0, now - 10, false, 1},
+		{"election open", 1, 0, 0, 0, now + 100, false, 0},
+	}
+
+	for _, test := range tests {
+		c.conf.Params = p
+		if test.wait > 0 {
+			c.conf.Params.MaxBlockWait = test.wait
+		}
+		if test.bytes > 0 {
+			c.conf.Params.MaxBlockBytes = test.bytes
+		}
+		c.conf.VotingEnd = test.end
+		var flushed int32
+		if test.flush {
+			flushed = 1
+		}
+		atomic.StoreInt32(&c.state.flushed, flushed)
+		pool := NewMerkleTestTransactions(test.pending)
+		if n := c.readyTransactions(pool, test.waited); n != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "transactions, got", n, "\n")
+		}
+	}
+
+	// a flushed pool mines partial blocks without waiting
+	c.conf.Params = p
+	atomic.StoreInt32(&c.state.flushed, 0)
+	c.conf.VotingEnd = 0
+	c.Flush()
+	if n := c.readyTransactions(NewMerkleTestTransactions(1), 0); n != 1 {
+		t.Error("Expected a flushed pool to mine a partial block, got", n, "\n")
+	}
+}
+
+func TestRunAndStop(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Params.HashingDelay = 1
+	conf.Params.MaxBlockWait = 1
+	store := NewMemoryStore()
+	c, err := NewTestChainFrom(conf, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- c.Run(context.Background())
+	}()
+
+	// a vote is mined while the chain runs
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReceiveTransaction(tr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitForTestBlocks(c, 2) {
+		t.Error("Expected a block to be mined while the chain runs\n")
+	}
+
+	// a vote which has not been mined is kept when the chain stops
+	tr, err = NewTestTransactionFor(c, otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReceiveTransaction(tr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Stop(); err != nil {
+		t.Error("Expected the chain to stop, got", err, "\n")
+	}
+	select {
+	case err = <-stopped:
+		if err != nil {
+			t.Error("Expected Run to return no error, got", err, "\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return once the chain was stopped")
+	}
+
+	pool, err := store.Pool()
+	blocks := c.blocks()
+	if err != nil || len(blocks)+len(pool) != 3 {
+		t.Error("Expected the pending vote to be in the chain or the saved pool, got",
+			len(blocks), "blocks and", len(pool), "pending\n")
+	}
+
+	if err = c.Run(context.Background()); err != ChainRanError {
+		t.Error("Expected", ChainRanError, "when running a chain again, got", err, "\n")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		stop func(c *Chain, cancel context.CancelFunc) error
+	}{
+		{"cancelled context", func(c *Chain, cancel context.CancelFunc) error {
+			cancel()
+			return nil
+		}},
+		{"stopped before running", func(c *Chain, cancel context.CancelFunc) error {
+			if err := c.Stop(); err != ChainNotRunningError {
+				return err
+			}
+			return nil
+		}},
+	}
+
+	for _, test := range tests {
+		c, err := NewTestChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if err = test.stop(c, cancel); err != nil {
+			t.Error("For input", test.name, "expected", ChainNotRunningError, "got", err, "\n")
+		}
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- c.Run(ctx)
+		}()
+		select {
+		case err = <-stopped:
+			if err != nil {
+				t.Error("For input", test.name, "expected Run to return no error, got", err, "\n")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("For input", test.name, "expected Run to return\n")
+		}
+		cancel()
+	}
+}
+
+// WaitForTestBlocks waits for the chain of c to have n blocks, and
+// returns false if it does not within a few seconds.
+func WaitForTestBlocks(c *Chain, n int) bool {
+	timeout := time.After(10 * time.Second)
+	for len(c.blocks()) < n {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return true
+}
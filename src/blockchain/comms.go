@@ -3,22 +3,24 @@ package blockchain
 import (
 	"crypto/dsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/CPSSD/voting/src/crypto"
 	"github.com/CPSSD/voting/src/election"
 	"io/ioutil"
 	"log"
 	"math/big"
-	"net"
-	"net/http"
-	"net/rpc"
-	"reflect"
+)
+
+var (
+	InvalidKeyShareError  = errors.New("Key share does not match the commitments of the election key.")
+	AlreadyListeningError = errors.New("Chain is already listening for RPC calls.")
 )
 
 // Configuration contains information about our node, along with
 // information about peers in the network, VoteTokens for the
 // election, and information required for reconstruction of the
-// election private key.
+// election private key, or for threshold decryption of the tally.
 type Configuration struct {
 	MyAddr    string
 	MyPort    string
@@ -30,12 +32,90 @@ type Configuration struct {
 	VoteTokens map[string]dsa.PublicKey
 	MyToken    string
 
+	// ElectionID identifies the election, so that transactions
+	// can not be replayed onto the chain of another election.
+	ElectionID     string
 	ElectionFormat election.Format
 
+	// The genesis block contains the manifest of the election,
+	// and replaces the election information in this configuration
+	// when it is loaded. Its hash should be published, so that
+	// nodes can check that the genesis block has not been changed.
+	GenesisFile string
+	GenesisHash string
+
+	// Votes are only accepted with timestamps between VotingStart
+	// and VotingEnd, unless VotingEnd is 0.
+	VotingStart uint32
+	VotingEnd   uint32
+
+	// Consensus is the mode which decides which nodes may add
+	// blocks to the chain, either ProofOfWork or ProofOfAuthority.
+	// With proof of authority, blocks are signed in turn by the
+	// keys in Authorities, and AuthorityKey is this node's key if
+	// it is one of them.
+	Consensus    string
+	Authorities  []dsa.PublicKey
+	AuthorityKey dsa.PrivateKey
+
+	// Params are the chain parameters of the election, which are
+	// replaced by the ones in the manifest of the genesis block.
+	// If they are not set, DefaultChainParams are used.
+	Params ChainParams
+
+	// MiningWorkers is the number of goroutines which search for
+	// proofs of work, or one for each processor if it is zero.
+	MiningWorkers int
+
+	// StoreDir is the directory where the chain is kept, so that it
+	// survives a restart. If it is empty, the chain is only kept
+	// in memory.
+	StoreDir string
+
 	ElectionKey           crypto.PrivateKey
 	ElectionKeyShare      ElectionSecret
 	ElectionLambdaModulus *big.Int
 	ElectionMuModulus     *big.Int
+
+	// Public commitments to the polynomials used to divide the
+	// election key, which allow key shares to be verified.
+	ElectionLambdaCommitments *crypto.Commitments
+	ElectionMuCommitments     *crypto.Commitments
+
+	// In threshold decryption mode, the election key is never
+	// reconstructed. Instead each node holds a share of the key
+	// which is only used to partially decrypt the final tally.
+	ThresholdDecryption     bool
+	ElectionDecryptionShare crypto.Share
+	ElectionThreshold       int
+	ElectionParties         int
+
+	// Public verification keys of each share of the election key,
+	// which allow the partial decryptions of the tally to be
+	// checked. The key of the share with X value i is at i-1.
+	ElectionVerificationKeys []*big.Int
+
+	// In distributed key generation mode, the election key is
+	// created by the trustees together, so that no party ever
+	// holds Lambda or Mu. Trustees are listed by address, and sign
+	// their key generation messages with their PrivateKey, whose
+	// public keys are listed in the same order in TrusteeKeys.
+	DistributedKeyGeneration bool
+	Trustees                 []string
+	TrusteeKeys              []dsa.PublicKey
+	KeyGenerationBits        int
+
+	// Nodes are the fingerprints of the certificates of the nodes
+	// registered for the election, which are part of its manifest.
+	// If TLSCertificate and TLSKey are set, the node connects to its
+	// peers over TLS, and only to peers which present one of these
+	// certificates. If RequireRegisteredPeers is also set, only
+	// registered nodes may connect to this node, and otherwise other
+	// clients may only submit transactions and read the chain.
+	Nodes                  []string
+	TLSCertificate         string
+	TLSKey                 string
+	RequireRegisteredPeers bool
 }
 
 // ElectionSecret contains two shares which are required in the
@@ -69,12 +149,18 @@ func (c *Chain) GetVoteToken() string {
 	return c.conf.MyToken
 }
 
+// UsesThresholdDecryption returns whether the election tally
+// is decrypted with partial tallies rather than by reconstructing
+// the election key.
+func (c *Chain) UsesThresholdDecryption() bool {
+	return c.conf.ThresholdDecryption
+}
+
 // CollectBallots will gather all the ballots from the current
 // chain and return them.
 func (c *Chain) CollectBallots() *[]election.Ballot {
 	log.Println("Gathering ballots from the chain")
-	blocks := <-c.blocks
-	c.blocks <- blocks
+	blocks := c.blocks()
 
 	ballots := make([]election.Ballot, 0)
 
@@ -91,35 +177,44 @@ func (c *Chain) CollectBallots() *[]election.Ballot {
 // ReceiveTransaction is an RPC function which allows a node to
 // recieve transactions from the network.
 func (c *Chain) ReceiveTransaction(t *Transaction, _ *struct{}) (err error) {
-	pool := <-c.TransactionPool
-	seen := <-c.SeenTrs
 
 	if valid := c.ValidateSignature(t); !valid {
 		log.Println("Received a new transaction with invalid signature")
-		c.SeenTrs <- seen
-		c.TransactionPool <- pool
 		return nil
 	}
-	// if the tr was seen in our chain, then don't add it
-	if _, ok := seen[t.Header.VoteToken]; ok {
-		c.SeenTrs <- seen
-		c.TransactionPool <- pool
-		return nil
+	if t.size() > c.conf.Params.MaxBlockBytes {
+		log.Println("Received a new transaction which is too large for a block")
+		return TransactionTooLargeError
 	}
 
-	// if the tr is in our pool, don't add it
-	for _, tr := range pool {
-		if reflect.DeepEqual(t.Header.VoteToken, tr.Header.VoteToken) {
-			c.SeenTrs <- seen
-			c.TransactionPool <- pool
+	added := false
+	err = c.update(func(tx *stateTx) error {
+
+		// if the tr was seen in our chain, then don't add it
+		if _, ok := tx.Seen[t.Header.VoteToken]; ok {
 			return nil
 		}
-	}
 
-	log.Println("We received a new transaction")
-	pool = append(pool, *t)
-	c.SeenTrs <- seen
-	c.TransactionPool <- pool
+		// if the tr is in our pool, don't add it
+		for _, tr := range tx.Pool {
+			if tr.Header.VoteToken == t.Header.VoteToken {
+				return nil
+			}
+		}
+
+		if len(tx.Pool) >= c.conf.Params.MaxPoolSize {
+			log.Println("Received a new transaction, but the pool is full")
+			return PoolFullError
+		}
+
+		log.Println("We received a new transaction")
+		tx.setPool(append(tx.Pool[:len(tx.Pool):len(tx.Pool)], *t))
+		added = true
+		return nil
+	})
+	if err != nil || !added {
+		return err
+	}
 
 	go c.SendTransaction(t)
 
@@ -131,60 +226,60 @@ type ChainUpdate struct {
 	Blocks []Block
 }
 
-func (c *Chain) sendKeyShareTo(share *ElectionSecret, peer string) {
-	log.Println("Opening connection to ", peer)
-	conn, err := rpc.DialHTTP("tcp", peer)
-	if err != nil {
-		return
-	}
-	shareCall := conn.Go("Chain.ReceiveKeyShare", share, nil, nil)
-	_ = <-shareCall.Done
-	conn.Close()
-	log.Println("Done sending key, connection closed with", peer)
-}
-
 // ReceiveKeyShare is an RPC function which allows a node to
 // receive a share of the private key from other nodes.
 func (c *Chain) ReceiveKeyShare(share *ElectionSecret, _ *struct{}) (err error) {
-	log.Println("Received a key share, writing to respective channel")
+	log.Println("Received a key share")
+	if !c.hasGenesis() {
+		return NoGenesisError
+	}
+	if !c.verifyShare(share) {
+		log.Println("Rejected a forged key share")
+		return InvalidKeyShareError
+	}
 	c.addShare(*share)
-	log.Println("Written key share to channel")
 	return
 }
 
-func (c *Chain) getChainUpdateFrom(peer string) (altChain *[]Block, err error) {
-	conn, err := rpc.DialHTTP("tcp", peer)
-	if err != nil {
-		return altChain, err
-	}
-	empty := true
-	altChain = new([]Block)
-	err = conn.Call("Chain.GetChain", empty, &altChain)
-	if err != nil {
-		return altChain, err
+// verifyShare checks that both parts of a key share are
+// consistent with the commitments of the election key. If
+// the configuration is missing either of the commitments, then
+// shares cannot be verified and are rejected.
+func (c *Chain) verifyShare(share *ElectionSecret) (valid bool) {
+	if c.conf.ElectionLambdaCommitments == nil || c.conf.ElectionMuCommitments == nil {
+		log.Println("No commitments available to verify key share")
+		return false
 	}
-	conn.Close()
-	return altChain, err
+	return crypto.VerifyShare(share.Lambda, c.conf.ElectionLambdaCommitments) &&
+		crypto.VerifyShare(share.Mu, c.conf.ElectionMuCommitments)
 }
 
-// GetChain is an RPC call which will set the value of altChain
-// to the value of this node's current set of blocks. The value
-// of empty is unused.
-func (c *Chain) GetChain(empty bool, altChain *[]Block) error {
-	b := <-c.blocks
-	c.blocks <- b
-
-	*altChain = b
+// ReceivePartialTally is an RPC function which allows a node to
+// receive a partial decryption of the tally from other nodes. It
+// is only kept if it is a partial decryption of the ballots in the
+// chain, with a valid proof for the verification key of its share.
+func (c *Chain) ReceivePartialTally(pt *election.PartialTally, _ *struct{}) (err error) {
+	log.Println("Received a partial tally")
+	if !c.hasGenesis() {
+		return NoGenesisError
+	}
+	err = c.conf.ElectionFormat.VerifyPartialTally(c.CollectBallots(), &c.conf.ElectionKey.PublicKey,
+		pt, c.conf.ElectionVerificationKeys, c.conf.ElectionParties)
+	if err != nil {
+		log.Println("Rejected an invalid partial tally")
+		return err
+	}
+	c.addPartialTally(*pt)
 	return nil
 }
 
 // BlockUpdate contains the latest block, along with details of
-// the peer who created it and the length of the chain which it
-// was added to.
+// the peer who created it and the total work of the chain which
+// it was added to.
 type BlockUpdate struct {
 	LatestBlock Block
 	Peer        string
-	ChainLength uint32
+	ChainWork   *big.Int
 }
 
 // ReceiveBlockUpdate is an RPC function which allows a node
@@ -192,38 +287,24 @@ type BlockUpdate struct {
 func (c *Chain) ReceiveBlockUpdate(blu *BlockUpdate, _ *struct{}) (err error) {
 
 	log.Println("Received block update, writing to respective channel")
-	c.BlockUpdate <- *blu
+	select {
+	case c.BlockUpdate <- *blu:
+	case <-c.quit:
+		return ChainNotRunningError
+	}
 	return
 }
 
 func (c *Chain) sendBlock(bl *Block) {
 
 	log.Println("Sending block to peers")
-	peers := <-c.Peers
-	c.Peers <- peers
-	blocks := <-c.blocks
-	c.blocks <- blocks
 	update := &BlockUpdate{
 		LatestBlock: *bl,
 		Peer:        c.conf.MyAddr + c.conf.MyPort,
-		ChainLength: uint32(len(blocks)),
+		ChainWork:   c.chainWork(c.blocks()),
 	}
 
-	for k, _ := range peers {
-		if k == c.conf.MyAddr+c.conf.MyPort {
-			continue
-		}
-
-		conn, err := rpc.DialHTTP("tcp", k)
-		if err != nil {
-			continue
-		}
-		go func() {
-			blCall := conn.Go("Chain.ReceiveBlockUpdate", update, nil, nil)
-			_ = <-blCall.Done
-			conn.Close()
-		}()
-	}
+	c.transport.Broadcast(c.otherPeers(), "Chain.ReceiveBlockUpdate", update)
 	log.Println("Done sending block to peers")
 	return
 }
@@ -233,25 +314,7 @@ func (c *Chain) sendBlock(bl *Block) {
 func (c *Chain) SendTransaction(tr *Transaction) {
 
 	log.Println("Sending transaction to peers")
-	peers := <-c.Peers
-
-	for k, _ := range peers {
-		if k == c.conf.MyAddr+c.conf.MyPort {
-			continue
-		}
-
-		conn, err := rpc.DialHTTP("tcp", k)
-		if err != nil {
-			continue
-		}
-		go func() {
-			trCall := conn.Go("Chain.ReceiveTransaction", tr, nil, nil)
-			_ = <-trCall.Done
-			conn.Close()
-		}()
-	}
-
-	c.Peers <- peers
+	c.transport.Broadcast(c.otherPeers(), "Chain.ReceiveTransaction", tr)
 	log.Println("Done sending transaction to peers")
 	return
 }
@@ -266,36 +329,107 @@ func (c *Chain) BroadcastShare() {
 
 func (c *Chain) addShare(sh ElectionSecret) (exists bool) {
 
-	shares := <-c.KeyShares
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
 	var ok bool
-	if _, ok := shares[sh.Lambda.X.String()]; !ok {
-		shares[sh.Lambda.X.String()] = sh
+	if _, ok := c.state.keyShares[sh.Lambda.X.String()]; !ok {
+		c.state.keyShares[sh.Lambda.X.String()] = sh
 		log.Println("Added a new share:", sh.Lambda.X.String())
 	}
-	c.KeyShares <- shares
 	return ok
 }
 
+// BroadcastPartialTally will partially decrypt the tally of the
+// ballots currently in the chain using this node's share of the
+// election key, and add it to the pool of partial tallies which
+// are broadcast regularly.
+func (c *Chain) BroadcastPartialTally() (err error) {
+
+	log.Println("Broadcasting our partial decryption of the tally")
+	pt, err := c.conf.ElectionFormat.PartialTally(c.CollectBallots(),
+		&c.conf.ElectionKey.PublicKey, c.conf.ElectionDecryptionShare)
+	if err != nil {
+		return err
+	}
+	c.addPartialTally(*pt)
+	return nil
+}
+
+func (c *Chain) addPartialTally(pt election.PartialTally) {
+
+	if pt.X == nil {
+		return
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	// keep only the most recent partial tally from each share,
+	// as the chain may have grown since the last one was made.
+	// Received partial tallies are verified before they are
+	// added, so a valid one is never replaced by a forgery.
+	c.state.partialTallies[pt.X.String()] = pt
+	log.Println("Added a partial tally:", pt.X.String())
+}
+
+// ThresholdTally combines the partial tallies known to the node
+// to calculate the tally of the ballots currently in the chain.
+func (c *Chain) ThresholdTally() (t *election.Tally, err error) {
+
+	partials := c.partialTallies()
+
+	pts := make([]election.PartialTally, 0, len(partials))
+	for _, pt := range partials {
+		pts = append(pts, pt)
+	}
+
+	return c.conf.ElectionFormat.ThresholdTally(c.CollectBallots(), &c.conf.ElectionKey.PublicKey,
+		pts, c.conf.ElectionVerificationKeys, c.conf.ElectionThreshold, c.conf.ElectionParties)
+}
+
+// VerifyTally checks that the Tally t is the correct decryption of
+// the ballots currently in the chain, using the proofs it contains
+// and the public election key. It can be run by any node, as it
+// does not need the private key or a share of it.
+func (c *Chain) VerifyTally(t *election.Tally) (err error) {
+	return c.conf.ElectionFormat.VerifyTally(c.CollectBallots(), &c.conf.ElectionKey.PublicKey,
+		t, c.conf.ElectionVerificationKeys, c.conf.ElectionThreshold, c.conf.ElectionParties)
+}
+
+func (c *Chain) broadcastPartialTallies() {
+
+	partials := c.partialTallies()
+
+	if len(partials) == 0 {
+		return
+	}
+
+	peers := c.otherPeers()
+
+	log.Println("Broadcasting our known partial tallies")
+	for _, pt := range partials {
+		pt := pt
+		log.Println("Broadcasting partial tally", pt.X, "to peers:", peers)
+		c.transport.Broadcast(peers, "Chain.ReceivePartialTally", &pt)
+	}
+}
+
 func (c *Chain) broadcastKeyShares() {
 
-	shares := <-c.KeyShares
-	c.KeyShares <- shares
+	shares := c.keyShares()
 
 	if len(shares) == 0 {
 		return
 	}
 
-	peers := <-c.Peers
-	c.Peers <- peers
+	peers := c.otherPeers()
 
 	log.Println("Broadcasting our known shares")
 	for _, s := range shares {
-		for p, _ := range peers {
-			if p != c.conf.MyAddr+c.conf.MyPort {
-				log.Println("Broadcasting share", s, "to peer:", p)
-				c.sendKeyShareTo(&s, p)
-			}
-		}
+		s := s
+		log.Println("Broadcasting share", s, "to peers:", peers)
+		c.transport.Broadcast(peers, "Chain.ReceiveKeyShare", &s)
 	}
 }
 
@@ -303,18 +437,25 @@ func (c *Chain) syncPeers() {
 
 	log.Println("Syncing peer list with peers")
 
-	peers := <-c.Peers
-	c.Peers <- peers
+	peers := c.peers()
 
 	for k, _ := range peers {
 		if k == c.conf.MyAddr+c.conf.MyPort {
 			continue
 		}
-		conn, err := rpc.DialHTTP("tcp", k)
+		conn, err := c.transport.Dial(k)
 		if err != nil {
 			continue
 		}
 
+		// peers which do not agree on the chain parameters are
+		// forgotten
+		if err = c.checkPeerParams(k, conn); err == ParamsMismatchError {
+			conn.Close()
+			c.removePeer(k)
+			continue
+		}
+
 		var newPeers map[string]bool
 
 		err = conn.Call("Chain.GetPeers", peers, &newPeers)
@@ -323,8 +464,7 @@ func (c *Chain) syncPeers() {
 			continue
 		}
 
-		_ = <-c.Peers
-		c.Peers <- newPeers
+		c.addPeers(newPeers)
 	}
 	log.Println("Done syncing peer list with peers")
 	return
@@ -333,8 +473,7 @@ func (c *Chain) syncPeers() {
 // PrintPeers displays the list of peers known to a node
 func (c *Chain) PrintPeers() {
 
-	peers := <-c.Peers
-	c.Peers <- peers
+	peers := c.peers()
 
 	fmt.Printf("Peers:\n")
 	for k, _ := range peers {
@@ -346,10 +485,7 @@ func (c *Chain) PrintPeers() {
 // not yet incorporated into the chain.
 func (c *Chain) PrintPool() {
 
-	pool := <-c.TransactionPool
-	c.TransactionPool <- pool
-
-	for _, tr := range pool {
+	for _, tr := range c.snapshot().Pool {
 		fmt.Println(tr)
 	}
 }
@@ -358,50 +494,102 @@ func (c *Chain) PrintPool() {
 // combine their peer lists for syncing.
 func (c *Chain) GetPeers(myPeers *map[string]bool, r *map[string]bool) error {
 
-	peers := <-c.Peers
-
-	for key, _ := range *myPeers {
-		peers[key] = true
-	}
-	*r = peers
-	c.Peers <- peers
+	c.addPeers(*myPeers)
+	*r = c.peers()
 
 	return nil
 }
 
 func (c *Chain) addPeer(p string) {
-	peers := <-c.Peers
-	peers[p] = true
-	c.Peers <- peers
+	c.addPeers(map[string]bool{p: true})
 }
 
 // Init will read in a configration file and set up
 // a new chain. The RPC functions are made available
-// during the call of this method.
+// during the call of this method, as with Listen.
 func (c *Chain) Init(filename string) (err error) {
 
 	log.Println("Reading configuration file")
 	bs, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
 	err = json.Unmarshal(bs, &c.conf)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	c.Peers <- c.conf.Peers
+	if c.conf.Params == (ChainParams{}) {
+		c.conf.Params = DefaultChainParams()
+	}
+	if err = c.conf.ElectionFormat.Validate(); err != nil {
+		return err
+	}
+	c.addPeers(c.conf.Peers)
 	c.addPeer(c.conf.MyAddr + c.conf.MyPort)
 
-	rpc.Register(c)
-	rpc.HandleHTTP()
+	if c.conf.StoreDir != "" {
+		log.Println("Opening the block store")
+		c.store, err = NewFileStore(c.conf.StoreDir)
+		if err != nil {
+			return err
+		}
+	}
 
-	ln, err := net.Listen("tcp", c.conf.MyPort)
+	err = c.initGenesis()
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+
+	if c.conf.TLSCertificate != "" {
+		log.Println("Authenticating peers over TLS")
+		t, err := NewTLSTransport(c.conf.TLSCertificate, c.conf.TLSKey,
+			c.conf.Nodes, c.conf.RequireRegisteredPeers)
+		if err != nil {
+			return err
+		}
+		c.SetTransport(t)
 	}
 
-	go http.Serve(ln, nil)
+	return c.Listen(c.conf.MyPort)
+}
+
+// Listen will make the RPC functions of the chain available on the
+// address addr with its Transport. Each chain has its own RPC
+// server, so that several chains can be served in one process. The
+// listener is closed when the chain stops running.
+func (c *Chain) Listen(addr string) (err error) {
 
+	if c.listener != nil {
+		return AlreadyListeningError
+	}
+	c.listener, err = c.transport.Serve(addr, c)
 	return err
 }
+
+// Addr returns the address which the chain is listening on, or an
+// empty string if it is not listening.
+func (c *Chain) Addr() string {
+	if c.listener == nil {
+		return ""
+	}
+	return c.listener.Addr()
+}
+
+// SetTransport will make the chain communicate with its peers over
+// the Transport t, rather than over HTTP. It must be called before
+// the chain listens or runs.
+func (c *Chain) SetTransport(t Transport) {
+	c.transport = t
+}
+
+// otherPeers returns the addresses of the peers known to the node,
+// other than its own.
+func (c *Chain) otherPeers() (peers []string) {
+	for p := range c.peers() {
+		if p != c.conf.MyAddr+c.conf.MyPort {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
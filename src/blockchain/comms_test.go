@@ -0,0 +1,258 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/CPSSD/voting/src/crypto"
+	"github.com/CPSSD/voting/src/election"
+	"io/ioutil"
+	"math/big"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultipleChains(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "chains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each chain serves its own election in the same process
+	ids := []string{"first", "second"}
+	chains := make([]*Chain, 0, len(ids))
+	for _, id := range ids {
+		c, err := NewTestChainConfigured(filepath.Join(dir, id+".json"), func(conf *Configuration) {
+			conf.ElectionID = id
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chains = append(chains, c)
+	}
+
+	stopped := make(chan error, len(chains))
+	for _, c := range chains {
+		go func(c *Chain) {
+			stopped <- c.Run(context.Background())
+		}(c)
+	}
+
+	for i, c := range chains {
+		var reply ParamsReply
+		if err = CallTestChain(c.Addr(), "Chain.GetParams", &struct{}{}, &reply); err != nil {
+			t.Error("For input", ids[i], "expected the chain to serve RPC calls, got", err, "\n")
+		} else if reply.GenesisHash != c.GenesisHash() {
+			t.Error("For input", ids[i], "expected the chain's own genesis block\n")
+		}
+	}
+	if chains[0].GenesisHash() == chains[1].GenesisHash() {
+		t.Error("Expected the chains to be for different elections\n")
+	}
+
+	// the listener of a chain is closed when it stops
+	for i, c := range chains {
+		if err = c.Stop(); err != nil {
+			t.Error("For input", ids[i], "expected the chain to stop, got", err, "\n")
+		}
+		<-stopped
+		var reply ParamsReply
+		if err = CallTestChain(c.Addr(), "Chain.GetParams", &struct{}{}, &reply); err == nil {
+			t.Error("For input", ids[i], "expected the chain to stop serving RPC calls\n")
+		}
+	}
+}
+
+func TestReceivePartialTally(t *testing.T) {
+
+	// the election key is divided into three shares, any two of
+	// which decrypt the tally
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := conf.ElectionKey.DivideKey(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range shares {
+		vk, err := conf.ElectionKey.PublicKey.VerificationKey(share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf.ElectionVerificationKeys = append(conf.ElectionVerificationKeys, vk)
+	}
+	conf.ThresholdDecryption = true
+	conf.ElectionThreshold, conf.ElectionParties = 2, 3
+
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = AddTestBlock(c, testToken); err != nil {
+		t.Fatal(err)
+	}
+	pts := make([]election.PartialTally, len(shares))
+	for i, share := range shares {
+		pt, err := c.conf.ElectionFormat.PartialTally(c.CollectBallots(), &c.conf.ElectionKey.PublicKey, share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pts[i] = *pt
+	}
+
+	// a forgery of the first share's partial tally
+	forged := pts[0]
+	forged.Partials = make(map[string]crypto.PartialDecryption, 0)
+	for name, p := range pts[0].Partials {
+		p.Value = new(big.Int).Add(p.Value, big.NewInt(1))
+		forged.Partials[name] = p
+	}
+
+	var tests = []struct {
+		name     string
+		pt       election.PartialTally
+		expected error
+	}{
+		{"honest partial tally", pts[0], nil},
+		{"forged partial tally", forged, election.InvalidPartialTallyError},
+		{"other share", pts[1], nil},
+	}
+
+	for _, test := range tests {
+		if err = c.ReceivePartialTally(&test.pt, nil); err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+		}
+	}
+
+	// the forgery did not replace the honest partial tally, so the
+	// tally can be calculated
+	kept := c.partialTallies()["1"].Partials["yes"]
+	if kept.Value.Cmp(pts[0].Partials["yes"].Value) != 0 {
+		t.Error("Expected the honest partial tally to be kept\n")
+	}
+	tally, err := c.ThresholdTally()
+	if err != nil || tally.Totals["yes"].Int64() != 1 || tally.Totals["no"].Int64() != 0 {
+		t.Error("Expected the tally to be calculated from the honest partial tallies, got", err, "\n")
+	}
+}
+
+func TestReceiveKeyShare(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := NewTestKeyShares(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTestKeyShares(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		share    ElectionSecret
+		commit   bool
+		expected error
+	}{
+		{"valid share", shares[0], true, nil},
+		{"forged lambda", ElectionSecret{Lambda: other[1].Lambda, Mu: shares[1].Mu}, true, InvalidKeyShareError},
+		{"forged mu", ElectionSecret{Lambda: shares[1].Lambda, Mu: other[1].Mu}, true, InvalidKeyShareError},
+		{"changed value", ElectionSecret{
+			Lambda: crypto.Share{X: shares[1].Lambda.X, Y: new(big.Int).Add(shares[1].Lambda.Y, big.NewInt(1))},
+			Mu:     shares[1].Mu,
+		}, true, InvalidKeyShareError},
+		{"missing commitments", shares[2], false, InvalidKeyShareError},
+	}
+
+	for _, test := range tests {
+		if !test.commit {
+			c.conf.ElectionMuCommitments = nil
+		}
+		if err = c.ReceiveKeyShare(&test.share, nil); err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+		}
+	}
+	if known := c.keyShares(); len(known) != 1 {
+		t.Error("Expected only the valid share to be kept, got", len(known), "shares\n")
+	}
+}
+
+// NewTestChainConfigured returns a chain set up with Init from a
+// configuration file at path, with a test configuration changed by
+// change, which listens on a local port.
+func NewTestChainConfigured(path string, change func(conf *Configuration)) (c *Chain, err error) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	conf.MyPort = "127.0.0.1:0"
+	change(&conf)
+
+	bs, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(path, bs, 0600); err != nil {
+		return nil, err
+	}
+
+	c, err = NewChain()
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Init(path)
+}
+
+// CallTestChain calls the RPC function method of the chain at addr.
+func CallTestChain(addr, method string, args, reply interface{}) (err error) {
+
+	conn, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	call := conn.Go(method, args, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(5 * time.Second):
+		return rpc.ErrShutdown
+	}
+}
+
+// NewTestKeyShares divides the election key of conf into n shares,
+// any two of which reconstruct it, and sets the commitments of conf
+// which the shares are verified against.
+func NewTestKeyShares(conf *Configuration, n int) (shares []ElectionSecret, err error) {
+
+	key := &conf.ElectionKey
+	lambdas, lambdaModulus, lambdaCommitments, err := crypto.DivideSecretVerifiable(key.Lambda, 2, n)
+	if err != nil {
+		return nil, err
+	}
+	mus, muModulus, muCommitments, err := crypto.DivideSecretVerifiable(key.Mu, 2, n)
+	if err != nil {
+		return nil, err
+	}
+	conf.ElectionLambdaModulus, conf.ElectionLambdaCommitments = lambdaModulus, lambdaCommitments
+	conf.ElectionMuModulus, conf.ElectionMuCommitments = muModulus, muCommitments
+
+	for i := range lambdas {
+		shares = append(shares, ElectionSecret{Lambda: lambdas[i], Mu: mus[i]})
+	}
+	return shares, nil
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	UnknownConsensusError  = errors.New("Unknown consensus mode.")
+	ConsensusMismatchError = errors.New("Consensus of the chain does not match the election manifest.")
+)
+
+// The consensus modes which decide which nodes may add blocks to
+// the chain. With proof of work, any node which finds a proof of
+// work for a block may add it. With proof of authority, blocks
+// are instead signed by one of the election's authorities, who
+// take turns. An empty mode is proof of work.
+const (
+	ProofOfWork      = "pow"
+	ProofOfAuthority = "poa"
+)
+
+// Consensus decides how blocks are sealed, so that they can be
+// added to the chain, how their seals are verified, and which of
+// several chains is chosen. The chain with the most total Work is
+// always chosen.
+type Consensus interface {
+	// Name returns the consensus mode, which must match the mode
+	// in the election manifest.
+	Name() string
+
+	// CanSeal returns true if this node may seal blocks.
+	CanSeal() bool
+
+	// Seal will seal the block b, whose transactions and parent
+	// are set, on top of the chain of blocks. A signal may be
+	// received on the channel stop to indicate that the function
+	// should exit early, in which case stopped is true.
+	Seal(b *Block, blocks []Block, stop chan bool) (stopped bool)
+
+	// VerifySeal checks the seal of the block at index i of the
+	// chain of blocks, whose earlier blocks are already valid. The
+	// parent hash and transactions of the block are checked by
+	// the chain. Only the headers, proofs and signatures of the
+	// blocks may be set.
+	VerifySeal(blocks []Block, i int) bool
+
+	// Work returns the work of the block with header h at the
+	// given height of the chain.
+	Work(h *BlockHeader, height int) *big.Int
+}
+
+// newConsensus returns the Consensus for the mode of the election
+// described by the configuration.
+func newConsensus(conf *Configuration) (cons Consensus, err error) {
+	switch conf.Consensus {
+	case "", ProofOfWork:
+		return &WorkConsensus{Workers: conf.MiningWorkers, Difficulty: conf.Params.Difficulty}, nil
+	case ProofOfAuthority:
+		return NewAuthorityConsensus(conf.Authorities, &conf.AuthorityKey)
+	}
+	return nil, UnknownConsensusError
+}
+
+// setConsensus will set the Consensus of the chain for the
+// election described by the configuration, unless one was given
+// when the chain was created, in which case it is checked that
+// its mode matches the election's.
+func (c *Chain) setConsensus() (err error) {
+
+	if c.customConsensus {
+		mode := c.conf.Consensus
+		if mode == "" {
+			mode = ProofOfWork
+		}
+		if c.consensus.Name() != mode {
+			return ConsensusMismatchError
+		}
+		return nil
+	}
+
+	cons, err := newConsensus(&c.conf)
+	if err != nil {
+		return err
+	}
+	c.consensus = cons
+	return nil
+}
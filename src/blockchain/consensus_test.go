@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+// TestConsensus seals a block with the hash of its header, and
+// gives every block the same work.
+type TestConsensus struct{}
+
+func (t *TestConsensus) Name() string {
+	return ProofOfWork
+}
+
+func (t *TestConsensus) CanSeal() bool {
+	return true
+}
+
+func (t *TestConsensus) Seal(b *Block, blocks []Block, stop chan bool) (stopped bool) {
+	b.getMerkleHash()
+	b.Proof = sha256.Sum256(append(b.Header.MerkleHash[:], b.Header.ParentHash[:]...))
+	return false
+}
+
+func (t *TestConsensus) VerifySeal(blocks []Block, i int) bool {
+	return t.VerifyBlockSeal(&blocks[i])
+}
+
+func (t *TestConsensus) VerifyBlockSeal(bl *Block) bool {
+	h := &bl.Header
+	return bl.Proof == sha256.Sum256(append(h.MerkleHash[:], h.ParentHash[:]...))
+}
+
+func (t *TestConsensus) Work(h *BlockHeader, height int) *big.Int {
+	return big.NewInt(1)
+}
+
+func TestCustomConsensus(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestChainWith(conf, NewMemoryStore(), new(TestConsensus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := c.blocks()
+
+	var tests = []struct {
+		name   string
+		tamper func(bl *Block)
+		valid  bool
+	}{
+		{"sealed block", func(bl *Block) {}, true},
+		{"changed seal", func(bl *Block) { bl.Proof[0]++ }, false},
+		{"proof of work", func(bl *Block) {
+			bl.createProof(maxTarget, 0, make(chan bool))
+		}, false},
+	}
+
+	for _, test := range tests {
+		tr, err := NewTestTransaction(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl := NewBlock()
+		bl.addTransaction(tr)
+		bl.setParent(&blocks[0])
+		c.consensus.Seal(bl, blocks, make(chan bool))
+		test.tamper(bl)
+
+		chain := []Block{blocks[0], *bl}
+		if valid, _ := c.validate(&chain); valid != test.valid {
+			t.Error("For input", test.name, "expected the block to be valid:", test.valid, "\n")
+		}
+		if test.valid && c.chainWork(chain).Cmp(big.NewInt(1)) != 0 {
+			t.Error("For input", test.name, "expected the work of the consensus\n")
+		}
+	}
+
+	// the consensus must match the mode of the election
+	conf.Consensus = ProofOfAuthority
+	if _, err = NewTestChainWith(conf, NewMemoryStore(), new(TestConsensus)); err != ConsensusMismatchError {
+		t.Error("Expected", ConsensusMismatchError, "got", err, "\n")
+	}
+}
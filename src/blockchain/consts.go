@@ -1,7 +1,46 @@
 package blockchain
 
+import (
+	"math"
+	"time"
+)
+
 var (
-	hashingDelay      = 5
-	blockSize         = 4
-	proofDifficultyBl = 5
+	// the target is changed every retargetInterval blocks, which
+	// must be at least 2, so that blocks take targetBlockTime to
+	// mine on average
+	retargetInterval = 10
+	targetBlockTime  = 30 * time.Second
+	maxTargetChange  = int64(4)
+	maxClockDrift    = 2 * time.Hour
+
+	// with proof of authority, each authority waits authorityDelay
+	// longer than the one before it when it is not its turn
+	authorityDelay = 10 * time.Second
+
+	// each mining worker checks whether it should stop every
+	// miningCheckInterval hashes, and increases its extra nonce
+	// when it has tried every nonce up to maxNonce
+	miningCheckInterval = uint32(1024)
+	maxNonce            = uint32(math.MaxUint32)
+
+	// a chain synced from a peer may be at most maxSyncBlocks
+	// blocks longer than the local chain, and is otherwise synced
+	// in several parts
+	syncHeadersPage = 500
+	syncBlocksPage  = 16
+	maxSyncBlocks   = 10000
+
+	// side blocks more than maxSideDepth blocks below the top of
+	// the chain are dropped, and at most maxSideBlocks are kept
+	maxSideDepth  = 100
+	maxSideBlocks = 1000
+
+	keyGenRetries    = 60
+	keyGenRetryDelay = time.Second
+	keyGenTimeout    = 30 * time.Minute
+
+	// the trustees test keyGenCandidates moduli at a time during
+	// key generation, or the default number if it is 0
+	keyGenCandidates = 0
 )
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"log"
+	"math/big"
+	"time"
+)
+
+// maxTarget is the largest possible target, which every hash meets.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// initialTarget returns the target of the first blocks of the
+// chain, before there are enough blocks to retarget. A hash meets
+// it if it starts with difficulty hex zero characters.
+func initialTarget(difficulty int) *big.Int {
+	return new(big.Int).Rsh(maxTarget, uint(4*difficulty))
+}
+
+// target returns the target which the proof of work of a block
+// with header h must not exceed.
+func (h *BlockHeader) target() *big.Int {
+	return new(big.Int).SetBytes(h.Target[:])
+}
+
+// encodeTarget returns the big-endian encoding of a target, as it
+// is stored in a BlockHeader.
+func encodeTarget(t *big.Int) (enc [32]byte) {
+	bs := t.Bytes()
+	copy(enc[32-len(bs):], bs)
+	return enc
+}
+
+// checkProof will check that a hash, read as a big-endian number,
+// does not exceed the target.
+func checkProof(hash [32]byte, target *big.Int) bool {
+	return target.Sign() > 0 && new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// nextTarget returns the target which the next block added to the
+// chain of blocks must have. Every retargetInterval blocks, the
+// target is scaled by how long the last retargetInterval blocks
+// took to mine, compared to targetBlockTime for each. The change
+// is limited to a factor of maxTargetChange, so that a few blocks
+// with wrong timestamps cannot move the target far. The first
+// blocks have the initial target for the difficulty. Only the
+// headers of the blocks are used.
+func nextTarget(blocks []Block, difficulty int) *big.Int {
+
+	// the genesis block has no target
+	n := len(blocks)
+	if n < 2 {
+		return initialTarget(difficulty)
+	}
+	prev := blocks[n-1].Header.target()
+	if n-1 < retargetInterval || (n-1)%retargetInterval != 0 {
+		return prev
+	}
+
+	first := blocks[n-retargetInterval].Header.Timestamp
+	last := blocks[n-1].Header.Timestamp
+	expected := int64(retargetInterval-1) * int64(targetBlockTime/time.Second)
+	actual := int64(last) - int64(first)
+	if actual < 0 {
+		actual = 0
+	}
+
+	next := new(big.Int).Mul(prev, big.NewInt(actual))
+	next.Div(next, big.NewInt(expected))
+	if min := new(big.Int).Div(prev, big.NewInt(maxTargetChange)); next.Cmp(min) < 0 {
+		next = min
+	}
+	if max := new(big.Int).Mul(prev, big.NewInt(maxTargetChange)); next.Cmp(max) > 0 {
+		next = max
+	}
+	if next.Cmp(maxTarget) > 0 {
+		next.Set(maxTarget)
+	}
+	if next.Sign() == 0 {
+		next.SetInt64(1)
+	}
+	return next
+}
+
+// headerWork returns the expected number of hashes which were
+// needed to find the proof of work for a block with header h.
+func headerWork(h *BlockHeader) *big.Int {
+	d := new(big.Int).Add(h.target(), big.NewInt(1))
+	return d.Div(new(big.Int).Lsh(big.NewInt(1), 256), d)
+}
+
+// WorkConsensus is the Consensus of proof of work, where any node
+// may seal a block by finding a proof of work for it which meets
+// the target expected at its place in the chain. The proof of work
+// is searched for by Workers goroutines, or one for each processor
+// if Workers is not positive. The first blocks of the chain have
+// the initial target for the Difficulty.
+type WorkConsensus struct {
+	Workers    int
+	Difficulty int
+}
+
+// Name returns ProofOfWork.
+func (w *WorkConsensus) Name() string {
+	return ProofOfWork
+}
+
+// CanSeal returns true, as any node may mine blocks.
+func (w *WorkConsensus) CanSeal() bool {
+	return true
+}
+
+// Seal will find a proof of work for the block b on top of the
+// chain of blocks. A signal may be received on the channel stop to
+// indicate that the function should exit early.
+func (w *WorkConsensus) Seal(b *Block, blocks []Block, stop chan bool) (stopped bool) {
+	if height := len(blocks); height > 1 {
+		b.Header.Timestamp = blocks[height-1].Header.Timestamp
+	}
+	return b.createProof(nextTarget(blocks, w.Difficulty), w.Workers, stop)
+}
+
+// VerifySeal will check that the block at index i of a chain has
+// the target expected at its place in the chain, and a proof of
+// work which meets it. The block may not have a timestamp before
+// its parent, so that the timestamps used to retarget can not be
+// moved back to make the target easier.
+func (w *WorkConsensus) VerifySeal(blocks []Block, i int) bool {
+	bl := &blocks[i]
+	if bl.Header.target().Cmp(nextTarget(blocks[:i], w.Difficulty)) != 0 {
+		log.Println("Block does not have the expected target")
+		return false
+	}
+	if i > 1 && bl.Header.Timestamp < blocks[i-1].Header.Timestamp {
+		log.Println("Block has a timestamp before its parent")
+		return false
+	}
+	valid, _ := bl.Header.validate(bl.Proof)
+	return valid
+}
+
+// Work returns the expected number of hashes which were needed to
+// find the proof of work for a block with header h.
+func (w *WorkConsensus) Work(h *BlockHeader, height int) *big.Int {
+	return headerWork(h)
+}
@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestNextTarget(t *testing.T) {
+
+	interval, blockTime := retargetInterval, targetBlockTime
+	retargetInterval = 10
+	defer func() { retargetInterval = interval }()
+
+	seconds := uint32(blockTime.Seconds())
+	target := initialTarget(testDifficulty)
+	half := new(big.Int).Rsh(target, 1)
+	double := new(big.Int).Lsh(target, 1)
+	quarter := new(big.Int).Rsh(target, 2)
+	quadruple := new(big.Int).Lsh(target, 2)
+
+	var tests = []struct {
+		name     string
+		length   int
+		spacing  uint32
+		target   *big.Int
+		expected *big.Int
+	}{
+		{"first block", 1, seconds, target, initialTarget(testDifficulty)},
+		{"before retargeting", 5, seconds / 2, target, target},
+		{"blocks on time", 11, seconds, target, target},
+		{"blocks twice as fast", 11, seconds / 2, target, half},
+		{"blocks twice as slow", 11, seconds * 2, target, double},
+		{"blocks far too fast", 11, 0, target, quarter},
+		{"blocks far too slow", 11, seconds * 100, target, quadruple},
+		{"after retargeting", 12, seconds / 2, target, target},
+		{"easiest target", 11, seconds * 2, maxTarget, maxTarget},
+	}
+
+	for _, test := range tests {
+		blocks := NewTargetTestBlocks(test.length, test.spacing, test.target)
+		if next := nextTarget(blocks, testDifficulty); next.Cmp(test.expected) != 0 {
+			t.Error("For input", test.name, "expected the target", test.expected, "got", next, "\n")
+		}
+	}
+}
+
+// NewTargetTestBlocks returns a chain of length blocks, which have
+// only the headers needed to find the next target. The blocks after
+// the genesis block are spacing seconds apart, and have the target.
+func NewTargetTestBlocks(length int, spacing uint32, target *big.Int) []Block {
+	blocks := make([]Block, length)
+	for i := 1; i < length; i++ {
+		blocks[i].Header.Timestamp = 1000000 + uint32(i)*spacing
+		blocks[i].Header.Target = encodeTarget(target)
+	}
+	return blocks
+}
+
+func TestBlockTarget(t *testing.T) {
+
+	// work is the expected number of hashes for the target
+	for d := 0; d < 8; d++ {
+		h := BlockHeader{Target: encodeTarget(new(big.Int).Rsh(maxTarget, uint(4*d)))}
+		if w := headerWork(&h); w.Cmp(new(big.Int).Lsh(big.NewInt(1), uint(4*d))) != 0 {
+			t.Error("For input", d, "expected the work to be", 1<<uint(4*d), "got", w, "\n")
+		}
+	}
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := c.blocks()
+	genesis := blocks[0]
+
+	var tests = []struct {
+		name   string
+		target *big.Int
+		valid  bool
+	}{
+		{"expected target", initialTarget(testDifficulty), true},
+		{"easier target", new(big.Int).Lsh(initialTarget(testDifficulty), 1), false},
+		{"harder target", new(big.Int).Rsh(initialTarget(testDifficulty), 1), false},
+	}
+
+	for _, test := range tests {
+		tr, err := NewTestTransaction(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl := NewBlock()
+		bl.addTransaction(tr)
+		bl.setParent(&genesis)
+		bl.createProof(test.target, 0, make(chan bool))
+
+		chain := []Block{genesis, *bl}
+		if valid, _ := c.validate(&chain); valid != test.valid {
+			t.Error("For input", test.name, "expected the block to be valid:", test.valid, "\n")
+		}
+	}
+
+	// a block may not have a timestamp before its parent, which
+	// is later than the time the block is mined at
+	parent := NewBlock()
+	parent.setParent(&genesis)
+	parent.Header.Timestamp = uint32(time.Now().Add(time.Hour).Unix())
+	parent.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+
+	backdated := NewBlock()
+	backdated.setParent(parent)
+	backdated.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+	chain := []Block{genesis, *parent, *backdated}
+	if c.consensus.VerifySeal(chain, 2) {
+		t.Error("Expected a block with a timestamp before its parent to be invalid\n")
+	}
+
+	sealed := NewBlock()
+	sealed.setParent(parent)
+	c.consensus.Seal(sealed, chain[:2], make(chan bool))
+	chain[2] = *sealed
+	if !c.consensus.VerifySeal(chain, 2) {
+		t.Error("Expected a sealed block to have a timestamp no earlier than its parent\n")
+	}
+
+	// a proof which does not meet the target of the header is rejected
+	var hash [32]byte
+	hash[0] = 0x10
+	if checkProof(hash, new(big.Int).Rsh(maxTarget, 4)) {
+		t.Error("Expected a hash above the target not to meet it\n")
+	}
+	hash[0] = 0x0f
+	if !checkProof(hash, new(big.Int).Rsh(maxTarget, 4)) {
+		t.Error("Expected a hash below the target to meet it\n")
+	}
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/CPSSD/voting/src/crypto"
+)
+
+// transactionTag separates the encoding of a TransactionHeader
+// from the encodings of other values which may be hashed.
+const transactionTag = "voting/transaction/v1"
+
+// Bytes returns the canonical binary encoding of the header,
+// which covers every field including the Signature.
+func (h *TransactionHeader) Bytes() []byte {
+
+	var buf bytes.Buffer
+	buf.Write(h.signedBytes())
+	crypto.WriteInt(&buf, h.Signature.R)
+	crypto.WriteInt(&buf, h.Signature.S)
+	return buf.Bytes()
+}
+
+// SigningHash returns the hash of the parts of the header
+// which are covered by its Signature.
+func (h *TransactionHeader) SigningHash() [32]byte {
+	return sha256.Sum256(h.signedBytes())
+}
+
+// signedBytes returns the canonical binary encoding of the
+// parts of the header which are covered by its Signature.
+func (h *TransactionHeader) signedBytes() []byte {
+
+	var buf bytes.Buffer
+	crypto.WriteString(&buf, transactionTag)
+	crypto.WriteString(&buf, h.VoteToken)
+	buf.Write(h.BallotHash[:])
+	crypto.WriteString(&buf, h.ElectionID)
+	buf.Write(h.GenesisHash[:])
+	binary.Write(&buf, binary.BigEndian, h.Timestamp)
+	return buf.Bytes()
+}
+
+// Hash returns the hash of the canonical encoding of the
+// transaction's header, which is used as its leaf in the
+// merkle tree of a block. The header contains the hash of
+// the ballot, so the ballot is also covered.
+func (t *Transaction) Hash() [32]byte {
+	return sha256.Sum256(t.Header.Bytes())
+}
+
+// headerVersion is the version of the BlockHeader format. Blocks
+// with another version are rejected.
+const headerVersion = 1
+
+// headerSize is the length of the binary encoding of a
+// BlockHeader.
+const headerSize = 4 + 4 + 32 + 32 + 4 + 32 + 4 + 4 + 4
+
+// Bytes returns the fixed width binary encoding of the header.
+// The nonce is the last field, so that it can be changed in the
+// encoding while mining without encoding the whole header again.
+func (h *BlockHeader) Bytes() []byte {
+
+	data := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(data[0:], h.Version)
+	binary.BigEndian.PutUint32(data[4:], h.Height)
+	copy(data[8:], h.ParentHash[:])
+	copy(data[40:], h.MerkleHash[:])
+	binary.BigEndian.PutUint32(data[72:], h.Timestamp)
+	copy(data[76:], h.Target[:])
+	binary.BigEndian.PutUint32(data[108:], h.Signer)
+	binary.BigEndian.PutUint32(data[112:], h.ExtraNonce)
+	binary.BigEndian.PutUint32(data[116:], h.Nonce)
+	return data
+}
+
+// Hash returns the hash of the binary encoding of the header,
+// which identifies the block. It is the proof of work of a mined
+// block, the hash signed by an authority, and the hash of the
+// genesis block.
+func (h *BlockHeader) Hash() [32]byte {
+	return sha256.Sum256(h.Bytes())
+}
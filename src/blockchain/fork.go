@@ -0,0 +1,280 @@
+package blockchain
+
+import (
+	"bytes"
+	"log"
+	"math/big"
+)
+
+// ReorgEvent describes a change of the chain to a branch which
+// does not extend it. The blocks after the common ancestor at
+// index Fork are rolled back, and the blocks of the new branch
+// are rolled forward in their place.
+type ReorgEvent struct {
+	Fork int
+
+	// vote tokens of the transactions in the blocks rolled back
+	Dropped []string
+	// vote tokens of the transactions in the blocks rolled forward
+	Added []string
+	// vote tokens of dropped transactions which are not in the new
+	// branch, and so are added back to the pool to be mined again
+	Readded []string
+}
+
+// chainWork returns the total work of the blocks of a chain. The
+// genesis block does not need a proof of work, so adds nothing.
+func (c *Chain) chainWork(blocks []Block) (work *big.Int) {
+	work = new(big.Int)
+	for i := 1; i < len(blocks); i++ {
+		work.Add(work, c.consensus.Work(&blocks[i].Header, i))
+	}
+	return work
+}
+
+// forkPoint returns the index of the last block which the chains
+// a and b have in common, or -1 if they have none.
+func forkPoint(a, b []Block) (fork int) {
+	for fork = 0; fork < len(a) && fork < len(b); fork++ {
+		if a[fork].Proof != b[fork].Proof {
+			break
+		}
+	}
+	return fork - 1
+}
+
+// branchTo returns the chain which ends with the block bl, made
+// of the blocks of the main chain up to the point it forks from
+// it, and then the side blocks which lead to bl. It also returns
+// the index of the fork point in the main chain. If the branch
+// does not lead back to the main chain, known is false.
+func branchTo(main []Block, side map[[32]byte]Block, bl *Block) (branch []Block, fork int, known bool) {
+
+	index := make(map[[32]byte]int, len(main))
+	for i := range main {
+		index[main[i].Proof] = i
+	}
+
+	path := []Block{*bl}
+	parent := bl.Header.ParentHash
+	for len(path) <= len(side)+1 {
+		if i, ok := index[parent]; ok {
+			branch = make([]Block, i+1, i+1+len(path))
+			copy(branch, main[:i+1])
+			for j := len(path) - 1; j >= 0; j-- {
+				branch = append(branch, path[j])
+			}
+			return branch, i, true
+		}
+		sb, ok := side[parent]
+		if !ok {
+			break
+		}
+		path = append(path, sb)
+		parent = sb.Header.ParentHash
+	}
+	return nil, -1, false
+}
+
+// isKnown returns true if the block with the given proof is in the
+// main chain or in a side branch.
+func isKnown(main []Block, side map[[32]byte]Block, proof [32]byte) bool {
+	if _, ok := side[proof]; ok {
+		return true
+	}
+	for i := len(main) - 1; i >= 0; i-- {
+		if main[i].Proof == proof {
+			return true
+		}
+	}
+	return false
+}
+
+// addSideBlock will keep the last block of branch, which is not
+// part of the main chain, so that the branch can be switched to if
+// it later has more work than the main chain. The header of the
+// block is checked against the blocks before it in the branch, so
+// it must have the target expected there, while its transactions
+// are validated if its branch is switched to.
+func (c *Chain) addSideBlock(branch []Block) {
+
+	bl := &branch[len(branch)-1]
+	if !c.validateSideBlock(branch) {
+		log.Println("Rejected an invalid side block")
+		return
+	}
+
+	c.update(func(tx *stateTx) error {
+		side := tx.copySide()
+		side[bl.Proof] = *bl
+		pruneSideBlocks(side, len(tx.Blocks))
+		tx.setSide(side)
+		if _, ok := side[bl.Proof]; ok {
+			log.Println("Added a block to a side branch")
+		} else {
+			log.Println("Discarded a side block too far behind the chain")
+		}
+		return nil
+	})
+}
+
+// validateSideBlock will check the last block of branch, whose
+// earlier blocks are already known, against its place in the
+// branch, using the Consensus of the chain.
+func (c *Chain) validateSideBlock(branch []Block) bool {
+
+	bl := &branch[len(branch)-1]
+	if bl.Header.MerkleHash != merkleHash(bl.Transactions) || !c.blockFits(bl) {
+		return false
+	}
+	return c.validateHeader(branch, len(branch)-1)
+}
+
+// pruneSideBlocks will remove the side blocks which are more than
+// maxSideDepth blocks below the top of a main chain of n blocks, as
+// their branches are unlikely to ever have more work. If there are
+// still more than maxSideBlocks, the lowest of them are removed.
+func pruneSideBlocks(side map[[32]byte]Block, n int) {
+
+	for proof, bl := range side {
+		if int(bl.Header.Height)+maxSideDepth < n-1 {
+			delete(side, proof)
+		}
+	}
+	for len(side) > maxSideBlocks {
+		var lowest *Block
+		for proof := range side {
+			bl := side[proof]
+			if lowest == nil || bl.Header.Height < lowest.Header.Height ||
+				(bl.Header.Height == lowest.Header.Height && bytes.Compare(bl.Proof[:], lowest.Proof[:]) < 0) {
+				lowest = &bl
+			}
+		}
+		delete(side, lowest.Proof)
+	}
+}
+
+// reorganize will make newBlocks the chain of this node, where
+// seen contains the vote tokens in newBlocks. The blocks of the
+// old chain after the fork point are rolled back and kept as a
+// side branch, and their transactions which are not in the new
+// chain are returned to the pool. The chain, the side blocks, the
+// seen vote tokens and the pool are changed in one transaction.
+// If newBlocks no longer has more work than the chain, as a block
+// was added since it was validated, LessWorkError is returned and
+// nothing is changed. Mining must be stopped while the chain is
+// reorganized.
+func (c *Chain) reorganize(newBlocks []Block, seen map[string]bool) (err error) {
+
+	// the transactions which were being mined when mining stopped
+	currentTrs := <-c.CurrentTransactions
+
+	var ev ReorgEvent
+	var dropped, added []Block
+	var newPool []Transaction
+
+	err = c.update(func(tx *stateTx) error {
+
+		oldBlocks := tx.Blocks
+		if c.chainWork(newBlocks).Cmp(c.chainWork(oldBlocks)) <= 0 {
+			return LessWorkError
+		}
+		fork := forkPoint(oldBlocks, newBlocks)
+		dropped = oldBlocks[fork+1:]
+		added = newBlocks[fork+1:]
+
+		tx.setBlocks(newBlocks)
+
+		// the rolled back blocks become a side branch
+		side := tx.copySide()
+		for _, bl := range added {
+			delete(side, bl.Proof)
+		}
+		for _, bl := range dropped {
+			side[bl.Proof] = bl
+		}
+		pruneSideBlocks(side, len(newBlocks))
+		tx.setSide(side)
+
+		// set the new pool of transactions still to be mined, with
+		// the transactions of the rolled back blocks returned to it
+		droppedTrs := make([]Transaction, 0)
+		for _, bl := range dropped {
+			droppedTrs = append(droppedTrs, bl.Transactions...)
+		}
+
+		allTrs := append(tx.Pool[:len(tx.Pool):len(tx.Pool)], currentTrs...)
+		allTrs = append(allTrs, droppedTrs...)
+
+		// the map of seen transactions is copied, as the pool is
+		// checked against it
+		inChain := make(map[string]bool, len(seen))
+		for vt := range seen {
+			inChain[vt] = true
+		}
+		newPool = c.removeSeenTransactions(allTrs, inChain)
+
+		tx.setSeen(seen)
+		tx.setPool(newPool)
+
+		ev = newReorgEvent(fork, droppedTrs, added, newPool)
+		return nil
+	})
+	if err != nil {
+		// the transactions which were being mined are pending again
+		c.update(func(tx *stateTx) error {
+			pool := append(tx.Pool[:len(tx.Pool):len(tx.Pool)], currentTrs...)
+			tx.setPool(c.removeSeenTransactions(pool, tx.copySeen()))
+			return nil
+		})
+		return err
+	}
+
+	go c.broadcastOldTransactions(&newPool)
+
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	log.Println("Reorganized the chain from block", ev.Fork, "dropping", len(dropped),
+		"blocks and adding", len(added), "blocks")
+
+	// the event is dropped rather than blocking the chain if
+	// nothing is reading them
+	select {
+	case c.Reorgs <- ev:
+	default:
+		log.Println("Reorg event was not delivered")
+	}
+	return nil
+}
+
+// newReorgEvent returns the event for a reorganization of the
+// chain from the block at index fork, which rolled back the
+// transactions dropped, rolled forward the blocks added, and
+// left the pool of transactions still to be mined as pool.
+func newReorgEvent(fork int, dropped []Transaction, added []Block, pool []Transaction) (ev ReorgEvent) {
+
+	ev = ReorgEvent{
+		Fork:    fork,
+		Dropped: make([]string, 0, len(dropped)),
+		Added:   make([]string, 0),
+		Readded: make([]string, 0),
+	}
+	inPool := make(map[string]bool, len(pool))
+	for _, tr := range pool {
+		inPool[tr.Header.VoteToken] = true
+	}
+	for _, tr := range dropped {
+		ev.Dropped = append(ev.Dropped, tr.Header.VoteToken)
+		if inPool[tr.Header.VoteToken] {
+			ev.Readded = append(ev.Readded, tr.Header.VoteToken)
+		}
+	}
+	for _, bl := range added {
+		for _, tr := range bl.Transactions {
+			ev.Added = append(ev.Added, tr.Header.VoteToken)
+		}
+	}
+	return ev
+}
@@ -0,0 +1,280 @@
+package blockchain
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForkChoice(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the main chain has blocks A1 and A2
+	for i := 0; i < 2; i++ {
+		if err = AddTestBlock(c, "token"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := c.blocks()
+	a1, a2 := blocks[1], blocks[2]
+
+	start, stop := RunTestChainUpdates(c)
+	defer stop()
+
+	// B2 forks from A1, but does not have more work
+	b2, err := NewTestBlock(c, &a1, "token2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BlockUpdate <- BlockUpdate{LatestBlock: *b2}
+
+	// B3 makes the branch of B2 the one with the most work
+	b3, err := NewTestBlock(c, b2, "token3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BlockUpdate <- BlockUpdate{LatestBlock: *b3}
+	if !WaitForTestUpdate(start) {
+		t.Fatal("Expected the chain to be reorganized")
+	}
+
+	var tests = []struct {
+		name string
+		tip  [32]byte
+		pool []string
+		ev   ReorgEvent
+	}{
+		{"reorg to side branch", b3.Proof, []string{"token1"}, ReorgEvent{
+			Fork:    1,
+			Dropped: []string{"token1"},
+			Added:   []string{"token2", "token3"},
+			Readded: []string{"token1"},
+		}},
+		{"reorg back to old branch", [32]byte{}, []string{"token2", "token3"}, ReorgEvent{
+			Fork:    1,
+			Dropped: []string{"token2", "token3"},
+			Added:   []string{"token1", "token4", "token5"},
+			Readded: []string{"token2", "token3"},
+		}},
+	}
+
+	for i, test := range tests {
+
+		if i == 1 {
+			// A3 and A4 extend the old branch until it has more work
+			a3, err := NewTestBlock(c, &a2, "token4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.BlockUpdate <- BlockUpdate{LatestBlock: *a3}
+			a4, err := NewTestBlock(c, a3, "token5")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.BlockUpdate <- BlockUpdate{LatestBlock: *a4}
+			if !WaitForTestUpdate(start) {
+				t.Fatal("Expected the chain to be reorganized")
+			}
+			test.tip = a4.Proof
+		}
+
+		success, err := CheckReorg(c, test.tip, test.pool, test.ev)
+		if !success {
+			t.Error("For input", test.name, "expected the chain to be reorganized", "with error", err, "\n")
+		}
+	}
+
+	// a block which is already known is ignored
+	c.BlockUpdate <- BlockUpdate{LatestBlock: *b3}
+	c.BlockUpdate <- BlockUpdate{LatestBlock: a2}
+	select {
+	case <-start:
+		t.Error("Expected known blocks to be ignored\n")
+	case ev := <-c.Reorgs:
+		t.Error("Expected known blocks not to cause a reorg, got", ev, "\n")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSideBlocks(t *testing.T) {
+
+	// few side blocks are kept, close to the top of the chain
+	depth, max := maxSideDepth, maxSideBlocks
+	maxSideDepth, maxSideBlocks = 1, 2
+	defer func() {
+		maxSideDepth, maxSideBlocks = depth, max
+	}()
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the main chain has blocks A1 and A2
+	for i := 0; i < 2; i++ {
+		if err = AddTestBlock(c, "token"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := c.blocks()
+	genesis, a1 := blocks[0], blocks[1]
+
+	addSide := func(bl *Block) {
+		branch, _, known := branchTo(c.blocks(), c.snapshot().Side, bl)
+		if !known {
+			t.Fatal("Expected the branch of the side block to be known")
+		}
+		c.addSideBlock(branch)
+	}
+	newSide := func(parent *Block, vt string) *Block {
+		bl, err := NewTestBlock(c, parent, vt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bl
+	}
+
+	// a side block is checked against the target expected after
+	// its parent, not the target it claims
+	easy := newSide(&a1, "token2")
+	easy.createProof(new(big.Int).Lsh(initialTarget(testDifficulty), 2), 0, make(chan bool))
+	addSide(easy)
+
+	// C1 forks from the genesis block and B2 from A1
+	c1 := newSide(&genesis, "token3")
+	addSide(c1)
+	b2 := newSide(&a1, "token4")
+	addSide(b2)
+
+	// once A3 is added, C1 is too far behind the chain and is
+	// dropped when another side block is added
+	if err = AddTestBlock(c, "token5"); err != nil {
+		t.Fatal(err)
+	}
+	other := newSide(&a1, "token6")
+	addSide(other)
+	pruned := c.snapshot().Side
+
+	// B3 is kept in place of the lowest side block
+	b3 := newSide(b2, "token7")
+	addSide(b3)
+	kept := c.snapshot().Side
+
+	var tests = []struct {
+		name     string
+		side     map[[32]byte]Block
+		proof    [32]byte
+		expected bool
+	}{
+		{"side block with an easier target", pruned, easy.Proof, false},
+		{"side block far behind the chain", pruned, c1.Proof, false},
+		{"side block close to the chain", pruned, b2.Proof, true},
+		{"another side block close to the chain", pruned, other.Proof, true},
+		{"highest side block", kept, b3.Proof, true},
+	}
+
+	for _, test := range tests {
+		if _, ok := test.side[test.proof]; ok != test.expected {
+			t.Error("For input", test.name, "expected the side block to be kept:", test.expected, "\n")
+		}
+	}
+	if len(pruned) != maxSideBlocks || len(kept) != maxSideBlocks {
+		t.Error("Expected", maxSideBlocks, "side blocks to be kept, got", len(pruned), "and", len(kept), "\n")
+	}
+}
+
+// CheckReorg checks that the chain of c ends with the block with
+// the proof tip, that its pool has transactions for the vote tokens
+// pool, and that the reorg event ev was emitted.
+func CheckReorg(c *Chain, tip [32]byte, pool []string, ev ReorgEvent) (success bool, err error) {
+
+	blocks := c.blocks()
+	if blocks[len(blocks)-1].Proof != tip {
+		return false, nil
+	}
+
+	trs := c.snapshot().Pool
+	tokens := make([]string, 0)
+	for _, tr := range trs {
+		tokens = append(tokens, tr.Header.VoteToken)
+	}
+	sort.Strings(tokens)
+	if !reflect.DeepEqual(tokens, pool) {
+		return false, nil
+	}
+
+	var got ReorgEvent
+	select {
+	case got = <-c.Reorgs:
+	default:
+		return false, nil
+	}
+	sort.Strings(got.Dropped)
+	sort.Strings(got.Added)
+	sort.Strings(got.Readded)
+	return reflect.DeepEqual(got, ev), nil
+}
+
+// RunTestChainUpdates runs the chain update process of c, with a
+// stand-in for the mining process. A signal is received on start
+// each time the chain is changed by an update, and calling stop
+// stops the process.
+func RunTestChainUpdates(c *Chain) (start chan bool, stop func()) {
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-c.stopMining:
+				c.CurrentTransactions <- make([]Transaction, 0)
+				c.confirmStopped <- true
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		c.scheduleChainUpdates(done)
+	}()
+	return c.startMining, func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// WaitForTestUpdate waits for the chain update process to signal
+// that it has changed the chain.
+func WaitForTestUpdate(start chan bool) bool {
+	select {
+	case <-start:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
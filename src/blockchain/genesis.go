@@ -0,0 +1,352 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/CPSSD/voting/src/crypto"
+	"github.com/CPSSD/voting/src/election"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"sort"
+)
+
+var (
+	InvalidGenesisError    = errors.New("Invalid genesis block; it does not match the election manifest.")
+	UnexpectedGenesisError = errors.New("Genesis block does not have the published genesis hash.")
+	NoGenesisError         = errors.New("The chain does not have a genesis block yet.")
+)
+
+// manifestTag separates the encoding of the manifest from the
+// encodings of other values which may be hashed.
+const manifestTag = "voting/manifest/v1"
+
+// Manifest contains the public information about an election
+// which every node must agree on. It is stored in the genesis
+// block, so that the hash of the genesis block commits to it.
+type Manifest struct {
+	ElectionID     string
+	ElectionKey    crypto.PublicKey
+	VoteTokens     map[string]dsa.PublicKey
+	ElectionFormat election.Format
+
+	// moduli and commitments used to verify shares of the key
+	ElectionLambdaModulus     *big.Int
+	ElectionMuModulus         *big.Int
+	ElectionLambdaCommitments *crypto.Commitments
+	ElectionMuCommitments     *crypto.Commitments
+
+	// parameters of threshold decryption of the tally
+	ThresholdDecryption      bool
+	ElectionThreshold        int
+	ElectionParties          int
+	ElectionVerificationKeys []*big.Int
+	Trustees                 []string
+	TrusteeKeys              []dsa.PublicKey
+
+	// votes are only accepted with timestamps in the voting
+	// window, unless VotingEnd is 0
+	VotingStart uint32
+	VotingEnd   uint32
+
+	// the consensus mode, and the keys of the authorities which
+	// sign blocks with proof of authority
+	Consensus   string
+	Authorities []dsa.PublicKey
+
+	// the parameters of the chain, which every node must agree on
+	Params ChainParams
+
+	// the fingerprints of the certificates of the registered nodes,
+	// which peers are authenticated against
+	Nodes []string
+}
+
+// NewManifest returns the Manifest of the election described by
+// the Configuration conf.
+func NewManifest(conf *Configuration) (m *Manifest) {
+	return &Manifest{
+		ElectionID:     conf.ElectionID,
+		ElectionKey:    conf.ElectionKey.PublicKey,
+		VoteTokens:     conf.VoteTokens,
+		ElectionFormat: conf.ElectionFormat,
+
+		ElectionLambdaModulus:     conf.ElectionLambdaModulus,
+		ElectionMuModulus:         conf.ElectionMuModulus,
+		ElectionLambdaCommitments: conf.ElectionLambdaCommitments,
+		ElectionMuCommitments:     conf.ElectionMuCommitments,
+
+		ThresholdDecryption:      conf.ThresholdDecryption,
+		ElectionThreshold:        conf.ElectionThreshold,
+		ElectionParties:          conf.ElectionParties,
+		ElectionVerificationKeys: conf.ElectionVerificationKeys,
+		Trustees:                 conf.Trustees,
+		TrusteeKeys:              conf.TrusteeKeys,
+
+		VotingStart: conf.VotingStart,
+		VotingEnd:   conf.VotingEnd,
+
+		Consensus:   conf.Consensus,
+		Authorities: conf.Authorities,
+
+		Params: conf.Params,
+		Nodes:  conf.Nodes,
+	}
+}
+
+// Bytes returns the canonical binary encoding of the Manifest.
+func (m *Manifest) Bytes() []byte {
+
+	var buf bytes.Buffer
+	crypto.WriteString(&buf, manifestTag)
+	crypto.WriteString(&buf, m.ElectionID)
+	crypto.WriteInt(&buf, m.ElectionKey.N)
+	crypto.WriteInt(&buf, m.ElectionKey.Generator)
+
+	tokens := make([]string, 0, len(m.VoteTokens))
+	for vt := range m.VoteTokens {
+		tokens = append(tokens, vt)
+	}
+	sort.Strings(tokens)
+	binary.Write(&buf, binary.BigEndian, uint32(len(tokens)))
+	for _, vt := range tokens {
+		pub := m.VoteTokens[vt]
+		crypto.WriteString(&buf, vt)
+		crypto.WriteInt(&buf, pub.P)
+		crypto.WriteInt(&buf, pub.Q)
+		crypto.WriteInt(&buf, pub.G)
+		crypto.WriteInt(&buf, pub.Y)
+	}
+
+	crypto.WriteBytes(&buf, m.ElectionFormat.Bytes())
+
+	crypto.WriteInt(&buf, m.ElectionLambdaModulus)
+	crypto.WriteInt(&buf, m.ElectionMuModulus)
+	writeCommitments(&buf, m.ElectionLambdaCommitments)
+	writeCommitments(&buf, m.ElectionMuCommitments)
+
+	binary.Write(&buf, binary.BigEndian, m.ThresholdDecryption)
+	binary.Write(&buf, binary.BigEndian, int64(m.ElectionThreshold))
+	binary.Write(&buf, binary.BigEndian, int64(m.ElectionParties))
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.ElectionVerificationKeys)))
+	for _, vk := range m.ElectionVerificationKeys {
+		crypto.WriteInt(&buf, vk)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.Trustees)))
+	for _, t := range m.Trustees {
+		crypto.WriteString(&buf, t)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.TrusteeKeys)))
+	for _, pub := range m.TrusteeKeys {
+		crypto.WriteInt(&buf, pub.P)
+		crypto.WriteInt(&buf, pub.Q)
+		crypto.WriteInt(&buf, pub.G)
+		crypto.WriteInt(&buf, pub.Y)
+	}
+
+	binary.Write(&buf, binary.BigEndian, m.VotingStart)
+	binary.Write(&buf, binary.BigEndian, m.VotingEnd)
+
+	crypto.WriteString(&buf, m.Consensus)
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.Authorities)))
+	for _, pub := range m.Authorities {
+		crypto.WriteInt(&buf, pub.P)
+		crypto.WriteInt(&buf, pub.Q)
+		crypto.WriteInt(&buf, pub.G)
+		crypto.WriteInt(&buf, pub.Y)
+	}
+
+	writeParams(&buf, &m.Params)
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.Nodes)))
+	for _, n := range m.Nodes {
+		crypto.WriteString(&buf, n)
+	}
+	return buf.Bytes()
+}
+
+// Hash returns the hash of the canonical encoding of the Manifest.
+func (m *Manifest) Hash() [32]byte {
+	return sha256.Sum256(m.Bytes())
+}
+
+// NewGenesisBlock returns the genesis block of the election
+// described by the Manifest m. The block contains no
+// transactions, and its proof is the hash of its header, which
+// contains the hash of the manifest. Every node creates the
+// same genesis block from the same manifest.
+func NewGenesisBlock(m *Manifest) (b *Block) {
+	b = &Block{
+		Transactions: make([]Transaction, 0),
+		Manifest:     m,
+		Header: BlockHeader{
+			Version:    headerVersion,
+			MerkleHash: m.Hash(),
+			Timestamp:  m.VotingStart,
+		},
+	}
+	b.Proof = b.Header.Hash()
+	return b
+}
+
+// validateGenesis will check that a block is a genesis block
+// for the manifest it contains, and returns its hash.
+func (bl *Block) validateGenesis() (isValid bool, hash [32]byte) {
+
+	if bl.Manifest == nil || len(bl.Transactions) != 0 ||
+		bl.Header.Version != headerVersion || bl.Header.Height != 0 ||
+		bl.Header.ParentHash != *new([32]byte) || bl.Header.Target != *new([32]byte) ||
+		bl.Header.Nonce != 0 || bl.Header.ExtraNonce != 0 || bl.Header.Signer != 0 {
+		return false, hash
+	}
+	if bl.Header.MerkleHash != bl.Manifest.Hash() {
+		return false, hash
+	}
+	// the hash of the manifest only covers N of the election key
+	if !validElectionKey(&bl.Manifest.ElectionKey) {
+		return false, hash
+	}
+	hash = bl.Header.Hash()
+	return hash == bl.Proof, hash
+}
+
+// ReadGenesisBlock reads a genesis block from a json file.
+func ReadGenesisBlock(filename string) (b *Block, err error) {
+
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	b = new(Block)
+	if err = json.Unmarshal(bs, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GenesisHash returns the hash of the genesis block of this
+// election's chain, which commits to the election manifest. A
+// transaction signed for one election is rejected by the chain
+// of any other. If the chain does not yet have a genesis block,
+// because the election key has not been generated, the zero
+// hash is returned.
+func (c *Chain) GenesisHash() [32]byte {
+	blocks := c.blocks()
+	if len(blocks) == 0 {
+		return *new([32]byte)
+	}
+	return blocks[0].Proof
+}
+
+// hasGenesis returns whether the chain has a genesis block. The
+// election information in the configuration is set before the
+// genesis block, so once this returns true, the RPC functions may
+// read it. Until then, it may still be replaced by key generation.
+func (c *Chain) hasGenesis() bool {
+	return c.GenesisHash() != *new([32]byte)
+}
+
+// initGenesis will set the genesis block of the chain when the
+// node starts. The block is read from the configured file, or
+// created from the configuration if there is none. With
+// distributed key generation, the genesis block is instead
+// created once the election key has been generated.
+func (c *Chain) initGenesis() (err error) {
+
+	if c.conf.DistributedKeyGeneration {
+		log.Println("The genesis block will be created after key generation")
+		if index := c.trusteeIndex(); index > 0 {
+			c.openKeyGenSession(index)
+		}
+		return nil
+	}
+
+	genesis := NewGenesisBlock(NewManifest(&c.conf))
+	if c.conf.GenesisFile != "" {
+		log.Println("Reading the genesis block")
+		genesis, err = ReadGenesisBlock(c.conf.GenesisFile)
+		if err != nil {
+			return err
+		}
+	}
+	return c.setGenesis(genesis)
+}
+
+// setGenesis will check the genesis block against the published
+// genesis hash, if there is one, and then make it the first
+// block of the chain. The election information in the node's
+// configuration is replaced with the contents of its manifest.
+// Any chain kept in the block store is then restored.
+func (c *Chain) setGenesis(genesis *Block) (err error) {
+
+	valid, hash := genesis.validateGenesis()
+	if !valid {
+		return InvalidGenesisError
+	}
+	if c.conf.GenesisHash != "" && c.conf.GenesisHash != hex.EncodeToString(hash[:]) {
+		return UnexpectedGenesisError
+	}
+
+	m := genesis.Manifest
+	c.conf.ElectionID = m.ElectionID
+	c.conf.ElectionKey.PublicKey = m.ElectionKey
+	c.conf.VoteTokens = m.VoteTokens
+	c.conf.ElectionFormat = m.ElectionFormat
+	c.conf.ElectionLambdaModulus = m.ElectionLambdaModulus
+	c.conf.ElectionMuModulus = m.ElectionMuModulus
+	c.conf.ElectionLambdaCommitments = m.ElectionLambdaCommitments
+	c.conf.ElectionMuCommitments = m.ElectionMuCommitments
+	c.conf.ThresholdDecryption = m.ThresholdDecryption
+	c.conf.ElectionThreshold = m.ElectionThreshold
+	c.conf.ElectionParties = m.ElectionParties
+	c.conf.ElectionVerificationKeys = m.ElectionVerificationKeys
+	c.conf.Trustees = m.Trustees
+	c.conf.TrusteeKeys = m.TrusteeKeys
+	c.conf.VotingStart = m.VotingStart
+	c.conf.VotingEnd = m.VotingEnd
+	c.conf.Consensus = m.Consensus
+	c.conf.Authorities = m.Authorities
+	c.conf.Params = m.Params
+	c.conf.Nodes = m.Nodes
+	if err = c.conf.Params.validate(); err != nil {
+		return err
+	}
+	if err = c.conf.ElectionFormat.Validate(); err != nil {
+		return err
+	}
+	if err = c.setConsensus(); err != nil {
+		return err
+	}
+
+	// the stored chain is not replaced until it has been restored
+	c.update(func(tx *stateTx) error {
+		tx.Blocks = []Block{*genesis}
+		tx.setSide(make(map[[32]byte]Block, 0))
+		return nil
+	})
+
+	log.Println("Set the genesis block:", hex.EncodeToString(hash[:]))
+	c.restore(genesis)
+	return nil
+}
+
+// writeCommitments writes the commitments to a polynomial to buf.
+// A nil value has a different encoding to empty commitments.
+func writeCommitments(buf *bytes.Buffer, cm *crypto.Commitments) {
+	if cm == nil {
+		buf.WriteByte(0)
+		return
+	}
+	buf.WriteByte(1)
+	crypto.WriteInt(buf, cm.Prime)
+	crypto.WriteInt(buf, cm.P)
+	crypto.WriteInt(buf, cm.G)
+	binary.Write(buf, binary.BigEndian, uint32(len(cm.Values)))
+	for _, v := range cm.Values {
+		crypto.WriteInt(buf, v)
+	}
+}
@@ -0,0 +1,186 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestGenesisBlock(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := NewGenesisBlock(NewManifest(&c.conf))
+	if valid, hash := genesis.validateGenesis(); !valid || hash != c.GenesisHash() {
+		t.Error("Expected the genesis block to be valid and have the hash of the chain's genesis block\n")
+	}
+
+	// the genesis block must survive being saved and read back
+	bs, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := new(Block)
+	if err = json.Unmarshal(bs, read); err != nil {
+		t.Fatal(err)
+	}
+	if valid, hash := read.validateGenesis(); !valid || hash != genesis.Proof {
+		t.Error("Expected the genesis block to be valid after being read from json\n")
+	}
+
+	var tests = []struct {
+		name   string
+		tamper func(m *Manifest)
+	}{
+		{"changed election", func(m *Manifest) {
+			m.ElectionID = "other"
+		}},
+		{"changed election key", func(m *Manifest) {
+			m.ElectionKey.N = new(big.Int).Add(m.ElectionKey.N, big.NewInt(2))
+		}},
+		{"changed election key NSquared", func(m *Manifest) {
+			m.ElectionKey.NSquared = new(big.Int).Add(m.ElectionKey.NSquared, big.NewInt(2))
+		}},
+		{"changed election key generator", func(m *Manifest) {
+			m.ElectionKey.Generator = new(big.Int).Add(m.ElectionKey.Generator, big.NewInt(2))
+		}},
+		{"removed vote token", func(m *Manifest) {
+			delete(m.VoteTokens, testToken)
+		}},
+		{"changed format", func(m *Manifest) {
+			m.ElectionFormat.Selections[0].Name = "maybe"
+		}},
+		{"changed voting window", func(m *Manifest) {
+			m.VotingEnd = 1
+		}},
+		{"changed consensus", func(m *Manifest) {
+			m.Consensus = ProofOfAuthority
+		}},
+		{"changed chain parameters", func(m *Manifest) {
+			m.Params.BlockSize++
+		}},
+		{"registered node", func(m *Manifest) {
+			m.Nodes = append(m.Nodes, "other")
+		}},
+		{"registered trustee key", func(m *Manifest) {
+			m.TrusteeKeys = append(m.TrusteeKeys, m.VoteTokens[testToken])
+		}},
+	}
+
+	for _, test := range tests {
+		bl := new(Block)
+		if err = json.Unmarshal(bs, bl); err != nil {
+			t.Fatal(err)
+		}
+		test.tamper(bl.Manifest)
+		if valid, _ := bl.validateGenesis(); valid {
+			t.Error("For input", test.name, "expected the genesis block to be invalid\n")
+		}
+	}
+
+	// a genesis block which does not match the published hash is rejected
+	other, err := NewChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.conf.GenesisHash = hex.EncodeToString(make([]byte, 32))
+	if err = other.setGenesis(genesis); err != UnexpectedGenesisError {
+		t.Error("Expected", UnexpectedGenesisError, "for a genesis block without the published hash, got", err, "\n")
+	}
+	other.conf.GenesisHash = hex.EncodeToString(genesis.Proof[:])
+	if err = other.setGenesis(genesis); err != nil {
+		t.Error("Expected the genesis block with the published hash to be accepted, got", err, "\n")
+	}
+}
+
+func TestChainAnchoredToGenesis(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := c.blocks()
+	genesis := blocks[0]
+
+	bl := NewBlock()
+	bl.addTransaction(tr)
+	bl.setParent(&genesis)
+	bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+
+	if valid, _ := c.validate(&[]Block{genesis, *bl}); !valid {
+		t.Error("Expected a block on top of the genesis block to be valid\n")
+	}
+	if valid, _ := c.validate(&[]Block{*bl}); valid {
+		t.Error("Expected a chain without the genesis block to be invalid\n")
+	}
+
+	// a block mined on the zero hash does not anchor to the genesis block
+	orphan := NewBlock()
+	orphan.addTransaction(tr)
+	orphan.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+	if valid, _ := c.validate(&[]Block{genesis, *orphan}); valid {
+		t.Error("Expected a block which is not anchored to the genesis block to be invalid\n")
+	}
+
+	// the genesis block of another election is rejected
+	conf := c.conf
+	conf.ElectionID = "other"
+	otherGenesis := NewGenesisBlock(NewManifest(&conf))
+	if valid, _ := c.validate(&[]Block{*otherGenesis}); valid {
+		t.Error("Expected a chain with the genesis block of another election to be invalid\n")
+	}
+}
+
+func TestVotingWindow(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint32(time.Now().Unix())
+	conf := c.conf
+
+	// voting has closed
+	conf.VotingStart, conf.VotingEnd = now-200, now-100
+	if err = c.setGenesis(NewGenesisBlock(NewManifest(&conf))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewTestTransaction(c); err != VotingClosedError {
+		t.Error("Expected", VotingClosedError, "after voting has closed, got", err, "\n")
+	}
+
+	// voting is open
+	conf.VotingStart, conf.VotingEnd = now-100, now+100
+	if err = c.setGenesis(NewGenesisBlock(NewManifest(&conf))); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.ValidateSignature(tr) {
+		t.Error("Expected a vote in the voting window to be valid\n")
+	}
+
+	// a correctly signed vote with a timestamp after voting has
+	// closed is rejected
+	tr.Header.Timestamp = conf.VotingEnd + 1
+	hash := tr.Header.SigningHash()
+	tr.Header.Signature = *crypto.SignHash(&c.conf.PrivateKey, &hash)
+	if c.ValidateSignature(tr) {
+		t.Error("Expected a vote outside the voting window to be invalid\n")
+	}
+}
@@ -0,0 +1,440 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/CPSSD/voting/src/crypto"
+	"log"
+	"math/big"
+	"time"
+)
+
+var (
+	KeyNotGeneratedError          = errors.New("The election key has not been generated yet.")
+	KeyGenTimeoutError            = errors.New("Timed out waiting for messages from the other trustees.")
+	KeyGenUnreachableError        = errors.New("Could not reach a trustee during key generation.")
+	TrusteeDisagreementError      = errors.New("Trustees do not agree on the election key.")
+	KeyGenRunningError            = errors.New("Key generation is already running.")
+	KeyGeneratedError             = errors.New("The election key has already been generated.")
+	KeyGenNotStartedError         = errors.New("This trustee has not started key generation yet.")
+	MissingTrusteeKeysError       = errors.New("The configuration does not have a key for each trustee.")
+	InvalidKeyGenSignatureError   = errors.New("Key generation message is not signed by the trustee which sent it.")
+	ConflictingKeyGenMessageError = errors.New("A different key generation message was already received from the trustee.")
+	InvalidElectionKeyError       = errors.New("The election key does not have the generator and modulus of its N.")
+)
+
+// keyGenTag separates the signed encoding of key generation
+// messages from the encodings of everything else which is signed.
+const keyGenTag = "voting/keygen/v1"
+
+// SignedKeyGenMessage is a key generation message along with the
+// signature of the trustee which sent it, made with the key
+// registered for it in TrusteeKeys.
+type SignedKeyGenMessage struct {
+	Message   crypto.KeyGenMessage
+	Signature crypto.Signature
+}
+
+// keyGenSession holds what a trustee needs to check the messages
+// it receives during key generation. It is set before the trustee
+// is served, and is never changed, so that the RPC functions do
+// not read the configuration while the genesis block replaces it.
+// The messages are kept under the lock of the state.
+type keyGenSession struct {
+	electionID string
+	index      int
+	keys       []dsa.PublicKey
+	messages   map[int]map[int]crypto.KeyGenMessage
+}
+
+// PublicElectionKey contains the public parts of an election key
+// created with distributed key generation.
+type PublicElectionKey struct {
+	Key              crypto.PublicKey
+	VerificationKeys []*big.Int
+}
+
+// trusteeIndex returns the position of this node in the list
+// of trustees, starting at 1, or 0 if it is not a trustee.
+func (c *Chain) trusteeIndex() int {
+	for i, t := range c.conf.Trustees {
+		if t == c.conf.MyAddr+c.conf.MyPort {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// GenerateElectionKey will create the election key together with
+// the other trustees of the election, using distributed key
+// generation. Each trustee receives a share of the decryption key
+// for threshold decryption of the tally, and no node ever holds
+// Lambda or Mu. Nodes which are not trustees will instead fetch
+// the public election key from the trustees. Once the key is
+// known, the genesis block of the chain is created from the
+// configuration. This call blocks until the key has been created,
+// and must return before the chain is run, as it replaces the
+// configuration of the chain.
+func (c *Chain) GenerateElectionKey() (err error) {
+
+	c.mu.Lock()
+	switch {
+	case c.ran:
+		err = ChainRanError
+	case c.generating:
+		err = KeyGenRunningError
+	case c.hasGenesis():
+		err = KeyGeneratedError
+	default:
+		c.generating = true
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mu.Lock()
+		c.generating = false
+		c.mu.Unlock()
+	}()
+
+	index := c.trusteeIndex()
+	if index == 0 {
+		return c.fetchElectionKey()
+	}
+	if len(c.conf.TrusteeKeys) != len(c.conf.Trustees) {
+		return MissingTrusteeKeysError
+	}
+
+	params := crypto.KeyGenParams{
+		Parties:    len(c.conf.Trustees),
+		Threshold:  c.conf.ElectionThreshold,
+		Bits:       c.conf.KeyGenerationBits,
+		Candidates: keyGenCandidates,
+	}
+	kg, err := crypto.NewKeyGenerator(params, index)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Starting distributed key generation as trustee", index)
+	c.openKeyGenSession(index)
+	out, err := kg.Start()
+	if err != nil {
+		return err
+	}
+
+	for !kg.Done() {
+		if err = c.sendKeyGenMessages(out); err != nil {
+			return err
+		}
+		in, err := c.awaitKeyGenMessages(kg.Round(), params.Parties)
+		if err != nil {
+			return err
+		}
+		log.Println("Received all key generation messages for round", kg.Round())
+		out, err = kg.Step(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, share := kg.Result()
+	c.conf.ElectionKey.PublicKey = *key
+	c.conf.ElectionDecryptionShare = share
+	c.conf.ElectionVerificationKeys = kg.VerificationKeys()
+	c.conf.ThresholdDecryption = true
+	c.conf.ElectionParties = params.Parties
+
+	log.Println("Finished distributed key generation")
+	if err = c.setGenesis(NewGenesisBlock(NewManifest(&c.conf))); err != nil {
+		return err
+	}
+	c.publishElectionKey(&PublicElectionKey{Key: *key, VerificationKeys: kg.VerificationKeys()})
+	return nil
+}
+
+// sendKeyGenMessages will sign each of the messages, and send it
+// to the trustee it is addressed to.
+func (c *Chain) sendKeyGenMessages(msgs []crypto.KeyGenMessage) (err error) {
+
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.To == c.trusteeIndex() {
+			c.addKeyGenMessage(*msg)
+			continue
+		}
+		hash := keyGenMessageHash(c.conf.ElectionID, msg)
+		signed := &SignedKeyGenMessage{
+			Message:   *msg,
+			Signature: *crypto.SignHash(&c.conf.PrivateKey, &hash),
+		}
+		if err = c.sendKeyGenMessageTo(signed, c.conf.Trustees[msg.To-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendKeyGenMessageTo will send a key generation message to a
+// trustee, retrying if the trustee is not yet available.
+func (c *Chain) sendKeyGenMessageTo(msg *SignedKeyGenMessage, peer string) (err error) {
+
+	for attempt := 0; attempt < keyGenRetries; attempt++ {
+		conn, err := c.transport.Dial(peer)
+		if err == nil {
+			err = conn.Call("Chain.ReceiveKeyGenMessage", msg, nil)
+			conn.Close()
+			if err == nil {
+				return nil
+			}
+		}
+		log.Println("Could not send key generation message to", peer, "retrying:", err)
+		time.Sleep(keyGenRetryDelay)
+	}
+	return KeyGenUnreachableError
+}
+
+// keyGenMessageHash returns the hash of the canonical encoding of
+// a key generation message for the election, which is signed by
+// the trustee which sends it.
+func keyGenMessageHash(electionID string, msg *crypto.KeyGenMessage) [32]byte {
+	return sha256.Sum256(keyGenMessageBytes(electionID, msg))
+}
+
+// keyGenMessageBytes returns the canonical encoding of a key
+// generation message for the election.
+func keyGenMessageBytes(electionID string, msg *crypto.KeyGenMessage) []byte {
+
+	var buf bytes.Buffer
+	crypto.WriteString(&buf, keyGenTag)
+	crypto.WriteString(&buf, electionID)
+	binary.Write(&buf, binary.BigEndian, int64(msg.From))
+	binary.Write(&buf, binary.BigEndian, int64(msg.To))
+	binary.Write(&buf, binary.BigEndian, int64(msg.Round))
+	binary.Write(&buf, binary.BigEndian, uint32(len(msg.Values)))
+	for _, v := range msg.Values {
+		crypto.WriteInt(&buf, v)
+	}
+	return buf.Bytes()
+}
+
+// awaitKeyGenMessages will wait until a message has been received
+// from every trustee for the given round, and returns them.
+func (c *Chain) awaitKeyGenMessages(round, parties int) (in []crypto.KeyGenMessage, err error) {
+
+	timeout := time.After(keyGenTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeout:
+			return nil, KeyGenTimeoutError
+		case <-ticker.C:
+			if in = c.takeKeyGenMessages(round, parties); in != nil {
+				return in, nil
+			}
+		}
+	}
+}
+
+// ReceiveKeyGenMessage is an RPC function which allows a trustee
+// to receive messages from other trustees during key generation.
+// A message is only accepted if it is signed by the trustee it is
+// from, and only the first message from each trustee for a round
+// is kept. The same message may be sent again, if the first
+// attempt looked like it failed, but a different one is rejected.
+func (c *Chain) ReceiveKeyGenMessage(msg *SignedKeyGenMessage, _ *struct{}) (err error) {
+	log.Println("Received a key generation message from trustee", msg.Message.From, "for round", msg.Message.Round)
+
+	s := c.keyGenSession()
+	if s == nil {
+		return KeyGenNotStartedError
+	}
+	m := &msg.Message
+	if m.To != s.index || m.From < 1 || m.From > len(s.keys) {
+		return crypto.InvalidKeyGenMessageError
+	}
+	hash := keyGenMessageHash(s.electionID, m)
+	if !crypto.Verify(&s.keys[m.From-1], &hash, &msg.Signature) {
+		log.Println("Rejected a key generation message with an invalid signature")
+		return InvalidKeyGenSignatureError
+	}
+	return c.addKeyGenMessage(*m)
+}
+
+// openKeyGenSession will make the RPC functions accept the key
+// generation messages for the trustee with the given index, unless
+// they already do. It is called before the chain is served, so
+// that the messages of the trustees which start first are kept
+// until this trustee starts.
+func (c *Chain) openKeyGenSession(index int) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if c.state.keyGen != nil {
+		return
+	}
+	c.state.keyGen = &keyGenSession{
+		electionID: c.conf.ElectionID,
+		index:      index,
+		keys:       c.conf.TrusteeKeys,
+		messages:   make(map[int]map[int]crypto.KeyGenMessage, 0),
+	}
+}
+
+// keyGenSession returns the key generation session of the node,
+// or nil if it has not started one.
+func (c *Chain) keyGenSession() *keyGenSession {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.keyGen
+}
+
+// addKeyGenMessage keeps the first message from each trustee for
+// each round. It returns ConflictingKeyGenMessageError if a
+// different message was already kept.
+func (c *Chain) addKeyGenMessage(msg crypto.KeyGenMessage) (err error) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	s := c.state.keyGen
+	msgs := s.messages
+	if _, ok := msgs[msg.Round]; !ok {
+		msgs[msg.Round] = make(map[int]crypto.KeyGenMessage, 0)
+	}
+	if kept, ok := msgs[msg.Round][msg.From]; ok {
+		if !bytes.Equal(keyGenMessageBytes(s.electionID, &kept), keyGenMessageBytes(s.electionID, &msg)) {
+			log.Println("Rejected a conflicting key generation message from trustee", msg.From)
+			return ConflictingKeyGenMessageError
+		}
+		return nil
+	}
+	msgs[msg.Round][msg.From] = msg
+	return nil
+}
+
+// takeKeyGenMessages returns the messages for the given round, and
+// forgets them, once there is a message from each of the parties.
+// Otherwise it returns nil.
+func (c *Chain) takeKeyGenMessages(round, parties int) (in []crypto.KeyGenMessage) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	msgs := c.state.keyGen.messages
+	if len(msgs[round]) != parties {
+		return nil
+	}
+	for _, m := range msgs[round] {
+		in = append(in, m)
+	}
+	delete(msgs, round)
+	return in
+}
+
+// publishElectionKey will make the public election key available
+// to the nodes which fetch it from this trustee.
+func (c *Chain) publishElectionKey(key *PublicElectionKey) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	c.state.electionKey = key
+}
+
+// GetPublicElectionKey is an RPC function which allows a node to get
+// the public election key and the verification keys from a trustee
+// once they have been generated. The value of empty is unused.
+func (c *Chain) GetPublicElectionKey(empty bool, key *PublicElectionKey) error {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	if c.state.electionKey == nil {
+		return KeyNotGeneratedError
+	}
+	*key = *c.state.electionKey
+	return nil
+}
+
+// fetchElectionKey will get the public election key from every
+// trustee, and will only accept it if all of the trustees agree.
+func (c *Chain) fetchElectionKey() (err error) {
+
+	var key *PublicElectionKey
+	for _, t := range c.conf.Trustees {
+		var k PublicElectionKey
+		err = c.getElectionKeyFrom(t, &k)
+		if err != nil {
+			return err
+		}
+		if key != nil && !samePublicElectionKey(key, &k) {
+			return TrusteeDisagreementError
+		}
+		key = &k
+	}
+	if key == nil {
+		return KeyNotGeneratedError
+	}
+
+	c.conf.ElectionKey.PublicKey = key.Key
+	c.conf.ElectionVerificationKeys = key.VerificationKeys
+	c.conf.ThresholdDecryption = true
+	c.conf.ElectionParties = len(c.conf.Trustees)
+	log.Println("Fetched the election key from the trustees")
+	return c.setGenesis(NewGenesisBlock(NewManifest(&c.conf)))
+}
+
+// getElectionKeyFrom will get the public election key from a
+// trustee, waiting until the trustee has generated it.
+func (c *Chain) getElectionKeyFrom(peer string, key *PublicElectionKey) (err error) {
+
+	timeout := time.Now().Add(keyGenTimeout)
+	for time.Now().Before(timeout) {
+		conn, err := c.transport.Dial(peer)
+		if err == nil {
+			err = conn.Call("Chain.GetPublicElectionKey", true, key)
+			conn.Close()
+			if err == nil {
+				if !validElectionKey(&key.Key) {
+					return InvalidElectionKeyError
+				}
+				return nil
+			}
+		}
+		log.Println("Election key not yet available from", peer, "retrying:", err)
+		time.Sleep(keyGenRetryDelay)
+	}
+	return KeyGenTimeoutError
+}
+
+// validElectionKey returns whether key is the Paillier public key
+// of its N, with a Generator of N+1 and an NSquared of N^2, as
+// generated by the trustees. Only N is agreed on by the trustees
+// and committed to by the manifest, so the other values are
+// checked against it.
+func validElectionKey(key *crypto.PublicKey) bool {
+	if key.Validate() != nil || key.N.Sign() <= 0 {
+		return false
+	}
+	nSquared := new(big.Int).Mul(key.N, key.N)
+	generator := new(big.Int).Add(key.N, big.NewInt(1))
+	return key.NSquared.Cmp(nSquared) == 0 && key.Generator.Cmp(generator) == 0
+}
+
+// samePublicElectionKey returns whether a and b contain the same
+// public key and verification keys.
+func samePublicElectionKey(a, b *PublicElectionKey) bool {
+	if a.Key.N.Cmp(b.Key.N) != 0 || len(a.VerificationKeys) != len(b.VerificationKeys) {
+		return false
+	}
+	for i, vk := range a.VerificationKeys {
+		if vk == nil || b.VerificationKeys[i] == nil || vk.Cmp(b.VerificationKeys[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
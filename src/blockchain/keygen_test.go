@@ -0,0 +1,291 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/dsa"
+	"crypto/rand"
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDistributedKeyGeneration(t *testing.T) {
+
+	// small keys and fewer candidates keep the test fast
+	candidates, delay := keyGenCandidates, keyGenRetryDelay
+	keyGenCandidates, keyGenRetryDelay = 256, 10*time.Millisecond
+	defer func() {
+		keyGenCandidates, keyGenRetryDelay = candidates, delay
+	}()
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewTestTrustees(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// three trustees generate the key, which a voter fetches
+	n := NewMemoryNetwork(1)
+	chains := make([]*Chain, 4)
+	for i := range chains {
+		addr := "node" + strconv.Itoa(i)
+		c, err := NewChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.conf = conf
+		c.conf.MyPort = addr
+		// as in Init, trustees accept messages before they start
+		if i < len(keys) {
+			c.conf.PrivateKey = keys[i]
+			c.openKeyGenSession(i + 1)
+		}
+		c.SetTransport(n.Node(addr))
+		if err = c.Listen(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer c.listener.Close()
+		chains[i] = c
+	}
+
+	errs := make(chan error, len(chains))
+	for _, c := range chains {
+		go func(c *Chain) {
+			errs <- c.GenerateElectionKey()
+		}(c)
+	}
+
+	// peers may call a trustee while it replaces its configuration
+	done := make(chan struct{})
+	called := make(chan struct{})
+	go func() {
+		defer close(called)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var reply ParamsReply
+			chains[0].GetParams(&struct{}{}, &reply)
+			chains[0].ReceiveTransaction(new(Transaction), nil)
+			chains[0].ReceiveKeyShare(new(ElectionSecret), nil)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for range chains {
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	<-called
+
+	// every node has the same genesis block, and the share of each
+	// trustee matches its verification key
+	genesis := chains[0].GenesisHash()
+	for i, c := range chains {
+		if !c.hasGenesis() || c.GenesisHash() != genesis {
+			t.Error("For input node", i, "expected the genesis block of the other nodes\n")
+		}
+	}
+	for i, c := range chains[:len(keys)] {
+		share := c.conf.ElectionDecryptionShare
+		vk, err := c.conf.ElectionKey.PublicKey.VerificationKey(share)
+		if err != nil || share.X.Int64() != int64(i+1) || vk.Cmp(c.conf.ElectionVerificationKeys[i]) != 0 {
+			t.Error("For input trustee", i, "expected a share matching its verification key, got", err, "\n")
+		}
+	}
+
+	// the key is only generated once, and before the chain is run
+	if err = chains[0].GenerateElectionKey(); err != KeyGeneratedError {
+		t.Error("Expected", KeyGeneratedError, "when generating the key again, got", err, "\n")
+	}
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run(context.Background())
+	for {
+		c.mu.Lock()
+		ran := c.ran
+		c.mu.Unlock()
+		if ran {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err = c.GenerateElectionKey(); err != ChainRanError {
+		t.Error("Expected", ChainRanError, "when generating the key of a running chain, got", err, "\n")
+	}
+	c.Stop()
+}
+
+func TestReceiveKeyGenMessage(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewTestTrustees(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.conf = conf
+
+	msg := crypto.KeyGenMessage{From: 2, To: 1, Round: 0, Values: []*big.Int{big.NewInt(1)}}
+	other := crypto.KeyGenMessage{From: 2, To: 1, Round: 0, Values: []*big.Int{big.NewInt(2)}}
+	if err = c.ReceiveKeyGenMessage(SignTestKeyGenMessage(&msg, &keys[1]), nil); err != KeyGenNotStartedError {
+		t.Error("Expected", KeyGenNotStartedError, "before key generation starts, got", err, "\n")
+	}
+	c.openKeyGenSession(1)
+
+	var tests = []struct {
+		name     string
+		msg      *SignedKeyGenMessage
+		expected error
+	}{
+		{"signed message", SignTestKeyGenMessage(&msg, &keys[1]), nil},
+		{"resent message", SignTestKeyGenMessage(&msg, &keys[1]), nil},
+		{"different message", SignTestKeyGenMessage(&other, &keys[1]), ConflictingKeyGenMessageError},
+		{"signed by another trustee", SignTestKeyGenMessage(&other, &keys[2]), InvalidKeyGenSignatureError},
+		{"unsigned message", &SignedKeyGenMessage{Message: other}, InvalidKeyGenSignatureError},
+		{"message for another trustee", SignTestKeyGenMessage(&crypto.KeyGenMessage{From: 2, To: 3}, &keys[1]),
+			crypto.InvalidKeyGenMessageError},
+		{"message from an unknown trustee", SignTestKeyGenMessage(&crypto.KeyGenMessage{From: 4, To: 1}, &keys[1]),
+			crypto.InvalidKeyGenMessageError},
+	}
+
+	for _, test := range tests {
+		if err = c.ReceiveKeyGenMessage(test.msg, nil); err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+		}
+	}
+
+	// only the first message from the trustee is kept
+	kept := c.keyGenSession().messages[0][2]
+	if kept.Values[0].Cmp(msg.Values[0]) != 0 {
+		t.Error("Expected the first message to be kept, got", kept.Values, "\n")
+	}
+}
+
+func TestFetchElectionKey(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := conf.ElectionKey.PublicKey
+	if _, err = NewTestTrustees(&conf, 3); err != nil {
+		t.Fatal(err)
+	}
+	vks := []*big.Int{big.NewInt(2), big.NewInt(3), big.NewInt(5)}
+
+	// the key with a different value in place of the one derived
+	// from N, which every trustee agrees on
+	changed := func(change func(k *crypto.PublicKey)) crypto.PublicKey {
+		k := crypto.PublicKey{
+			N:         key.N,
+			NSquared:  new(big.Int).Set(key.NSquared),
+			Generator: new(big.Int).Set(key.Generator),
+		}
+		change(&k)
+		return k
+	}
+	other, err := crypto.GenerateKeyPair(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		keys     []crypto.PublicKey
+		expected error
+	}{
+		{"same key", []crypto.PublicKey{key, key, key}, nil},
+		{"different key", []crypto.PublicKey{key, key, other.PublicKey}, TrusteeDisagreementError},
+		{"different generator", []crypto.PublicKey{
+			changed(func(k *crypto.PublicKey) { k.Generator.Add(k.Generator, big.NewInt(1)) }),
+			changed(func(k *crypto.PublicKey) { k.Generator.Add(k.Generator, big.NewInt(1)) }),
+			changed(func(k *crypto.PublicKey) { k.Generator.Add(k.Generator, big.NewInt(1)) }),
+		}, InvalidElectionKeyError},
+		{"different generator from one trustee", []crypto.PublicKey{
+			key, key, changed(func(k *crypto.PublicKey) { k.Generator.Add(k.Generator, big.NewInt(1)) }),
+		}, InvalidElectionKeyError},
+		{"different modulus", []crypto.PublicKey{
+			key, changed(func(k *crypto.PublicKey) { k.NSquared.Add(k.NSquared, big.NewInt(1)) }), key,
+		}, InvalidElectionKeyError},
+	}
+
+	for _, test := range tests {
+		n := NewMemoryNetwork(1)
+		chains := make([]*Chain, len(test.keys)+1)
+		for i := range chains {
+			addr := "node" + strconv.Itoa(i)
+			c, err := NewChain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.conf = conf
+			c.conf.MyPort = addr
+			if i < len(test.keys) {
+				c.publishElectionKey(&PublicElectionKey{Key: test.keys[i], VerificationKeys: vks})
+			}
+			c.SetTransport(n.Node(addr))
+			if err = c.Listen(addr); err != nil {
+				t.Fatal(err)
+			}
+			defer c.listener.Close()
+			chains[i] = c
+		}
+
+		voter := chains[len(test.keys)]
+		if err = voter.fetchElectionKey(); err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+		}
+		if err == nil && !validElectionKey(&voter.conf.ElectionKey.PublicKey) {
+			t.Error("For input", test.name, "expected the key of the trustees, got",
+				voter.conf.ElectionKey.PublicKey, "\n")
+		}
+	}
+}
+
+// NewTestTrustees sets the configuration conf to generate the
+// election key with n trustees, at the addresses of the chains
+// made by NewTestNetwork, and returns their keys.
+func NewTestTrustees(conf *Configuration, n int) (keys []dsa.PrivateKey, err error) {
+
+	conf.ElectionKey = crypto.PrivateKey{}
+	conf.DistributedKeyGeneration = true
+	conf.ElectionThreshold = 2
+	conf.KeyGenerationBits = 64
+	conf.Trustees = make([]string, n)
+	conf.TrusteeKeys = make([]dsa.PublicKey, n)
+	keys = make([]dsa.PrivateKey, n)
+	for i := range keys {
+		keys[i].PublicKey.Parameters = conf.PrivateKey.PublicKey.Parameters
+		if err = dsa.GenerateKey(&keys[i], rand.Reader); err != nil {
+			return nil, err
+		}
+		conf.Trustees[i] = "node" + strconv.Itoa(i)
+		conf.TrusteeKeys[i] = keys[i].PublicKey
+	}
+	return keys, nil
+}
+
+// SignTestKeyGenMessage returns the key generation message msg
+// for the test election, signed with key.
+func SignTestKeyGenMessage(msg *crypto.KeyGenMessage, key *dsa.PrivateKey) *SignedKeyGenMessage {
+	hash := keyGenMessageHash("test", msg)
+	return &SignedKeyGenMessage{Message: *msg, Signature: *crypto.SignHash(key, &hash)}
+}
@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/CPSSD/voting/src/crypto"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var (
+	NoSuchPeerError   = errors.New("No chain is served at the address on the network.")
+	AddressInUseError = errors.New("A chain is already served at the address on the network.")
+	PartitionedError  = errors.New("The peer is not reachable across a partition of the network.")
+	MessageLostError  = errors.New("The message was lost by the network.")
+)
+
+// MemoryNetwork connects chains in the same process, without
+// opening any ports, so that the nodes of an election can be
+// simulated. Each node uses the MemoryTransport returned by Node.
+// The network may delay calls, lose them, and be partitioned.
+// Whether a call is lost only depends on the seed of the network,
+// the nodes making and receiving it, its method, and how many
+// calls of the method the one node has made to the other before,
+// so it does not depend on how the goroutines of the nodes are
+// scheduled, except for concurrent calls of the same method
+// between the same nodes.
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	servers   map[string]*rpc.Server
+	seed      int64
+	calls     map[memoryCall]uint64
+	latency   time.Duration
+	loss      float64
+	partition map[string]int
+}
+
+// memoryCall identifies the calls of a method from one node of a
+// MemoryNetwork to another, which are counted.
+type memoryCall struct {
+	from, to, method string
+}
+
+// NewMemoryNetwork returns a MemoryNetwork which chooses the
+// messages it loses with the given seed.
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		servers:   make(map[string]*rpc.Server, 0),
+		seed:      seed,
+		calls:     make(map[memoryCall]uint64, 0),
+		partition: make(map[string]int, 0),
+	}
+}
+
+// Node returns the Transport of the node at the address addr.
+func (n *MemoryNetwork) Node(addr string) *MemoryTransport {
+	return &MemoryTransport{network: n, addr: addr}
+}
+
+// SetLatency will make every call on the network take at least d
+// before it is delivered.
+func (n *MemoryNetwork) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency = d
+}
+
+// SetLoss will make the network lose each call with the
+// probability p.
+func (n *MemoryNetwork) SetLoss(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loss = p
+}
+
+// Partition will split the network into the groups of addresses,
+// so that nodes can only reach nodes in the same group. Addresses
+// which are not in any of the groups form one more group.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.partition = make(map[string]int, 0)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.partition[addr] = i + 1
+		}
+	}
+}
+
+// Heal will remove any partition of the network.
+func (n *MemoryNetwork) Heal() {
+	n.Partition()
+}
+
+// reachable returns true if the node at from can reach the node
+// at to, with the network locked.
+func (n *MemoryNetwork) reachable(from, to string) bool {
+	return n.partition[from] == n.partition[to]
+}
+
+// deliver decides whether a call of method from the node at from
+// to the node at to is delivered, and returns the delay before
+// it is.
+func (n *MemoryNetwork) deliver(from, to, method string) (delay time.Duration, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.reachable(from, to) {
+		return 0, PartitionedError
+	}
+	call := memoryCall{from: from, to: to, method: method}
+	seq := n.calls[call]
+	n.calls[call] = seq + 1
+	if n.loss > 0 && n.draw(call, seq) < n.loss {
+		return 0, MessageLostError
+	}
+	return n.latency, nil
+}
+
+// draw returns a number in [0, 1) for the call with the sequence
+// number seq, which is the same for every network with the seed.
+func (n *MemoryNetwork) draw(call memoryCall, seq uint64) float64 {
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, n.seed)
+	crypto.WriteString(&buf, call.from)
+	crypto.WriteString(&buf, call.to)
+	crypto.WriteString(&buf, call.method)
+	binary.Write(&buf, binary.BigEndian, seq)
+	hash := sha256.Sum256(buf.Bytes())
+
+	// the top 53 bits fill the mantissa of a float64
+	return float64(binary.BigEndian.Uint64(hash[:])>>11) / (1 << 53)
+}
+
+// MemoryTransport is the Transport of one node of a MemoryNetwork.
+type MemoryTransport struct {
+	network *MemoryNetwork
+	addr    string
+}
+
+// Dial opens a connection to the chain served at addr on the
+// network, if it can be reached from this node.
+func (t *MemoryTransport) Dial(addr string) (Conn, error) {
+
+	n := t.network
+	n.mu.Lock()
+	server, ok := n.servers[addr]
+	reachable := n.reachable(t.addr, addr)
+	n.mu.Unlock()
+	if !ok {
+		return nil, NoSuchPeerError
+	}
+	if !reachable {
+		return nil, PartitionedError
+	}
+
+	// the calls are encoded as they would be over TCP, so that the
+	// nodes do not share any memory
+	client, conn := net.Pipe()
+	go server.ServeConn(conn)
+	return &memoryConn{Client: rpc.NewClient(client), transport: t, to: addr}, nil
+}
+
+// Broadcast calls the RPC function method with args on each of the
+// peers, without waiting for them to reply.
+func (t *MemoryTransport) Broadcast(peers []string, method string, args interface{}) {
+	broadcast(t, peers, method, args)
+}
+
+// Serve makes the RPC functions of the chain c available on the
+// network at the address of this node, whatever addr is.
+func (t *MemoryTransport) Serve(addr string, c *Chain) (Listener, error) {
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Chain", c); err != nil {
+		return nil, err
+	}
+
+	n := t.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.servers[t.addr]; ok {
+		return nil, AddressInUseError
+	}
+	n.servers[t.addr] = server
+	return &memoryListener{network: n, addr: t.addr}, nil
+}
+
+// memoryConn is a connection of a MemoryTransport, whose calls may
+// be delayed or lost.
+type memoryConn struct {
+	*rpc.Client
+	transport *MemoryTransport
+	to        string
+}
+
+// Call calls the RPC function method with args, once the network
+// delivers the call.
+func (c *memoryConn) Call(method string, args, reply interface{}) error {
+	delay, err := c.transport.network.deliver(c.transport.addr, c.to, method)
+	if err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	return c.Client.Call(method, args, reply)
+}
+
+// memoryListener is the Listener of a MemoryTransport.
+type memoryListener struct {
+	network *MemoryNetwork
+	addr    string
+}
+
+// Addr returns the address which the chain is served at.
+func (l *memoryListener) Addr() string {
+	return l.addr
+}
+
+// Close will stop serving the chain on the network.
+func (l *memoryListener) Close() error {
+	l.network.mu.Lock()
+	defer l.network.mu.Unlock()
+	delete(l.network.servers, l.addr)
+	return nil
+}
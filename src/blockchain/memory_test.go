@@ -0,0 +1,324 @@
+package blockchain
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryNetwork(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewMemoryNetwork(1)
+	chains, stop, err := NewTestNetwork(n, conf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	a, b := chains[0], chains[1]
+
+	var tests = []struct {
+		name     string
+		change   func()
+		expected error
+	}{
+		{"connected peer", func() {}, nil},
+		{"lost messages", func() { n.SetLoss(1) }, MessageLostError},
+		{"partitioned peer", func() { n.Partition([]string{a.Addr()}) }, PartitionedError},
+		{"healed partition", func() { n.Heal() }, nil},
+		{"stopped peer", func() { b.listener.Close() }, NoSuchPeerError},
+	}
+
+	for _, test := range tests {
+		test.change()
+		var reply ParamsReply
+		if err = CallTestPeer(a, b.Addr(), "Chain.GetParams", &reply); err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+		}
+		if err == nil && reply.GenesisHash != b.GenesisHash() {
+			t.Error("For input", test.name, "expected the reply of the peer\n")
+		}
+		n.SetLoss(0)
+	}
+
+	// calls are delayed by the latency of the network
+	n.SetLatency(100 * time.Millisecond)
+	start := time.Now()
+	var reply ParamsReply
+	if err = CallTestPeer(b, a.Addr(), "Chain.GetParams", &reply); err != nil || time.Since(start) < 100*time.Millisecond {
+		t.Error("Expected the call to be delayed, got", time.Since(start), err, "\n")
+	}
+}
+
+func TestMemoryNetworkLoss(t *testing.T) {
+
+	// each node calls each other node concurrently, and the calls
+	// which are lost only depend on the seed of the network
+	lost := func(seed int64) map[string][]bool {
+		n := NewMemoryNetwork(seed)
+		n.SetLoss(0.5)
+		results := make(map[string][]bool, 0)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				from, to := NewTestPeer(i), NewTestPeer(j)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					calls := make([]bool, 50)
+					for k := range calls {
+						_, err := n.deliver(from, to, "Chain.GetParams")
+						calls[k] = err == MessageLostError
+					}
+					mu.Lock()
+					results[from+to] = calls
+					mu.Unlock()
+				}()
+			}
+		}
+		wg.Wait()
+		return results
+	}
+
+	first := lost(1)
+	if again := lost(1); !reflect.DeepEqual(first, again) {
+		t.Error("Expected the same calls to be lost with the same seed\n")
+	}
+	if other := lost(2); reflect.DeepEqual(first, other) {
+		t.Error("Expected other calls to be lost with another seed\n")
+	}
+	var count int
+	for _, calls := range first {
+		for _, l := range calls {
+			if l {
+				count++
+			}
+		}
+	}
+	if count < 150 || count > 300 {
+		t.Error("Expected about half of the 450 calls to be lost, got", count, "\n")
+	}
+}
+
+func TestNetworkFork(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+	n := NewMemoryNetwork(1)
+	chains, stop, err := NewTestNetwork(n, conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// while the network is partitioned, the first node mines a block
+	// of its own, and the others build a longer chain together
+	n.Partition([]string{chains[0].Addr()}, []string{chains[1].Addr(), chains[2].Addr()})
+	if err = MineTestBlock(chains[0], "token0"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err = MineTestBlock(chains[1], "token"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+		if !WaitForTestBlocks(chains[2], i+1) {
+			t.Fatal("Expected the block to reach the node in the same partition")
+		}
+	}
+	if len(chains[0].blocks()) != 2 {
+		t.Error("Expected the blocks not to cross the partition\n")
+	}
+
+	// once it is healed, the next block makes every node switch to
+	// the chain with the most work
+	n.Heal()
+	if err = MineTestBlock(chains[2], "token3"); err != nil {
+		t.Fatal(err)
+	}
+	tip := chains[2].blocks()[3].Proof
+	for i, c := range chains {
+		if !WaitForTestBlocks(c, 4) || c.blocks()[3].Proof != tip {
+			t.Error("For input node", i, "expected the chain with the most work\n")
+		}
+	}
+
+	// the vote of the rolled back block is pending again
+	pool := chains[0].snapshot().Pool
+	if len(pool) != 1 || pool[0].Header.VoteToken != "token0" {
+		t.Error("Expected the rolled back vote to be returned to the pool, got", len(pool), "votes\n")
+	}
+
+	// every node agrees on the tally of the chain
+	var tallies []map[string]string
+	for _, c := range chains {
+		tally, err := c.conf.ElectionFormat.Tally(c.CollectBallots(), &c.conf.ElectionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totals := make(map[string]string, len(tally.Totals))
+		for name, total := range tally.Totals {
+			totals[name] = total.String()
+		}
+		tallies = append(tallies, totals)
+	}
+	if !reflect.DeepEqual(tallies[0], tallies[1]) || !reflect.DeepEqual(tallies[1], tallies[2]) {
+		t.Error("Expected every node to agree on the tally, got", tallies, "\n")
+	}
+}
+
+func TestShareGossip(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := NewTestKeyShares(&conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewMemoryNetwork(1)
+	n.SetLoss(0.3)
+	n.SetLatency(time.Millisecond)
+	chains, stop, err := NewTestNetwork(n, conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// each node starts with a share of its own
+	all := make(map[string]ElectionSecret, 0)
+	for i, c := range chains {
+		c.addShare(shares[i])
+		all[shares[i].Lambda.X.String()] = shares[i]
+	}
+
+	// a partitioned node only has its own share
+	n.Partition([]string{chains[2].Addr()})
+	GossipTestShares(chains, 5)
+	if shares := chains[2].keyShares(); len(shares) != 1 {
+		t.Error("Expected the partitioned node to have one share, got", len(shares), "\n")
+	}
+
+	// despite lost messages, every share reaches every node once
+	// the partition is healed
+	n.Heal()
+	for round := 0; round < 20; round++ {
+		GossipTestShares(chains, 1)
+		done := true
+		for _, c := range chains {
+			done = done && len(c.keyShares()) == len(all)
+		}
+		if done {
+			break
+		}
+	}
+	for i, c := range chains {
+		if shares := c.keyShares(); !reflect.DeepEqual(shares, all) {
+			t.Error("For input node", i, "expected every share, got", len(shares), "\n")
+		}
+	}
+}
+
+// NewTestNetwork returns n chains with the configuration conf,
+// which are peers on the network, and run their chain update
+// processes. Calling stop stops the chains.
+func NewTestNetwork(network *MemoryNetwork, conf Configuration, n int) (chains []*Chain, stop func(), err error) {
+
+	stops := make([]func(), 0)
+	stop = func() {
+		for _, s := range stops {
+			s()
+		}
+	}
+
+	peers := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		addr := "node" + strconv.Itoa(i)
+		peers[addr] = true
+
+		conf.MyPort = addr
+		c, err := NewTestChainFrom(conf, NewMemoryStore())
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+		c.SetTransport(network.Node(addr))
+		if err = c.Listen(addr); err != nil {
+			stop()
+			return nil, nil, err
+		}
+
+		// the signals to start mining are not needed
+		start, stopUpdates := RunTestChainUpdates(c)
+		done := make(chan bool)
+		go func() {
+			for {
+				select {
+				case <-start:
+				case <-done:
+					return
+				}
+			}
+		}()
+		stops = append(stops, func() {
+			close(done)
+			stopUpdates()
+		})
+		chains = append(chains, c)
+	}
+
+	for _, c := range chains {
+		c.addPeers(peers)
+	}
+	return chains, stop, nil
+}
+
+// MineTestBlock adds a block containing a vote for the vote token
+// vt to the chain of c, and sends it to the peers of c.
+func MineTestBlock(c *Chain, vt string) (err error) {
+
+	blocks := c.blocks()
+	bl, err := NewTestBlock(c, &blocks[len(blocks)-1], vt)
+	if err != nil {
+		return err
+	}
+	if !c.addMinedBlock(bl) {
+		return InvalidChainError
+	}
+	c.sendBlock(bl)
+	return nil
+}
+
+// GossipTestShares makes each of the chains broadcast the key
+// shares it knows for the given number of rounds.
+func GossipTestShares(chains []*Chain, rounds int) {
+	for i := 0; i < rounds; i++ {
+		for _, c := range chains {
+			c.broadcastKeyShares()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// CallTestPeer calls the RPC function method of the peer at addr
+// from the chain c, with its transport.
+func CallTestPeer(c *Chain, addr, method string, reply interface{}) (err error) {
+
+	conn, err := c.transport.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Call(method, &struct{}{}, reply)
+}
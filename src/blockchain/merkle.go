@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+)
+
+// MerkleStep is one level of a merkle inclusion proof. Hash is the
+// hash of the sibling of the node on the path from a leaf to the
+// root, and Left is true if the sibling is on the left.
+type MerkleStep struct {
+	Hash [32]byte
+	Left bool
+}
+
+// MerkleProof proves that a leaf is in the merkle tree with a
+// given root. The steps go from the leaf up to the root.
+type MerkleProof []MerkleStep
+
+// The hashes of leaves and of nodes are prefixed with different
+// bytes, so that the hash of a node can never be passed off as
+// the hash of a transaction in the tree.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// merkleHash will get the hash of a slice of transactions.
+// The leaves of the tree are made from the hashes of each
+// transaction. The tree is split with the first half of the
+// transactions on the left, and the root of an empty tree is
+// zero.
+func merkleHash(trs []Transaction) (hash [32]byte) {
+	l := len(trs)
+	if l == 0 {
+		return hash
+	}
+	if l == 1 {
+		return merkleLeaf(trs[0].Hash())
+	}
+	hl := merkleHash(trs[:l/2])
+	hr := merkleHash(trs[l/2:])
+	return merkleNode(hl, hr)
+}
+
+// merkleLeaf returns the hash of the leaf of the tree for the
+// transaction with the given hash.
+func merkleLeaf(hash [32]byte) [32]byte {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, hash[:]...))
+}
+
+// merkleNode returns the hash of a node of the tree with the
+// children l and r.
+func merkleNode(l, r [32]byte) [32]byte {
+	data := append([]byte{merkleNodePrefix}, l[:]...)
+	return sha256.Sum256(append(data, r[:]...))
+}
+
+// merkleProof returns the proof that the transaction at index i
+// of trs is in the tree with the root merkleHash(trs). The proof
+// is nil if i is not an index of trs.
+func merkleProof(trs []Transaction, i int) (proof MerkleProof) {
+
+	if i < 0 || i >= len(trs) {
+		return nil
+	}
+	proof = make(MerkleProof, 0)
+
+	// walk down the tree to the leaf, then reverse the steps
+	for len(trs) > 1 {
+		half := len(trs) / 2
+		if i < half {
+			proof = append(proof, MerkleStep{Hash: merkleHash(trs[half:]), Left: false})
+			trs = trs[:half]
+		} else {
+			proof = append(proof, MerkleStep{Hash: merkleHash(trs[:half]), Left: true})
+			trs = trs[half:]
+			i -= half
+		}
+	}
+	for l, r := 0, len(proof)-1; l < r; l, r = l+1, r-1 {
+		proof[l], proof[r] = proof[r], proof[l]
+	}
+	return proof
+}
+
+// Root returns the root of the merkle tree which the proof leads
+// to from the leaf of the transaction with the given hash.
+func (p MerkleProof) Root(tr [32]byte) [32]byte {
+	hash := merkleLeaf(tr)
+	for _, step := range p {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+	return hash
+}
+
+// VerifyMerkleProof will check that the proof shows that the
+// transaction with the hash tr is in the merkle tree with the
+// given root.
+func VerifyMerkleProof(tr, root [32]byte, proof MerkleProof) bool {
+	return proof.Root(tr) == root
+}
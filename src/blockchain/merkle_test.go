@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+
+	var empty [32]byte
+	if root := merkleHash(nil); root != empty {
+		t.Error("Expected the root of an empty tree to be zero\n")
+	}
+	if proof := merkleProof(nil, 0); proof != nil {
+		t.Error("Expected no proof for an empty tree\n")
+	}
+
+	for n := 1; n <= 9; n++ {
+		trs := NewMerkleTestTransactions(n)
+		root := merkleHash(trs)
+
+		for i := range trs {
+			proof := merkleProof(trs, i)
+			if !VerifyMerkleProof(trs[i].Hash(), root, proof) {
+				t.Error("For input", n, i, "expected the proof to be valid\n")
+			}
+
+			// the proof does not hold for any other transaction
+			other := trs[(i+1)%n].Hash()
+			if n > 1 && VerifyMerkleProof(other, root, proof) {
+				t.Error("For input", n, i, "expected the proof not to hold for another transaction\n")
+			}
+
+			// nor for a changed proof
+			if len(proof) > 0 {
+				changed := append(MerkleProof{}, proof...)
+				changed[0].Left = !changed[0].Left
+				if VerifyMerkleProof(trs[i].Hash(), root, changed) {
+					t.Error("For input", n, i, "expected a changed proof to be invalid\n")
+				}
+			}
+		}
+
+		if proof := merkleProof(trs, n); proof != nil {
+			t.Error("For input", n, "expected no proof for a transaction outside the tree\n")
+		}
+	}
+
+	// the hash of a node is not accepted as a transaction in the tree
+	trs := NewMerkleTestTransactions(4)
+	node := merkleHash(trs[:2])
+	proof := MerkleProof{{Hash: merkleHash(trs[2:]), Left: false}}
+	if VerifyMerkleProof(node, merkleHash(trs), proof) {
+		t.Error("Expected the hash of a node not to be proven as a transaction\n")
+	}
+}
+
+// NewMerkleTestTransactions returns n transactions with different
+// vote tokens, which are only used for their hashes.
+func NewMerkleTestTransactions(n int) []Transaction {
+	trs := make([]Transaction, n)
+	for i := range trs {
+		trs[i].Header.VoteToken = "token" + strconv.Itoa(i)
+	}
+	return trs
+}
@@ -0,0 +1,135 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+var (
+	InvalidParamsError       = errors.New("Chain parameters of the election are not valid.")
+	ParamsMismatchError      = errors.New("Peer does not agree on the chain parameters of the election.")
+	PoolFullError            = errors.New("Pool of pending transactions is full.")
+	TransactionTooLargeError = errors.New("Transaction is too large to fit in a block.")
+)
+
+// ChainParams contains the parameters of an election's chain,
+// which trade the throughput of the chain against its security.
+// They are part of the election manifest, so every node of the
+// election uses the same parameters.
+type ChainParams struct {
+	// a block holds at most BlockSize transactions, whose
+	// encodings take at most MaxBlockBytes bytes
+	BlockSize     int
+	MaxBlockBytes int
+
+	// at most MaxPoolSize transactions wait to be mined
+	MaxPoolSize int
+
+	// Difficulty is the number of hex zero characters which the
+	// proofs of work of the first blocks of the chain must start
+	// with, before the target is changed
+	Difficulty int
+
+	// the number of seconds between checks of the pool for a new
+	// block, between syncs with peers, and which pending
+	// transactions wait for a block to fill before a partial
+	// block is mined
+	HashingDelay int
+	SyncDelay    int
+	MaxBlockWait int
+}
+
+// DefaultChainParams returns the parameters used by elections
+// which do not set their own.
+func DefaultChainParams() ChainParams {
+	return ChainParams{
+		BlockSize:     4,
+		MaxBlockBytes: 1 << 20,
+		MaxPoolSize:   10000,
+		Difficulty:    5,
+		HashingDelay:  5,
+		SyncDelay:     10,
+		MaxBlockWait:  60,
+	}
+}
+
+// validate will check that the parameters can be used by a chain.
+func (p *ChainParams) validate() (err error) {
+	for _, v := range []int{p.BlockSize, p.MaxBlockBytes, p.MaxPoolSize,
+		p.HashingDelay, p.SyncDelay, p.MaxBlockWait} {
+		if v <= 0 {
+			return InvalidParamsError
+		}
+	}
+	if p.Difficulty < 0 || p.Difficulty > 64 {
+		return InvalidParamsError
+	}
+	return nil
+}
+
+// writeParams writes the parameters to buf.
+func writeParams(buf *bytes.Buffer, p *ChainParams) {
+	for _, v := range []int{p.BlockSize, p.MaxBlockBytes, p.MaxPoolSize,
+		p.Difficulty, p.HashingDelay, p.SyncDelay, p.MaxBlockWait} {
+		binary.Write(buf, binary.BigEndian, int64(v))
+	}
+}
+
+// size returns the length of the encoding of the transaction,
+// which counts towards the MaxBlockBytes of a block.
+func (t *Transaction) size() int {
+	return len(t.Header.Bytes()) + len(t.Ballot.Bytes())
+}
+
+// ParamsReply contains the chain parameters of a node, and the
+// hash of its genesis block, which is zero if it does not yet
+// have one.
+type ParamsReply struct {
+	GenesisHash [32]byte
+	Params      ChainParams
+}
+
+// GetParams is an RPC function which returns the chain parameters
+// of the node, so that peers can check that they agree on them.
+// Until the node has a genesis block, its parameters may still be
+// replaced by the ones in the manifest, and NoGenesisError is
+// returned.
+func (c *Chain) GetParams(_ *struct{}, r *ParamsReply) error {
+	r.GenesisHash = c.GenesisHash()
+	if r.GenesisHash == *new([32]byte) {
+		return NoGenesisError
+	}
+	r.Params = c.conf.Params
+	return nil
+}
+
+// checkParams will check that a peer, which sent the reply, agrees
+// on the chain parameters and, if both have one, on the genesis
+// block of the election.
+func (c *Chain) checkParams(r *ParamsReply) (err error) {
+
+	var zero [32]byte
+	genesis := c.GenesisHash()
+	if r.Params != c.conf.Params ||
+		(genesis != zero && r.GenesisHash != zero && r.GenesisHash != genesis) {
+		return ParamsMismatchError
+	}
+	return nil
+}
+
+// checkPeerParams will ask the peer for its chain parameters over
+// the connection conn, and check that it agrees on them.
+func (c *Chain) checkPeerParams(peer string, conn Conn) (err error) {
+
+	var reply ParamsReply
+	if err = conn.Call("Chain.GetParams", &struct{}{}, &reply); err != nil {
+		return err
+	}
+	if err = c.checkParams(&reply); err != nil {
+		log.Println("Peer", peer, "does not agree on the chain parameters")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"net/rpc"
+	"testing"
+)
+
+func TestChainParams(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the manifest must have parameters the chain can use
+	var tests = []struct {
+		name   string
+		change func(p *ChainParams)
+		err    error
+	}{
+		{"test parameters", func(p *ChainParams) {}, nil},
+		{"no parameters", func(p *ChainParams) { *p = ChainParams{} }, InvalidParamsError},
+		{"empty blocks", func(p *ChainParams) { p.BlockSize = 0 }, InvalidParamsError},
+		{"no block bytes", func(p *ChainParams) { p.MaxBlockBytes = -1 }, InvalidParamsError},
+		{"no pool", func(p *ChainParams) { p.MaxPoolSize = 0 }, InvalidParamsError},
+		{"no hashing delay", func(p *ChainParams) { p.HashingDelay = 0 }, InvalidParamsError},
+		{"difficulty too high", func(p *ChainParams) { p.Difficulty = 65 }, InvalidParamsError},
+	}
+	for _, test := range tests {
+		changed := conf
+		test.change(&changed.Params)
+		if _, err = NewTestChainFrom(changed, NewMemoryStore()); err != test.err {
+			t.Error("For input", test.name, "expected", test.err, "got", err, "\n")
+		}
+	}
+
+	// the parameters in the manifest replace those of the node
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := NewGenesisBlock(NewManifest(&c.conf))
+	other := conf
+	other.Params.BlockSize = 1
+	d, err := NewTestChainFrom(other, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.setGenesis(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if d.conf.Params != c.conf.Params {
+		t.Error("Expected the parameters of the manifest to be used\n")
+	}
+
+	// a block may not hold more transactions than the parameters allow
+	d.conf.Params.BlockSize = 1
+	blocks := d.blocks()
+	bl := NewBlock()
+	for _, vt := range []string{testToken, otherToken} {
+		tr, err := NewTestTransactionFor(d, vt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl.addTransaction(tr)
+	}
+	bl.setParent(&blocks[0])
+	bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+	if valid, _ := d.validate(&[]Block{blocks[0], *bl}); valid {
+		t.Error("Expected a block with too many transactions to be invalid\n")
+	}
+}
+
+func TestPeerParams(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := c.GenesisHash()
+
+	var tests = []struct {
+		name   string
+		change func(r *ParamsReply)
+		err    error
+	}{
+		{"same parameters", func(r *ParamsReply) {}, nil},
+		{"no genesis block yet", func(r *ParamsReply) { r.GenesisHash = [32]byte{} }, nil},
+		{"other genesis block", func(r *ParamsReply) { r.GenesisHash[0]++ }, ParamsMismatchError},
+		{"larger blocks", func(r *ParamsReply) { r.Params.BlockSize++ }, ParamsMismatchError},
+		{"lower difficulty", func(r *ParamsReply) { r.Params.Difficulty-- }, ParamsMismatchError},
+		{"longer sync delay", func(r *ParamsReply) { r.Params.SyncDelay++ }, ParamsMismatchError},
+	}
+	for _, test := range tests {
+		r := &ParamsReply{GenesisHash: genesis, Params: c.conf.Params}
+		test.change(r)
+		if err = c.checkParams(r); err != test.err {
+			t.Error("For input", test.name, "expected", test.err, "got", err, "\n")
+		}
+	}
+
+	// a peer of another election is found over rpc
+	conf.Params.BlockSize++
+	peer, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := ServeTestChain(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = c.checkPeerParams(addr, conn); err != ParamsMismatchError {
+		t.Error("Expected", ParamsMismatchError, "for a peer with other parameters, got", err, "\n")
+	}
+	if err = peer.checkPeerParams(addr, conn); err != nil {
+		t.Error("Expected a peer to agree with itself, got", err, "\n")
+	}
+}
+
+func TestPoolLimits(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTestTransactionFor(c, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction which can not fit in a block is rejected
+	c.conf.Params.MaxBlockBytes = tr.size() - 1
+	if err = c.ReceiveTransaction(tr, nil); err != TransactionTooLargeError {
+		t.Error("Expected", TransactionTooLargeError, "got", err, "\n")
+	}
+
+	// as is a transaction when the pool is full
+	c.conf.Params.MaxBlockBytes = tr.size()
+	c.conf.Params.MaxPoolSize = 1
+	c.update(func(tx *stateTx) error {
+		tx.setPool(NewMerkleTestTransactions(1))
+		return nil
+	})
+	if err = c.ReceiveTransaction(tr, nil); err != PoolFullError {
+		t.Error("Expected", PoolFullError, "got", err, "\n")
+	}
+}
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+var (
+	VoteNotInChainError         = errors.New("Vote is not in the chain yet.")
+	ReceiptBlockNotInChainError = errors.New("Block of the receipt is not in the chain.")
+	InvalidReceiptError         = errors.New("Receipt does not prove that the vote is in the block.")
+)
+
+// Receipt lets a voter check that their vote is in the chain. It
+// holds the hash of the transaction with the vote, the hash of the
+// block containing it, and the merkle proof that the transaction
+// is in the block. Like the transaction, it does not show how
+// the ballot was filled out.
+type Receipt struct {
+	TransactionHash [32]byte
+	BlockHash       [32]byte
+	Height          int
+	Proof           MerkleProof
+}
+
+// String returns the receipt in a form which can be shown to
+// the voter.
+func (r *Receipt) String() (str string) {
+	str = "Transaction hash: " + hex.EncodeToString(r.TransactionHash[:]) + "\n"
+	str = str + "Block hash:       " + hex.EncodeToString(r.BlockHash[:]) + "\n"
+	str = str + "Block height:     " + strconv.Itoa(r.Height) + "\n"
+	str = str + "Merkle proof:\n"
+	for i, step := range r.Proof {
+		side := "right"
+		if step.Left {
+			side = "left"
+		}
+		str = str + "  " + strconv.Itoa(i) + " (" + side + "): " + hex.EncodeToString(step.Hash[:]) + "\n"
+	}
+	return str
+}
+
+// GetReceipt returns the receipt for the transaction with the
+// hash tr in the current chain. The voter records the hash when
+// the vote is cast, so the receipt is for the vote they cast,
+// rather than whichever vote the chain holds for their token.
+func (c *Chain) GetReceipt(tr [32]byte) (r *Receipt, err error) {
+
+	blocks := c.blocks()
+
+	// the genesis block holds the manifest rather than votes
+	for i := 1; i < len(blocks); i++ {
+		for j := range blocks[i].Transactions {
+			if blocks[i].Transactions[j].Hash() != tr {
+				continue
+			}
+			return &Receipt{
+				TransactionHash: tr,
+				BlockHash:       blocks[i].Proof,
+				Height:          i,
+				Proof:           merkleProof(blocks[i].Transactions, j),
+			}, nil
+		}
+	}
+	return nil, VoteNotInChainError
+}
+
+// VerifyReceipt will check that the block of the receipt is in
+// the current chain, and that the receipt proves the transaction
+// is in the block.
+func (c *Chain) VerifyReceipt(r *Receipt) (err error) {
+
+	blocks := c.blocks()
+
+	if r.Height < 1 || r.Height >= len(blocks) || blocks[r.Height].Proof != r.BlockHash {
+		return ReceiptBlockNotInChainError
+	}
+	if !VerifyMerkleProof(r.TransactionHash, blocks[r.Height].Header.MerkleHash, r.Proof) {
+		return InvalidReceiptError
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestReceipt(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cast [32]byte
+	if _, err = c.GetReceipt(cast); err != VoteNotInChainError {
+		t.Error("Expected", VoteNotInChainError, "got", err, "\n")
+	}
+	for _, vt := range []string{"other token", testToken} {
+		if err = AddTestBlock(c, vt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cast = c.blocks()[2].Transactions[0].Hash()
+
+	r, err := c.GetReceipt(cast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Height != 2 || r.TransactionHash != cast {
+		t.Error("Expected the vote in block 2, got", r.Height, "\n")
+	}
+
+	// a vote with the same token, which the voter did not cast, has
+	// no receipt
+	other, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetReceipt(other.Hash()); err != VoteNotInChainError {
+		t.Error("Expected", VoteNotInChainError, "for another vote with the token, got", err, "\n")
+	}
+
+	var tests = []struct {
+		name   string
+		tamper func(r *Receipt)
+		err    error
+	}{
+		{"valid receipt", func(r *Receipt) {}, nil},
+		{"changed transaction", func(r *Receipt) { r.TransactionHash[0]++ }, InvalidReceiptError},
+		{"changed proof", func(r *Receipt) {
+			r.Proof = append(r.Proof, MerkleStep{})
+		}, InvalidReceiptError},
+		{"changed block", func(r *Receipt) { r.BlockHash[0]++ }, ReceiptBlockNotInChainError},
+		{"changed height", func(r *Receipt) { r.Height = 1 }, ReceiptBlockNotInChainError},
+		{"height past the chain", func(r *Receipt) { r.Height = 3 }, ReceiptBlockNotInChainError},
+		{"genesis block", func(r *Receipt) { r.Height = 0 }, ReceiptBlockNotInChainError},
+	}
+
+	for _, test := range tests {
+		receipt := *r
+		receipt.Proof = append(MerkleProof{}, r.Proof...)
+		test.tamper(&receipt)
+		if err = c.VerifyReceipt(&receipt); err != test.err {
+			t.Error("For input", test.name, "expected", test.err, "got", err, "\n")
+		}
+	}
+}
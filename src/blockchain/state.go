@@ -0,0 +1,225 @@
+package blockchain
+
+import (
+	"github.com/CPSSD/voting/src/election"
+	"sync"
+)
+
+// chainState contains the state of a node's chain which must be
+// kept consistent: the blocks of the main chain, the side blocks
+// kept in case their branch later has more work, the vote tokens
+// seen in the main chain, and the pool of transactions still to
+// be mined. A chainState is a snapshot, and its values are never
+// changed in place. A transaction replaces them instead, so a
+// snapshot may be read without holding a lock.
+type chainState struct {
+	Blocks []Block
+	Side   map[[32]byte]Block
+	Seen   map[string]bool
+	Pool   []Transaction
+}
+
+// stateTx is a read/write transaction on the state of the chain.
+// Its values start as those of the current state, and are changed
+// with its set methods, which record what must be written to the
+// block store when the transaction is committed. A value which is
+// assigned directly is committed without being written.
+type stateTx struct {
+	chainState
+	old chainState
+
+	blocksChanged bool
+	poolChanged   bool
+}
+
+// setBlocks will replace the blocks of the main chain.
+func (tx *stateTx) setBlocks(blocks []Block) {
+	tx.Blocks = blocks
+	tx.blocksChanged = true
+}
+
+// setSide will replace the side blocks.
+func (tx *stateTx) setSide(side map[[32]byte]Block) {
+	tx.Side = side
+}
+
+// setSeen will replace the vote tokens seen in the main chain.
+// They are not stored, as they are found again when the stored
+// chain is validated.
+func (tx *stateTx) setSeen(seen map[string]bool) {
+	tx.Seen = seen
+}
+
+// setPool will replace the pool of transactions to be mined.
+func (tx *stateTx) setPool(pool []Transaction) {
+	tx.Pool = pool
+	tx.poolChanged = true
+}
+
+// copySeen returns a copy of the vote tokens seen in the main
+// chain, which may be changed and then set.
+func (tx *stateTx) copySeen() (seen map[string]bool) {
+	seen = make(map[string]bool, len(tx.Seen))
+	for vt := range tx.Seen {
+		seen[vt] = true
+	}
+	return seen
+}
+
+// copySide returns a copy of the side blocks, which may be
+// changed and then set.
+func (tx *stateTx) copySide() (side map[[32]byte]Block) {
+	side = make(map[[32]byte]Block, len(tx.Side))
+	for k, bl := range tx.Side {
+		side[k] = bl
+	}
+	return side
+}
+
+// stateManager guards the state of a Chain. The chain, the side
+// blocks, the seen vote tokens and the pool are changed together
+// by transactions, so that a reorganization or a new transaction
+// is never seen half done. The peers, key shares, partial tallies,
+// key generation session and generated election key of the node
+// are guarded by the same lock, but are changed on their own. Whether the pool has been
+// flushed is set atomically, so that it can be read while a
+// transaction runs.
+type stateManager struct {
+	mu    sync.RWMutex
+	state chainState
+
+	peers          map[string]bool
+	keyShares      map[string]ElectionSecret
+	partialTallies map[string]election.PartialTally
+	keyGen         *keyGenSession
+	electionKey    *PublicElectionKey
+	flushed        int32
+}
+
+// newStateManager returns a stateManager for an empty chain.
+func newStateManager() *stateManager {
+	return &stateManager{
+		state: chainState{
+			Blocks: make([]Block, 0),
+			Side:   make(map[[32]byte]Block, 0),
+			Seen:   make(map[string]bool, 0),
+			Pool:   make([]Transaction, 0),
+		},
+		peers:          make(map[string]bool, 0),
+		keyShares:      make(map[string]ElectionSecret, 0),
+		partialTallies: make(map[string]election.PartialTally, 0),
+	}
+}
+
+// view returns a snapshot of the current state, which must not
+// be changed.
+func (m *stateManager) view() chainState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// update runs f as a read/write transaction on the state, while
+// no other transaction may run. If f returns nil, the changes it
+// made are committed, and passed to save before any other
+// transaction can see them. Otherwise they are discarded, and
+// the error is returned.
+func (m *stateManager) update(f func(tx *stateTx) error, save func(tx *stateTx)) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &stateTx{chainState: m.state, old: m.state}
+	if err = f(tx); err != nil {
+		return err
+	}
+	if save != nil {
+		save(tx)
+	}
+	m.state = tx.chainState
+	return nil
+}
+
+// snapshot returns a snapshot of the current state of the chain.
+func (c *Chain) snapshot() chainState {
+	return c.state.view()
+}
+
+// update runs f as a read/write transaction on the state of the
+// chain. The parts of the state which f changed are written to
+// the block store when it is committed.
+func (c *Chain) update(f func(tx *stateTx) error) (err error) {
+	return c.state.update(f, c.saveState)
+}
+
+// saveState will write the parts of the state changed by the
+// transaction tx to the block store.
+func (c *Chain) saveState(tx *stateTx) {
+	if tx.blocksChanged {
+		c.saveBlocks(tx.old.Blocks, tx.Blocks)
+	}
+	if tx.poolChanged {
+		c.savePool(tx.Pool)
+	}
+}
+
+// blocks returns the blocks of the current main chain, which must
+// not be changed.
+func (c *Chain) blocks() []Block {
+	return c.snapshot().Blocks
+}
+
+// peers returns a copy of the peers known to the node.
+func (c *Chain) peers() (peers map[string]bool) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	peers = make(map[string]bool, len(c.state.peers))
+	for p := range c.state.peers {
+		peers[p] = true
+	}
+	return peers
+}
+
+// addPeers will add the peers to those known to the node.
+func (c *Chain) addPeers(peers map[string]bool) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	for p := range peers {
+		c.state.peers[p] = true
+	}
+}
+
+// removePeer will forget the peer p.
+func (c *Chain) removePeer(p string) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	delete(c.state.peers, p)
+}
+
+// keyShares returns a copy of the shares of the election key
+// known to the node.
+func (c *Chain) keyShares() (shares map[string]ElectionSecret) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	shares = make(map[string]ElectionSecret, len(c.state.keyShares))
+	for k, sh := range c.state.keyShares {
+		shares[k] = sh
+	}
+	return shares
+}
+
+// partialTallies returns a copy of the partial tallies known to
+// the node.
+func (c *Chain) partialTallies() (partials map[string]election.PartialTally) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	partials = make(map[string]election.PartialTally, len(c.state.partialTallies))
+	for k, pt := range c.state.partialTallies {
+		partials[k] = pt
+	}
+	return partials
+}
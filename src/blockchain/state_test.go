@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestStateUpdate(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction which fails changes nothing
+	failed := errors.New("failed")
+	before := c.snapshot()
+	err = c.update(func(tx *stateTx) error {
+		tx.setPool([]Transaction{*tr})
+		tx.setSeen(map[string]bool{testToken: true})
+		return failed
+	})
+	if after := c.snapshot(); err != failed || len(after.Pool) != 0 || len(after.Seen) != 0 {
+		t.Error("Expected a failed transaction to be discarded, got", err, "\n")
+	}
+
+	// a snapshot is not changed by later transactions
+	if err = c.ReceiveTransaction(tr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Pool) != 0 || len(c.snapshot().Pool) != 1 {
+		t.Error("Expected a snapshot to keep the state it was taken from\n")
+	}
+	stored, err := c.store.Pool()
+	if err != nil || len(stored) != 1 {
+		t.Error("Expected the pool to be saved with the transaction, got", len(stored), err, "\n")
+	}
+
+	// a block mined on a chain which has since changed is dropped,
+	// and its transactions are pending again
+	genesis := c.blocks()[0]
+	bl, err := NewTestBlock(c, &genesis, otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = AddTestBlock(c, testToken); err != nil {
+		t.Fatal(err)
+	}
+	if c.addMinedBlock(bl) {
+		t.Error("Expected a block on an old tip not to be added\n")
+	}
+	state := c.snapshot()
+	if len(state.Blocks) != 2 || len(state.Pool) != 2 || state.Seen[otherToken] {
+		t.Error("Expected the transactions of the dropped block to be returned to the pool\n")
+	}
+}
+
+func TestConcurrentState(t *testing.T) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the main chain has block A1, and the branch with blocks B1
+	// and B2 has more work
+	genesis := c.blocks()[0]
+	a1, err := NewTestBlock(c, &genesis, "token0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.addMinedBlock(a1) {
+		t.Fatal("Expected a block on the tip of the chain to be added")
+	}
+	b1, err := NewTestBlock(c, &genesis, "token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := NewTestBlock(c, b1, "token2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := []Block{genesis, *b1, *b2}
+
+	trs := make([]*Transaction, 0)
+	for i := 1; i < 8; i++ {
+		tr, err := NewTestTransactionFor(c, "token"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		trs = append(trs, tr)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan bool)
+
+	// readers must always see the chain, the seen vote tokens and
+	// the pool agree with each other
+	errs := make(chan string, 4)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if msg := CheckState(c.snapshot()); msg != "" {
+					errs <- msg
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+
+	// transactions arrive, some of them more than once, while the
+	// chain is reorganized and peers are synced
+	var writers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for _, tr := range trs {
+				c.ReceiveTransaction(tr, nil)
+			}
+		}()
+	}
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		c.CurrentTransactions <- make([]Transaction, 0)
+		if err := c.reorganize(branch, seenIn(branch)); err != nil {
+			errs <- "reorganize failed: " + err.Error()
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 50; i++ {
+			var peers map[string]bool
+			c.GetPeers(&map[string]bool{NewTestPeer(i): true}, &peers)
+			c.removePeer(NewTestPeer(i - 1))
+		}
+	}()
+
+	writers.Wait()
+	close(done)
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg, "\n")
+	}
+
+	// the vote in the rolled back block is pending again, and the
+	// votes in the new branch are not
+	state := c.snapshot()
+	tokens := make([]string, 0)
+	for _, tr := range state.Pool {
+		tokens = append(tokens, tr.Header.VoteToken)
+	}
+	sort.Strings(tokens)
+	expected := []string{"token0", "token3", "token4", "token5", "token6", "token7"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Error("Expected the pool to have", expected, "got", tokens, "\n")
+	}
+	if peers := c.peers(); len(peers) != 1 || !peers[NewTestPeer(49)] {
+		t.Error("Expected only the last peer to be kept, got", peers, "\n")
+	}
+}
+
+// NewTestPeer returns the address of the ith peer of a test, at which
+// nothing is listening.
+func NewTestPeer(i int) string {
+	return "127.0.0.1:" + strconv.Itoa(i+1)
+}
+
+// CheckState checks that the seen vote tokens of the state are
+// those in its chain, and that its pool has no seen or repeated
+// vote tokens. It returns a description of the first problem found,
+// or an empty string.
+func CheckState(state chainState) (msg string) {
+
+	if !reflect.DeepEqual(state.Seen, seenIn(state.Blocks)) {
+		return "seen vote tokens do not match the chain"
+	}
+	inPool := make(map[string]bool, len(state.Pool))
+	for _, tr := range state.Pool {
+		if state.Seen[tr.Header.VoteToken] {
+			return "pool has a vote token seen in the chain: " + tr.Header.VoteToken
+		}
+		if inPool[tr.Header.VoteToken] {
+			return "pool has a repeated vote token: " + tr.Header.VoteToken
+		}
+		inPool[tr.Header.VoteToken] = true
+	}
+	return ""
+}
@@ -0,0 +1,439 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	CorruptStoreError = errors.New("The block store contains a corrupt record.")
+)
+
+// names of the files used by a FileStore
+const (
+	blocksFile = "blocks.log"
+	poolFile   = "pool.json"
+)
+
+// maxRecordSize is the largest block record which is read from
+// the log of a FileStore. A larger length can only come from a
+// partly written record.
+const maxRecordSize = 1 << 26
+
+// BlockStore persists the blocks of the chain, along with the
+// pool of transactions still to be mined, so that a node can
+// recover its state after it restarts. The vote tokens seen in
+// the chain are not stored, as they are found again when the
+// stored chain is validated.
+type BlockStore interface {
+	// AppendBlocks adds blocks to the end of the stored chain.
+	AppendBlocks(blocks []Block) error
+	// ReplaceBlocks replaces the whole stored chain.
+	ReplaceBlocks(blocks []Block) error
+	// Blocks returns the stored chain.
+	Blocks() ([]Block, error)
+
+	SavePool(pool []Transaction) error
+	Pool() ([]Transaction, error)
+
+	Close() error
+}
+
+// MemoryStore is a BlockStore which keeps its contents in
+// memory, and so does not survive a restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	blocks []Block
+	pool   []Transaction
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() (s *MemoryStore) {
+	return &MemoryStore{
+		blocks: make([]Block, 0),
+		pool:   make([]Transaction, 0),
+	}
+}
+
+// AppendBlocks adds blocks to the end of the stored chain.
+func (s *MemoryStore) AppendBlocks(blocks []Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = append(s.blocks, blocks...)
+	return nil
+}
+
+// ReplaceBlocks replaces the whole stored chain.
+func (s *MemoryStore) ReplaceBlocks(blocks []Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = append([]Block(nil), blocks...)
+	return nil
+}
+
+// Blocks returns the stored chain.
+func (s *MemoryStore) Blocks() ([]Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Block(nil), s.blocks...), nil
+}
+
+// SavePool stores the pool of transactions.
+func (s *MemoryStore) SavePool(pool []Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pool = append([]Transaction(nil), pool...)
+	return nil
+}
+
+// Pool returns the stored pool of transactions.
+func (s *MemoryStore) Pool() ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transaction(nil), s.pool...), nil
+}
+
+// Close does nothing for a MemoryStore.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore is a BlockStore which keeps its contents in a
+// directory. Blocks are kept in an append-only log, where each
+// record is the length of a json encoded block, the block and
+// its SHA-256 hash. A record which was only partly written when
+// a node crashed is discarded when the store is opened. The pool
+// is replaced atomically when saved.
+type FileStore struct {
+	mu     sync.Mutex
+	dir    string
+	blocks *os.File
+}
+
+// NewFileStore opens the FileStore in the directory dir,
+// creating it if it does not exist.
+func NewFileStore(dir string) (s *FileStore, err error) {
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s = &FileStore{dir: dir}
+	s.blocks, err = os.OpenFile(s.path(blocksFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// discard any partly written record at the end of the log
+	_, end, err := readBlocks(s.blocks)
+	if err != nil {
+		s.blocks.Close()
+		return nil, err
+	}
+	if err = s.blocks.Truncate(end); err != nil {
+		s.blocks.Close()
+		return nil, err
+	}
+	if _, err = s.blocks.Seek(end, io.SeekStart); err != nil {
+		s.blocks.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// AppendBlocks adds blocks to the end of the log, and waits for
+// them to be written to disk.
+func (s *FileStore) AppendBlocks(blocks []Block) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range blocks {
+		if err = writeBlock(s.blocks, &blocks[i]); err != nil {
+			return err
+		}
+	}
+	return s.blocks.Sync()
+}
+
+// ReplaceBlocks writes a new log containing blocks, and then
+// replaces the current log with it.
+func (s *FileStore) ReplaceBlocks(blocks []Block) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.OpenFile(s.path(blocksFile+".tmp"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for i := range blocks {
+		if err = writeBlock(tmp, &blocks[i]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path(blocksFile)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	s.blocks.Close()
+	s.blocks = tmp
+	_, err = s.blocks.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Blocks reads the chain from the log.
+func (s *FileStore) Blocks() (blocks []Block, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.blocks.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	blocks, end, err := readBlocks(s.blocks)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.blocks.Seek(end, io.SeekStart)
+	return blocks, err
+}
+
+// SavePool stores the pool of transactions.
+func (s *FileStore) SavePool(pool []Transaction) error {
+	return s.save(poolFile, pool)
+}
+
+// Pool returns the stored pool of transactions.
+func (s *FileStore) Pool() (pool []Transaction, err error) {
+	pool = make([]Transaction, 0)
+	return pool, s.load(poolFile, &pool)
+}
+
+// Close closes the log of blocks.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blocks.Close()
+}
+
+// path returns the path of a file in the store.
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// save writes v to a file as json, replacing the file atomically.
+func (s *FileStore) save(name string, v interface{}) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.OpenFile(s.path(name+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bs); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+// load reads v from a json file. A missing file leaves v unchanged.
+func (s *FileStore) load(name string, v interface{}) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bs, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+// writeBlock writes a record containing the block b to w.
+func writeBlock(w io.Writer, b *Block) (err error) {
+
+	bs, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(bs)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(bs)))
+	buf.Write(bs)
+	buf.Write(sum[:])
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// readBlocks reads records from r until the end of the log, or
+// until a record which was only partly written. It returns the
+// blocks along with the offset of the end of the last complete
+// record. A complete record which does not match its hash is
+// reported with a CorruptStoreError.
+func readBlocks(r io.Reader) (blocks []Block, end int64, err error) {
+
+	br := bufio.NewReader(r)
+	blocks = make([]Block, 0)
+
+	for {
+		var l uint32
+		if err = binary.Read(br, binary.BigEndian, &l); err != nil {
+			// a partial length is from an interrupted write
+			return blocks, end, nil
+		}
+		if l > maxRecordSize {
+			log.Println("Discarding a partly written block at the end of the store")
+			return blocks, end, nil
+		}
+		record := make([]byte, int(l)+sha256.Size)
+		if _, err = io.ReadFull(br, record); err != nil {
+			log.Println("Discarding a partly written block at the end of the store")
+			return blocks, end, nil
+		}
+
+		bs, sum := record[:l], record[l:]
+		if check := sha256.Sum256(bs); !bytes.Equal(check[:], sum) {
+			return nil, 0, CorruptStoreError
+		}
+		var b Block
+		if err = json.Unmarshal(bs, &b); err != nil {
+			return nil, 0, CorruptStoreError
+		}
+		blocks = append(blocks, b)
+		end += int64(4 + len(record))
+	}
+}
+
+// validPrefix returns the number of blocks at the start of the
+// chain blocks which form a valid chain, which is zero if the
+// chain does not begin with the genesis block.
+func (c *Chain) validPrefix(blocks []Block) int {
+
+	if len(blocks) == 0 {
+		return 0
+	}
+	prefix := blocks[:1]
+	if valid, _ := c.validate(&prefix); !valid {
+		return 0
+	}
+	seen := make(map[string]bool, 0)
+	for n := 1; n < len(blocks); n++ {
+		prefix = blocks[:n+1]
+		if valid, _ := c.validateFrom(&prefix, n, seen); !valid {
+			return n
+		}
+	}
+	return len(blocks)
+}
+
+// saveBlocks will store the chain of blocks which has replaced
+// the chain old. If the new chain extends the old one, only the
+// new blocks are appended to the store.
+func (c *Chain) saveBlocks(old, blocks []Block) {
+
+	var err error
+	n := len(old)
+	if n > 0 && len(blocks) >= n && blocks[n-1].Proof == old[n-1].Proof {
+		err = c.store.AppendBlocks(blocks[n:])
+	} else {
+		err = c.store.ReplaceBlocks(blocks)
+	}
+	if err != nil {
+		log.Println("Error saving blocks to the store:", err)
+	}
+}
+
+// savePool will store the pool of transactions still to be mined.
+func (c *Chain) savePool(pool []Transaction) {
+	if err := c.store.SavePool(pool); err != nil {
+		log.Println("Error saving the transaction pool to the store:", err)
+	}
+}
+
+// restore will reload the chain and the pool of transactions
+// from the block store. The stored chain is validated again,
+// which finds the vote tokens seen in it, and the longest valid
+// chain at the start of it is used. The blocks after it are
+// dropped from the store, and if the stored chain does not begin
+// with the genesis block the store is reset to contain only the
+// genesis block.
+func (c *Chain) restore(genesis *Block) {
+
+	stored, err := c.store.Blocks()
+	if err != nil {
+		log.Println("Error reading blocks from the store:", err)
+		stored = nil
+	}
+
+	if n := c.validPrefix(stored); n < len(stored) {
+		log.Println("Dropping", len(stored)-n, "of the", len(stored),
+			"stored blocks from height", n, "as they are not valid")
+		stored = stored[:n]
+		if n > 0 {
+			c.saveBlocks(nil, stored)
+		}
+	}
+	seen := seenIn(stored)
+
+	if len(stored) > 0 {
+		log.Println("Restored", len(stored), "blocks from the store")
+	} else {
+		stored = []Block{*genesis}
+		c.saveBlocks(nil, stored)
+	}
+
+	// transactions in the pool must be checked again, as they
+	// may have been added to the chain before the node stopped
+	storedPool, err := c.store.Pool()
+	if err != nil {
+		log.Println("Error reading the transaction pool from the store:", err)
+		storedPool = nil
+	}
+	pool := make([]Transaction, 0, len(storedPool))
+	for _, tr := range storedPool {
+		if c.ValidateSignature(&tr) {
+			pool = append(pool, tr)
+		}
+	}
+	inChain := make(map[string]bool, len(seen))
+	for vt := range seen {
+		inChain[vt] = true
+	}
+	pool = c.removeSeenTransactions(pool, inChain)
+
+	// the restored chain is not written again, as it is stored
+	c.update(func(tx *stateTx) error {
+		tx.Blocks = stored
+		tx.setSeen(seen)
+		tx.setPool(pool)
+		return nil
+	})
+}
@@ -0,0 +1,281 @@
+package blockchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := NewTestBlocks(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTestTransactionFor(c, otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AppendBlocks(blocks[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AppendBlocks(blocks[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SavePool([]Transaction{*tr}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a record which was only partly written is discarded
+	f, err := os.OpenFile(filepath.Join(dir, blocksFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, '{'})
+	f.Close()
+
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckStoreContents(s, blocks, tr); !success {
+		t.Error("Expected the store to contain what was saved after reopening", "with error", err, "\n")
+	}
+
+	// blocks can still be appended after the partial record
+	if err = s.ReplaceBlocks(blocks[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AppendBlocks(blocks[1:]); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckStoreContents(s, blocks, tr); !success {
+		t.Error("Expected the store to contain the replaced blocks after reopening", "with error", err, "\n")
+	}
+	s.Close()
+
+	// a complete record which does not match its hash is corrupt
+	bs, err := ioutil.ReadFile(filepath.Join(dir, blocksFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs[10] ^= 1
+	if err = ioutil.WriteFile(filepath.Join(dir, blocksFile), bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileStore(dir); err != CorruptStoreError {
+		t.Error("Expected", CorruptStoreError, "for a corrupt record, got", err, "\n")
+	}
+}
+
+// CheckStoreContents checks that the store s contains the chain
+// of blocks, and a pool with only the transaction tr.
+func CheckStoreContents(s BlockStore, blocks []Block, tr *Transaction) (success bool, err error) {
+
+	stored, err := s.Blocks()
+	if err != nil || len(stored) != len(blocks) {
+		return false, err
+	}
+	for i := range blocks {
+		if stored[i].Proof != blocks[i].Proof {
+			return false, nil
+		}
+	}
+
+	pool, err := s.Pool()
+	if err != nil || len(pool) != 1 {
+		return false, err
+	}
+	return pool[0].Hash() == tr.Hash(), nil
+}
+
+func TestRestartRecovery(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestChainFrom(conf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add a block to the chain, and a transaction to the pool
+	blocks, err := NewTestBlocks(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.update(func(tx *stateTx) error {
+		tx.setBlocks(blocks)
+		return nil
+	})
+
+	tr, err := NewTestTransactionFor(c, otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReceiveTransaction(tr, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// the restarted chain has the same blocks, seen tokens and pool
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewTestChainFrom(conf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckChainContents(restarted, blocks, tr); !success {
+		t.Error("Expected the restarted chain to be restored from the store", "with error", err, "\n")
+	}
+
+	// the vote tokens seen in the stored chain are found again, so
+	// a transaction already in the chain is not accepted again
+	if err = restarted.ReceiveTransaction(&blocks[1].Transactions[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckChainContents(restarted, blocks, tr); !success {
+		t.Error("Expected a replayed transaction to be rejected after a restart", "with error", err, "\n")
+	}
+
+	// a transaction in the pool which is already in the chain is dropped
+	if err = s.SavePool([]Transaction{blocks[1].Transactions[0], *tr}); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err = NewTestChainFrom(conf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckChainContents(restarted, blocks, tr); !success {
+		t.Error("Expected transactions in the chain to be removed from the restored pool", "with error", err, "\n")
+	}
+
+	// a stored chain is truncated before its first invalid block
+	bad := NewBlock()
+	bad.setParent(&blocks[1])
+	bad.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+	bad.Header.Nonce++
+	if err = s.ReplaceBlocks(append(append([]Block(nil), blocks...), *bad)); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err = NewTestChainFrom(conf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckChainContents(restarted, blocks, tr); !success {
+		t.Error("Expected the valid blocks of the stored chain to be kept", "with error", err, "\n")
+	}
+	if stored, err := s.Blocks(); err != nil || len(stored) != len(blocks) {
+		t.Error("Expected the invalid block to be dropped from the store", "with error", err, "\n")
+	}
+
+	// an invalid block after the genesis block leaves only the genesis block
+	tampered := append([]Block(nil), blocks...)
+	tampered[1].Header.Nonce++
+	if err = s.ReplaceBlocks(tampered); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err = NewTestChainFrom(conf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success, err := CheckChainContents(restarted, blocks[:1], tr); !success {
+		t.Error("Expected an invalid stored chain to be replaced by the genesis block", "with error", err, "\n")
+	}
+	if stored, err := s.Blocks(); err != nil || len(stored) != 1 {
+		t.Error("Expected the store to be reset to the genesis block", "with error", err, "\n")
+	}
+	s.Close()
+}
+
+// CheckChainContents checks that the chain c has the chain of
+// blocks, has seen the vote tokens in them, and has a pool with
+// only the transaction tr.
+func CheckChainContents(c *Chain, blocks []Block, tr *Transaction) (success bool, err error) {
+
+	chain := c.blocks()
+	if len(chain) != len(blocks) {
+		return false, nil
+	}
+	for i := range blocks {
+		if chain[i].Proof != blocks[i].Proof {
+			return false, nil
+		}
+	}
+
+	state := c.snapshot()
+	seen := state.Seen
+	var n int
+	for _, bl := range blocks {
+		for _, t := range bl.Transactions {
+			if !seen[t.Header.VoteToken] {
+				return false, nil
+			}
+			n++
+		}
+	}
+	if len(seen) != n {
+		return false, nil
+	}
+
+	pool := state.Pool
+	if len(pool) != 1 {
+		return false, nil
+	}
+	return pool[0].Hash() == tr.Hash(), nil
+}
+
+// NewTestBlocks returns the chain of c with a block containing a
+// vote for the test token added to it.
+func NewTestBlocks(c *Chain) (blocks []Block, err error) {
+
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks = c.blocks()
+
+	bl := NewBlock()
+	bl.addTransaction(tr)
+	bl.setParent(&blocks[len(blocks)-1])
+	bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+
+	return append(append([]Block(nil), blocks...), *bl), nil
+}
@@ -0,0 +1,259 @@
+package blockchain
+
+import (
+	"errors"
+	"github.com/CPSSD/voting/src/crypto"
+	"log"
+)
+
+var (
+	NoCommonAncestorError = errors.New("No block in common with the peer's chain.")
+	LessWorkError         = errors.New("Peer's chain does not have more work than ours.")
+	InvalidHeadersError   = errors.New("Headers received from peer do not form a valid chain.")
+	UnexpectedBlockError  = errors.New("Blocks received from peer do not match their headers.")
+	InvalidChainError     = errors.New("Chain received from peer is not valid.")
+)
+
+// locatorDense is the number of the most recent blocks which are
+// all included in a block locator, before the gaps between the
+// blocks it includes start to double.
+const locatorDense = 10
+
+// HeadersRequest asks a peer for the headers of the blocks in
+// its chain which follow the most recent block in Locator that
+// it also has. Locator lists proofs from the requester's chain,
+// starting with its latest block.
+type HeadersRequest struct {
+	Locator [][32]byte
+	Max     int
+}
+
+// SyncHeader contains the header of a block, its proof, and its
+// signature with proof of authority.
+type SyncHeader struct {
+	Header    BlockHeader
+	Proof     [32]byte
+	Signature crypto.Signature
+}
+
+// HeadersReply contains a page of headers from a peer's chain,
+// starting at the index Start, along with the length of its chain.
+type HeadersReply struct {
+	Start       int
+	Headers     []SyncHeader
+	ChainLength int
+}
+
+// BlocksRequest asks a peer for the blocks in its chain with the
+// proofs in Proofs, in order.
+type BlocksRequest struct {
+	Proofs [][32]byte
+}
+
+// blockLocator returns the proofs of some of the blocks in a
+// chain, from which a peer can find the most recent block that
+// its chain has in common with it. The most recent blocks are all
+// included, then the gaps double back to the genesis block, so
+// that the locator stays short for a long chain.
+func blockLocator(blocks []Block) (locator [][32]byte) {
+
+	locator = make([][32]byte, 0)
+	if len(blocks) == 0 {
+		return locator
+	}
+
+	step := 1
+	for i := len(blocks) - 1; i > 0; i -= step {
+		locator = append(locator, blocks[i].Proof)
+		if len(locator) >= locatorDense {
+			step *= 2
+		}
+	}
+	return append(locator, blocks[0].Proof)
+}
+
+// findLocator returns the index of the first block in locator
+// which is in the chain of blocks, or -1 if there is none.
+func findLocator(blocks []Block, locator [][32]byte) int {
+
+	index := make(map[[32]byte]int, len(blocks))
+	for i := range blocks {
+		index[blocks[i].Proof] = i
+	}
+	for _, proof := range locator {
+		if i, ok := index[proof]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetHeaders is an RPC function which allows a peer to find the
+// most recent block its chain has in common with this node's
+// chain, and to get a page of the headers of the blocks which
+// follow it.
+func (c *Chain) GetHeaders(req *HeadersRequest, r *HeadersReply) error {
+
+	blocks := c.blocks()
+
+	ancestor := findLocator(blocks, req.Locator)
+	if ancestor < 0 {
+		return NoCommonAncestorError
+	}
+
+	max := req.Max
+	if max <= 0 || max > syncHeadersPage {
+		max = syncHeadersPage
+	}
+	start := ancestor + 1
+	end := start + max
+	if end > len(blocks) {
+		end = len(blocks)
+	}
+
+	r.Start = start
+	r.ChainLength = len(blocks)
+	r.Headers = make([]SyncHeader, 0, end-start)
+	for _, bl := range blocks[start:end] {
+		r.Headers = append(r.Headers, SyncHeader{Header: bl.Header, Proof: bl.Proof, Signature: bl.Signature})
+	}
+	return nil
+}
+
+// GetBlocks is an RPC function which allows a peer to get a page
+// of the blocks in this node's chain with the requested proofs.
+// The reply stops at the first block which is not in the chain.
+func (c *Chain) GetBlocks(req *BlocksRequest, r *[]Block) error {
+
+	blocks := c.blocks()
+
+	index := make(map[[32]byte]int, len(blocks))
+	for i := range blocks {
+		index[blocks[i].Proof] = i
+	}
+
+	proofs := req.Proofs
+	if len(proofs) > syncBlocksPage {
+		proofs = proofs[:syncBlocksPage]
+	}
+	*r = make([]Block, 0, len(proofs))
+	for _, proof := range proofs {
+		i, ok := index[proof]
+		if !ok {
+			break
+		}
+		*r = append(*r, blocks[i])
+	}
+	return nil
+}
+
+// syncFrom will get the chain of the peer if it has more work
+// than the chain of blocks. The headers following the most recent
+// block the chains have in common are fetched and checked first,
+// and then only the blocks which are missing, in pages. Only the
+// new blocks are validated, as the blocks in common are already
+// part of a valid chain. It returns the new chain and the vote
+// tokens seen in it. At most maxSyncBlocks blocks more than the
+// chain of blocks are fetched, so a longer chain of the peer is
+// synced in several parts.
+func (c *Chain) syncFrom(peer string, blocks []Block) (newBlocks []Block, seen map[string]bool, err error) {
+
+	conn, err := c.transport.Dial(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	// find the blocks in common, and the headers which follow
+	// them, which must form a valid chain before any blocks are
+	// fetched. Each page of headers is checked as it arrives, so a
+	// peer can not send more than one invalid page.
+	limit := len(blocks) + maxSyncBlocks
+	locator := blockLocator(blocks)
+	headers := make([]SyncHeader, 0)
+	var headerChain []Block
+	start := -1
+	for {
+		var reply HeadersReply
+		req := &HeadersRequest{Locator: locator, Max: syncHeadersPage}
+		if err = conn.Call("Chain.GetHeaders", req, &reply); err != nil {
+			return nil, nil, err
+		}
+
+		if start < 0 {
+			start = reply.Start
+			if start < 1 || start > len(blocks) {
+				return nil, nil, InvalidHeadersError
+			}
+			headerChain = make([]Block, start, start+len(reply.Headers))
+			copy(headerChain, blocks[:start])
+		} else if reply.Start != start+len(headers) {
+			return nil, nil, InvalidHeadersError
+		}
+
+		page := reply.Headers
+		if len(page) > limit-len(headerChain) {
+			page = page[:limit-len(headerChain)]
+		}
+		for i := range page {
+			h := &page[i]
+			headerChain = append(headerChain, Block{Header: h.Header, Proof: h.Proof, Signature: h.Signature})
+			if !c.validateHeader(headerChain, len(headerChain)-1) {
+				return nil, nil, InvalidHeadersError
+			}
+		}
+
+		headers = append(headers, page...)
+		if len(page) == 0 || len(headerChain) >= reply.ChainLength || len(headerChain) >= limit {
+			break
+		}
+		locator = [][32]byte{headers[len(headers)-1].Proof}
+	}
+	log.Println("Common ancestor with", peer, "is block", start-1, "followed by", len(headers), "headers")
+
+	work := c.chainWork(blocks[:start])
+	for i := range headers {
+		work.Add(work, c.consensus.Work(&headers[i].Header, start+i))
+	}
+	if work.Cmp(c.chainWork(blocks)) <= 0 {
+		return nil, nil, LessWorkError
+	}
+
+	newBlocks = make([]Block, start, start+len(headers))
+	copy(newBlocks, blocks[:start])
+	seen = seenIn(newBlocks)
+
+	for i := 0; i < len(headers); i += syncBlocksPage {
+
+		page := headers[i:]
+		if len(page) > syncBlocksPage {
+			page = page[:syncBlocksPage]
+		}
+		req := &BlocksRequest{Proofs: make([][32]byte, len(page))}
+		for j := range page {
+			req.Proofs[j] = page[j].Proof
+		}
+
+		var reply []Block
+		if err = conn.Call("Chain.GetBlocks", req, &reply); err != nil {
+			return nil, nil, err
+		}
+		if len(reply) != len(page) {
+			return nil, nil, UnexpectedBlockError
+		}
+		for j := range reply {
+			if reply[j].Proof != page[j].Proof || reply[j].Header != page[j].Header {
+				return nil, nil, UnexpectedBlockError
+			}
+		}
+
+		// validate each page as it arrives
+		from := len(newBlocks)
+		newBlocks = append(newBlocks, reply...)
+		valid, _ := c.validateFrom(&newBlocks, from, seen)
+		if !valid {
+			return nil, nil, InvalidChainError
+		}
+	}
+	return newBlocks, seen, nil
+}
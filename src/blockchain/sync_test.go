@@ -0,0 +1,261 @@
+package blockchain
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBlockLocator(t *testing.T) {
+
+	blocks := make([]Block, 100)
+	for i := range blocks {
+		blocks[i].Proof[0] = byte(i)
+	}
+
+	locator := blockLocator(blocks)
+	if len(locator) > locatorDense+8 {
+		t.Error("Expected a short locator for a long chain, got", len(locator), "proofs\n")
+	}
+	if locator[0] != blocks[99].Proof || locator[len(locator)-1] != blocks[0].Proof {
+		t.Error("Expected the locator to start at the latest block and end at the genesis block\n")
+	}
+
+	// the common ancestor is the latest block of the shorter chain
+	// which is in the locator
+	inLocator := make(map[[32]byte]bool, len(locator))
+	for _, proof := range locator {
+		inLocator[proof] = true
+	}
+
+	var tests = []struct {
+		name   string
+		length int
+	}{
+		{"same chain", 100},
+		{"shorter chain", 50},
+		{"much shorter chain", 5},
+		{"genesis only", 1},
+	}
+	for _, test := range tests {
+		ancestor := test.length - 1
+		for !inLocator[blocks[ancestor].Proof] {
+			ancestor--
+		}
+		if i := findLocator(blocks[:test.length], locator); i != ancestor {
+			t.Error("For input", test.name, "expected the common ancestor", ancestor, "got", i, "\n")
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+
+	// small pages keep the test fast, while making the sync take
+	// several pages
+	headersPage, blocksPage := syncHeadersPage, syncBlocksPage
+	syncHeadersPage, syncBlocksPage = 3, 2
+	defer func() {
+		syncHeadersPage, syncBlocksPage = headersPage, blocksPage
+	}()
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		conf.VoteTokens["token"+strconv.Itoa(i)] = conf.PrivateKey.PublicKey
+	}
+
+	peer, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chains share two blocks, and then fork
+	for i := 0; i < 2; i++ {
+		if err = AddTestBlock(peer, "token"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := peer.blocks()
+	c.update(func(tx *stateTx) error {
+		tx.setBlocks(blocks)
+		return nil
+	})
+	for i := 2; i < 7; i++ {
+		if err = AddTestBlock(peer, "token"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = AddTestBlock(c, "token7"); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := ServeTestChain(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ours := c.blocks()
+	theirs := peer.blocks()
+
+	// only the headers after the fork are sent
+	var reply HeadersReply
+	if err = peer.GetHeaders(&HeadersRequest{Locator: blockLocator(ours)}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Start != 3 || len(reply.Headers) != syncHeadersPage || reply.ChainLength != len(theirs) {
+		t.Error("Expected headers from the block after the fork, got", len(reply.Headers), "from", reply.Start, "\n")
+	}
+
+	newBlocks, seen, err := c.syncFrom(addr, ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newBlocks) != len(theirs) || newBlocks[len(newBlocks)-1].Proof != theirs[len(theirs)-1].Proof {
+		t.Error("Expected to sync the peer's chain of", len(theirs), "blocks, got", len(newBlocks), "\n")
+	}
+	if len(seen) != 7 || seen["token7"] {
+		t.Error("Expected the vote tokens of the peer's chain to be seen, got", seen, "\n")
+	}
+
+	// the peer's chain does not have more work than its own
+	if _, _, err = peer.syncFrom(addr, theirs); err != LessWorkError {
+		t.Error("Expected", LessWorkError, "when syncing with the same chain, got", err, "\n")
+	}
+
+	// a chain of another election has no blocks in common
+	conf.ElectionID = "other"
+	other, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBlocks := other.blocks()
+	if _, _, err = other.syncFrom(addr, otherBlocks); err == nil || err.Error() != NoCommonAncestorError.Error() {
+		t.Error("Expected", NoCommonAncestorError, "when syncing with another election, got", err, "\n")
+	}
+
+	// a chain much longer than its own is synced in several parts
+	maxBlocks := maxSyncBlocks
+	maxSyncBlocks = 2
+	newBlocks, _, err = c.syncFrom(addr, ours)
+	if err != nil || len(newBlocks) != len(ours)+maxSyncBlocks {
+		t.Error("Expected to sync", len(ours)+maxSyncBlocks, "blocks of the peer's chain, got", len(newBlocks), err, "\n")
+	}
+	if newBlocks, _, err = c.syncFrom(addr, newBlocks); err != nil || len(newBlocks) != len(theirs) {
+		t.Error("Expected to sync the rest of the peer's chain, got", len(newBlocks), err, "\n")
+	}
+	maxSyncBlocks = maxBlocks
+
+	// a peer which claims a longer chain than it sends, and repeats
+	// its headers, is not asked for more than one invalid page
+	repeating := &RepeatingTestPeer{c: peer}
+	repeatingAddr, err := ServeTestPeer(repeating)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := c.blocks()[:1]
+	if _, _, err = c.syncFrom(repeatingAddr, genesis); err != InvalidHeadersError {
+		t.Error("Expected", InvalidHeadersError, "for a peer repeating its headers, got", err, "\n")
+	}
+	if calls := atomic.LoadInt32(&repeating.calls); calls != 2 {
+		t.Error("Expected the peer to be asked for 2 pages of headers, got", calls, "\n")
+	}
+
+	// a block which does not match its header is rejected
+	theirs[5].Transactions[0].Header.Timestamp++
+	if _, _, err = c.syncFrom(addr, ours); err != InvalidChainError {
+		t.Error("Expected", InvalidChainError, "for a tampered block, got", err, "\n")
+	}
+}
+
+// RepeatingTestPeer serves the first page of headers of the chain
+// of c in reply to every request for headers, and claims to have a
+// much longer chain.
+type RepeatingTestPeer struct {
+	c     *Chain
+	calls int32
+}
+
+// GetHeaders replies with the first page of headers after the
+// genesis block, starting after the block requested.
+func (p *RepeatingTestPeer) GetHeaders(req *HeadersRequest, r *HeadersReply) error {
+	atomic.AddInt32(&p.calls, 1)
+	blocks := p.c.blocks()
+	if err := p.c.GetHeaders(&HeadersRequest{Locator: blockLocator(blocks[:1]), Max: req.Max}, r); err != nil {
+		return err
+	}
+	r.Start = findLocator(blocks, req.Locator) + 1
+	r.ChainLength = math.MaxInt32
+	return nil
+}
+
+// ServeTestPeer makes the RPC functions of peer available in place
+// of those of a chain on a local port, and returns its address.
+func ServeTestPeer(peer interface{}) (addr string, err error) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	server := rpc.NewServer()
+	if err = server.RegisterName("Chain", peer); err != nil {
+		ln.Close()
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
+	go http.Serve(ln, mux)
+	return ln.Addr().String(), nil
+}
+
+// AddTestBlock adds a block to the chain of c, containing a vote
+// for the vote token vt.
+func AddTestBlock(c *Chain, vt string) (err error) {
+
+	blocks := c.blocks()
+
+	bl, err := NewTestBlock(c, &blocks[len(blocks)-1], vt)
+	if err != nil {
+		return err
+	}
+
+	return c.update(func(tx *stateTx) error {
+		n := len(tx.Blocks)
+		tx.setBlocks(append(tx.Blocks[:n:n], *bl))
+		return nil
+	})
+}
+
+// NewTestBlock returns a block on top of the block parent,
+// containing a vote for the vote token vt.
+func NewTestBlock(c *Chain, parent *Block, vt string) (bl *Block, err error) {
+
+	tr, err := NewTestTransactionFor(c, vt)
+	if err != nil {
+		return nil, err
+	}
+
+	bl = NewBlock()
+	bl.addTransaction(tr)
+	bl.setParent(parent)
+	bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+	return bl, nil
+}
+
+// ServeTestChain makes the RPC functions of c available on a local
+// port, and returns its address.
+func ServeTestChain(c *Chain) (addr string, err error) {
+	if err = c.Listen("127.0.0.1:0"); err != nil {
+		return "", err
+	}
+	return c.Addr(), nil
+}
@@ -0,0 +1,281 @@
+package blockchain
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+var (
+	NoRegisteredNodesError  = errors.New("The election has no registered nodes to authenticate peers against.")
+	UnregisteredPeerError   = errors.New("The peer did not present the certificate of a node registered for the election.")
+	InvalidCertificateError = errors.New("Invalid certificate; it could not be decoded from PEM.")
+	RPCConnectError         = errors.New("The peer did not accept the RPC connection.")
+)
+
+// certValidity is how long the certificates of nodes are valid for.
+// Peers are authenticated by the fingerprints of their certificates,
+// so the period only needs to cover the election.
+const certValidity = 10 * 365 * 24 * time.Hour
+
+// rpcConnected is the status with which an RPC server accepts a
+// connection made over HTTP.
+const rpcConnected = "200 Connected to Go RPC"
+
+// TLSTransport is a Transport which carries RPC calls over HTTP on
+// mutually authenticated TLS connections. Each node has its own
+// self-signed certificate, and peers are authenticated by checking
+// the fingerprints of their certificates against the nodes
+// registered in the election manifest.
+type TLSTransport struct {
+	cert              tls.Certificate
+	nodes             map[string]bool
+	requireRegistered bool
+}
+
+// NewTLSTransport returns a TLSTransport which presents the PEM
+// encoded certificate and key to its peers, and only accepts the
+// peers whose certificates have one of the fingerprints in nodes.
+// If requireRegistered is false, peers which present no certificate
+// may still connect to the node, such as clients which only submit
+// transactions, but may only call the RPC functions of clientRPC.
+// The node never connects to an unregistered peer.
+func NewTLSTransport(certPEM, keyPEM string, nodes []string, requireRegistered bool) (t *TLSTransport, err error) {
+
+	if len(nodes) == 0 {
+		return nil, NoRegisteredNodesError
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	t = &TLSTransport{
+		cert:              cert,
+		nodes:             make(map[string]bool, len(nodes)),
+		requireRegistered: requireRegistered,
+	}
+	for _, n := range nodes {
+		t.nodes[strings.ToLower(n)] = true
+	}
+	return t, nil
+}
+
+// Dial opens a TLS connection to the chain served at addr, if the
+// peer presents the certificate of a registered node.
+func (t *TLSTransport) Dial(addr string) (Conn, error) {
+
+	conn, err := tls.Dial("tcp", addr, t.clientConfig())
+	if err != nil {
+		return nil, err
+	}
+	return connectRPC(conn)
+}
+
+// connectRPC will connect to the RPC server of a chain over HTTP on
+// the connection conn, as rpc.DialHTTP does on TCP connections. The
+// connection is closed if the server does not accept it.
+func connectRPC(conn net.Conn) (Conn, error) {
+
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != rpcConnected {
+		err = RPCConnectError
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// Broadcast calls the RPC function method with args on each of the
+// peers, without waiting for them to reply.
+func (t *TLSTransport) Broadcast(peers []string, method string, args interface{}) {
+	broadcast(t, peers, method, args)
+}
+
+// Serve makes the RPC functions of the chain c available over HTTP
+// on TLS connections at the TCP address addr. Peers which presented
+// the certificate of a registered node may call all of them, and
+// other clients only those of clientRPC.
+func (t *TLSTransport) Serve(addr string, c *Chain) (Listener, error) {
+
+	ln, err := tls.Listen("tcp", addr, t.serverConfig())
+	if err != nil {
+		return nil, err
+	}
+	peers := rpc.NewServer()
+	clients := rpc.NewServer()
+	if err = peers.RegisterName("Chain", c); err == nil {
+		err = clients.RegisterName("Chain", clientRPC{c})
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	// a certificate is only accepted from a registered node
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			peers.ServeHTTP(w, r)
+		} else {
+			clients.ServeHTTP(w, r)
+		}
+	})
+	return serveHTTP(ln, handler), nil
+}
+
+// clientRPC has the RPC functions of a chain which clients that are
+// not registered nodes may call over TLS. They may submit
+// transactions and read the chain, but not change the peers, blocks,
+// shares or partial tallies of the node.
+type clientRPC struct {
+	c *Chain
+}
+
+// ReceiveTransaction calls Chain.ReceiveTransaction.
+func (r clientRPC) ReceiveTransaction(t *Transaction, reply *struct{}) error {
+	return r.c.ReceiveTransaction(t, reply)
+}
+
+// GetParams calls Chain.GetParams.
+func (r clientRPC) GetParams(args *struct{}, reply *ParamsReply) error {
+	return r.c.GetParams(args, reply)
+}
+
+// GetHeaders calls Chain.GetHeaders.
+func (r clientRPC) GetHeaders(req *HeadersRequest, reply *HeadersReply) error {
+	return r.c.GetHeaders(req, reply)
+}
+
+// GetBlocks calls Chain.GetBlocks.
+func (r clientRPC) GetBlocks(req *BlocksRequest, reply *[]Block) error {
+	return r.c.GetBlocks(req, reply)
+}
+
+// GetPublicElectionKey calls Chain.GetPublicElectionKey.
+func (r clientRPC) GetPublicElectionKey(empty bool, key *PublicElectionKey) error {
+	return r.c.GetPublicElectionKey(empty, key)
+}
+
+// clientConfig returns the TLS configuration used to connect to
+// peers. The usual verification of the peer's certificate chain is
+// replaced by checking it is the certificate of a registered node.
+func (t *TLSTransport) clientConfig() *tls.Config {
+	return &tls.Config{
+		Certificates:          []tls.Certificate{t.cert},
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: t.verify(false),
+	}
+}
+
+// serverConfig returns the TLS configuration used to accept
+// connections from peers.
+func (t *TLSTransport) serverConfig() *tls.Config {
+	auth := tls.RequestClientCert
+	if t.requireRegistered {
+		auth = tls.RequireAnyClientCert
+	}
+	return &tls.Config{
+		Certificates:          []tls.Certificate{t.cert},
+		MinVersion:            tls.VersionTLS12,
+		ClientAuth:            auth,
+		VerifyPeerCertificate: t.verify(!t.requireRegistered),
+	}
+}
+
+// verify returns a function which checks that the certificate
+// presented by a peer is the certificate of a registered node. A
+// peer which presents no certificate is only accepted if optional
+// is true.
+func (t *TLSTransport) verify(optional bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(certs [][]byte, _ [][]*x509.Certificate) error {
+		if len(certs) == 0 {
+			if optional {
+				return nil
+			}
+			return UnregisteredPeerError
+		}
+		if !t.nodes[fingerprint(certs[0])] {
+			return UnregisteredPeerError
+		}
+		return nil
+	}
+}
+
+// NewNodeCertificate returns a new self-signed certificate for a
+// node at host, and its private key, both PEM encoded. The
+// certificate can be used both to accept connections and to
+// connect to peers.
+func NewNodeCertificate(host string) (certPEM, keyPEM string, err error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, nil
+}
+
+// CertificateFingerprint returns the fingerprint of a PEM encoded
+// certificate, which registers the node in the election manifest.
+func CertificateFingerprint(certPEM string) (fp string, err error) {
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", InvalidCertificateError
+	}
+	return fingerprint(block.Bytes), nil
+}
+
+// fingerprint returns the SHA-256 hash of the DER encoding of a
+// certificate, in hex.
+func fingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSTransport(t *testing.T) {
+
+	// the first two nodes are registered for the election
+	certs := make([][2]string, 3)
+	nodes := make([]string, 0)
+	for i := range certs {
+		cert, key, err := NewNodeCertificate("127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs[i] = [2]string{cert, key}
+		if i < 2 {
+			fp, err := CertificateFingerprint(cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes = append(nodes, fp)
+		}
+	}
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		client   int // -1 for a client without a certificate
+		server   int
+		require  bool
+		expected bool
+	}{
+		{"registered peers", 0, 1, true, true},
+		{"unregistered client", 2, 1, true, false},
+		{"unregistered client, registration not required", 2, 1, false, false},
+		{"client without certificate", -1, 1, true, false},
+		{"client without certificate, registration not required", -1, 1, false, true},
+		{"unregistered server", 0, 2, false, false},
+	}
+
+	for _, test := range tests {
+		server, err := NewTLSTransport(certs[test.server][0], certs[test.server][1], nodes, test.require)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := server.Serve("127.0.0.1:0", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var conn Conn
+		if test.client < 0 {
+			conn, err = DialTestTLS(ln.Addr())
+		} else {
+			var client *TLSTransport
+			if client, err = NewTLSTransport(certs[test.client][0], certs[test.client][1], nodes, false); err != nil {
+				t.Fatal(err)
+			}
+			conn, err = client.Dial(ln.Addr())
+		}
+		if err == nil {
+			var reply ParamsReply
+			err = conn.Call("Chain.GetParams", &struct{}{}, &reply)
+			conn.Close()
+		}
+		if (err == nil) != test.expected {
+			t.Error("For input", test.name, "expected the call to succeed:", test.expected, "got", err, "\n")
+		}
+		ln.Close()
+	}
+
+	// a client without a certificate may not change the peers or
+	// the blocks of the node, while a registered peer may
+	server, err := NewTLSTransport(certs[1][0], certs[1][1], nodes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := server.Serve("127.0.0.1:0", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	peer, err := NewTLSTransport(certs[0][0], certs[0][1], nodes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls = []struct {
+		name     string
+		dial     func(addr string) (Conn, error)
+		addr     string
+		expected bool
+	}{
+		{"client without certificate", DialTestTLS, "client:1", false},
+		{"registered peer", peer.Dial, "peer:1", true},
+	}
+
+	for _, test := range calls {
+		conn, err := test.dial(ln.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var peers map[string]bool
+		err = conn.Call("Chain.GetPeers", &map[string]bool{test.addr: true}, &peers)
+		if (err == nil) != test.expected || c.peers()[test.addr] != test.expected {
+			t.Error("For input", test.name, "expected to add a peer:", test.expected, "got", err, "\n")
+		}
+		conn.Close()
+	}
+	conn, err := DialTestTLS(ln.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Call("Chain.ReceiveBlockUpdate", &BlockUpdate{}, nil); err == nil {
+		t.Error("Expected a client without certificate not to send block updates\n")
+	}
+	conn.Close()
+
+	if _, err = NewTLSTransport(certs[0][0], certs[0][1], nil, false); err != NoRegisteredNodesError {
+		t.Error("Expected", NoRegisteredNodesError, "without registered nodes, got", err, "\n")
+	}
+
+	// a chain configured with a certificate only serves over TLS
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configured, err := NewTestChainConfigured(filepath.Join(dir, "conf.json"), func(conf *Configuration) {
+		conf.Nodes = nodes
+		conf.TLSCertificate, conf.TLSKey = certs[0][0], certs[0][1]
+		conf.RequireRegisteredPeers = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configured.listener.Close()
+	var reply ParamsReply
+	if err = CallTestChain(configured.Addr(), "Chain.GetParams", &struct{}{}, &reply); err == nil {
+		t.Error("Expected a plain connection to be refused\n")
+	}
+	if err = CallTestPeer(configured, configured.Addr(), "Chain.GetParams", &reply); err != nil {
+		t.Error("Expected a registered node to connect, got", err, "\n")
+	}
+}
+
+// DialTestTLS connects to the RPC server of the chain at addr over
+// TLS, without presenting a certificate.
+func DialTestTLS(addr string) (conn Conn, err error) {
+
+	tc, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	return connectRPC(tc)
+}
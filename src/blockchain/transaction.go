@@ -1,15 +1,17 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/binary"
+	"errors"
 	"github.com/CPSSD/voting/src/crypto"
 	"github.com/CPSSD/voting/src/election"
 	"log"
 	"time"
 )
 
+var (
+	VotingClosedError = errors.New("Voting is not open for this election.")
+)
+
 // Transaction contains the user's Ballot along
 // with a TransactionHeader containing information
 // about the transaction.
@@ -20,12 +22,15 @@ type Transaction struct {
 
 // TransactionHeader contains information required to
 // verify a transaction, such as the VoteToken, the
-// BallotHash and Signature.
+// BallotHash and Signature. The Signature covers every
+// other field of the header.
 type TransactionHeader struct {
-	VoteToken  string           // so that we know what token is authorizing the vote
-	BallotHash [32]byte         // hash of the ballot to tie it to the header
-	Signature  crypto.Signature // signature of the ballot hash
-	Timestamp  uint32           // timestamp so we know when to count this vote for
+	VoteToken   string           // so that we know what token is authorizing the vote
+	BallotHash  [32]byte         // hash of the ballot to tie it to the header
+	ElectionID  string           // identifier of the election the vote is for
+	GenesisHash [32]byte         // hash identifying the chain of the election
+	Signature   crypto.Signature // signature of the rest of the header
+	Timestamp   uint32           // timestamp so we know when to count this vote for
 }
 
 // String representation of a Transaction
@@ -38,49 +43,97 @@ func (t Transaction) String() (str string) {
 }
 
 // NewTransaction will take a filled ballot and encrypt
-// its contents with the election key.
-func (c *Chain) NewTransaction(token string, ballot *election.Ballot) (t *Transaction) {
+// its contents with the election key, along with proofs
+// that each of the encrypted votes is either 0 or 1 and
+// that the ballot follows the election format.
+func (c *Chain) NewTransaction(token string, ballot *election.Ballot) (t *Transaction, err error) {
+
+	now := uint32(time.Now().Unix())
+	if !c.inVotingWindow(now) {
+		return nil, VotingClosedError
+	}
 
-	tmp := ballot
-	for i, s := range ballot.Selections {
-		enc, err := c.conf.ElectionKey.Encrypt(s.Vote)
-		if err != nil {
-			log.Println("Error while encrypting vote with the public election key")
-			log.Fatalln(err)
-		}
-		tmp.Selections[i].Vote = enc
+	err = ballot.Encrypt(c.conf.ElectionFormat, &c.conf.ElectionKey.PublicKey)
+	if err != nil {
+		log.Println("Error while encrypting vote with the public election key")
+		return nil, err
 	}
-	ballot = tmp
 
 	t = &Transaction{
 		Header: TransactionHeader{
-			VoteToken: token,
+			VoteToken:   token,
+			ElectionID:  c.conf.ElectionID,
+			GenesisHash: c.GenesisHash(),
+			Timestamp:   now,
 		},
 		Ballot: *ballot,
 	}
 
-	var ballot_buf bytes.Buffer
-	binary.Write(&ballot_buf, binary.BigEndian, t.Ballot)
-
-	t.Header.BallotHash = sha256.Sum256(ballot_buf.Bytes())
-	t.Header.Signature = *crypto.SignHash(&c.conf.PrivateKey, &t.Header.BallotHash)
-	t.Header.Timestamp = uint32(time.Now().Unix())
+	t.Header.BallotHash = t.Ballot.Hash()
+	hash := t.Header.SigningHash()
+	t.Header.Signature = *crypto.SignHash(&c.conf.PrivateKey, &hash)
 
-	return t
+	return t, nil
 }
 
 // ValidateSignature will allow a signature of a transaction
-// to be validated. The result is returned in the boolean value
-// valid.
+// to be validated, along with the hash of its ballot and the
+// proofs attached to the ballot. Transactions created for a
+// different election are rejected. The result is returned in
+// the boolean value valid.
 func (c *Chain) ValidateSignature(t *Transaction) (valid bool) {
+	genesis := c.GenesisHash()
+	if genesis == *new([32]byte) {
+		log.Println("Transaction received before the genesis block:", t.Header.VoteToken)
+		return false
+	}
 	pubkey, ok := c.conf.VoteTokens[t.Header.VoteToken]
 	if !ok {
 		log.Println("Transaction contains fake vote token:", t.Header.VoteToken)
 		return false
 	}
-	valid = crypto.Verify(&pubkey, &t.Header.BallotHash, &t.Header.Signature)
+	if t.Header.ElectionID != c.conf.ElectionID || t.Header.GenesisHash != genesis {
+		log.Println("Transaction is for a different election:", t.Header.VoteToken)
+		return false
+	}
+	if !c.inVotingWindow(t.Header.Timestamp) {
+		log.Println("Transaction is outside the voting window:", t.Header.VoteToken)
+		return false
+	}
+	if t.Ballot.Hash() != t.Header.BallotHash {
+		log.Println("Transaction ballot does not match its hash:", t.Header.VoteToken)
+		return false
+	}
+	hash := t.Header.SigningHash()
+	valid = crypto.Verify(&pubkey, &hash, &t.Header.Signature)
 	if !valid {
 		log.Println("Transaction signature invalid")
+		return false
+	}
+	return c.validateProofs(t)
+}
+
+// validateProofs will check that the ballot in a transaction
+// belongs to the token authorizing it, that each of its
+// encrypted votes is proven to be either 0 or 1, and that the
+// number of votes is proven to be allowed by the election format.
+func (c *Chain) validateProofs(t *Transaction) (valid bool) {
+	if t.Ballot.VoteToken != t.Header.VoteToken {
+		log.Println("Transaction ballot does not match its vote token:", t.Header.VoteToken)
+		return false
+	}
+	valid = t.Ballot.VerifyProofs(c.conf.ElectionFormat, &c.conf.ElectionKey.PublicKey)
+	if !valid {
+		log.Println("Transaction contains invalid ballot proofs:", t.Header.VoteToken)
 	}
 	return valid
 }
+
+// inVotingWindow returns whether a vote with the given timestamp
+// is allowed by the voting window of the election.
+func (c *Chain) inVotingWindow(timestamp uint32) bool {
+	if c.conf.VotingEnd == 0 {
+		return true
+	}
+	return timestamp >= c.conf.VotingStart && timestamp <= c.conf.VotingEnd
+}
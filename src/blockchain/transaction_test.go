@@ -0,0 +1,400 @@
+package blockchain
+
+import (
+	"crypto/dsa"
+	"crypto/rand"
+	"github.com/CPSSD/voting/src/crypto"
+	"github.com/CPSSD/voting/src/election"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testToken  = "token"
+	otherToken = "other token"
+
+	// a low difficulty keeps the tests fast
+	testDifficulty = 1
+)
+
+func TestTamperedTransactions(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		tamper func(tr *Transaction) error
+	}{
+		{"changed vote", func(tr *Transaction) (err error) {
+			tr.Ballot.Selections[0].Vote, err = c.conf.ElectionKey.PublicKey.Encrypt(big.NewInt(0))
+			return err
+		}},
+		{"swapped selection names", func(tr *Transaction) error {
+			s := tr.Ballot.Selections
+			s[0].Name, s[1].Name = s[1].Name, s[0].Name
+			return nil
+		}},
+		{"changed selection proof", func(tr *Transaction) error {
+			tr.Ballot.Selections[1].Proof[len(tr.Ballot.Selections[1].Proof)-1] ^= 1
+			return nil
+		}},
+		{"removed ballot proof", func(tr *Transaction) error {
+			tr.Ballot.Proof = nil
+			return nil
+		}},
+		{"changed ballot token", func(tr *Transaction) error {
+			tr.Ballot.VoteToken = "other"
+			return nil
+		}},
+		{"changed vote with new ballot hash", func(tr *Transaction) (err error) {
+			tr.Ballot.Selections[0].Vote, err = c.conf.ElectionKey.PublicKey.Encrypt(big.NewInt(0))
+			tr.Header.BallotHash = tr.Ballot.Hash()
+			return err
+		}},
+		{"changed signature", func(tr *Transaction) error {
+			tr.Header.Signature.S = new(big.Int).Add(tr.Header.Signature.S, big.NewInt(1))
+			return nil
+		}},
+		{"missing signature", func(tr *Transaction) error {
+			tr.Header.Signature = crypto.Signature{}
+			return nil
+		}},
+		{"changed timestamp", func(tr *Transaction) error {
+			tr.Header.Timestamp++
+			return nil
+		}},
+		{"changed election", func(tr *Transaction) error {
+			tr.Header.ElectionID = "other"
+			return nil
+		}},
+		{"changed genesis hash", func(tr *Transaction) error {
+			tr.Header.GenesisHash[0] ^= 1
+			return nil
+		}},
+	}
+
+	for _, test := range tests {
+		success, err := CheckTamperedTransaction(c, test.tamper)
+		if !success {
+			t.Error("For input", test.name, "expected the transaction to be rejected", "with error", err, "\n")
+		}
+	}
+}
+
+func TestOtherElectionTransactions(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		change func(conf *Configuration)
+	}{
+		{"different election identifier", func(conf *Configuration) {
+			conf.ElectionID = "other"
+		}},
+		{"different ballot format", func(conf *Configuration) {
+			conf.ElectionFormat.MaxSelections = 1
+		}},
+		{"different vote tokens", func(conf *Configuration) {
+			tokens := map[string]dsa.PublicKey{"third": conf.PrivateKey.PublicKey}
+			for vt, pub := range conf.VoteTokens {
+				tokens[vt] = pub
+			}
+			conf.VoteTokens = tokens
+		}},
+	}
+
+	for _, test := range tests {
+		success, err := CheckOtherElectionTransaction(c, test.change)
+		if !success {
+			t.Error("For input", test.name, "expected the transaction to be rejected", "with error", err, "\n")
+		}
+	}
+}
+
+func TestOverSelectedBallots(t *testing.T) {
+
+	// voters may vote for at most one of the two selections
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ElectionFormat.MaxSelections = 1
+	c, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		forge  func(b *election.Ballot)
+		accept bool
+	}{
+		{"valid ballot", nil, true},
+		{"range proof for another range", func(b *election.Ballot) {}, false},
+		{"reused range proof", func(b *election.Ballot) {
+			b.Proof = append([]byte(nil), valid.Ballot.Proof...)
+		}, false},
+	}
+
+	for _, test := range tests {
+		tr := valid
+		if test.forge != nil {
+			// the ballot votes for both selections, which is only
+			// allowed by a wider format, and has valid proofs that
+			// each of its votes is 0 or 1
+			wider := conf.ElectionFormat
+			wider.MaxSelections = 2
+			ballot := &election.Ballot{
+				VoteToken:     testToken,
+				NumSelections: 2,
+				Selections: []election.Selection{
+					{Name: "yes", Vote: big.NewInt(1)},
+					{Name: "no", Vote: big.NewInt(1)},
+				},
+			}
+			if err = ballot.Encrypt(wider, &conf.ElectionKey.PublicKey); err != nil {
+				t.Fatal(err)
+			}
+			test.forge(ballot)
+			tr = SignTestTransaction(c, ballot)
+		}
+
+		blocks := c.blocks()
+		bl := NewBlock()
+		bl.addTransaction(tr)
+		bl.setParent(&blocks[len(blocks)-1])
+		bl.createProof(initialTarget(testDifficulty), 0, make(chan bool))
+		chain := append(blocks, *bl)
+		if accepted, _ := c.validate(&chain); accepted != test.accept {
+			t.Error("For input", test.name, "expected the chain to be accepted:", test.accept, "\n")
+		}
+	}
+}
+
+// SignTestTransaction returns a transaction for the encrypted
+// ballot, signed with the key of the vote token of the ballot.
+func SignTestTransaction(c *Chain, ballot *election.Ballot) (tr *Transaction) {
+
+	tr = &Transaction{
+		Header: TransactionHeader{
+			VoteToken:   ballot.VoteToken,
+			ElectionID:  c.conf.ElectionID,
+			GenesisHash: c.GenesisHash(),
+			Timestamp:   uint32(time.Now().Unix()),
+		},
+		Ballot: *ballot,
+	}
+	tr.Header.BallotHash = tr.Ballot.Hash()
+	hash := tr.Header.SigningHash()
+	tr.Header.Signature = *crypto.SignHash(&c.conf.PrivateKey, &hash)
+	return tr
+}
+
+// CheckOtherElectionTransaction creates a valid transaction, and
+// checks that it is rejected by the chain of another election
+// with the same keys, whose configuration is changed by change.
+func CheckOtherElectionTransaction(c *Chain, change func(conf *Configuration)) (success bool, err error) {
+
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		return false, err
+	}
+	if !c.ValidateSignature(tr) {
+		return false, nil
+	}
+
+	conf := c.conf
+	change(&conf)
+	other, err := NewTestChainFrom(conf, NewMemoryStore())
+	if err != nil {
+		return false, err
+	}
+	return !other.ValidateSignature(tr), nil
+}
+
+func TestTransactionEncoding(t *testing.T) {
+
+	c, err := NewTestChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.ValidateSignature(tr) {
+		t.Error("Expected an untampered transaction to be valid\n")
+	}
+
+	// the same contents must always have the same encoding
+	copied := CopyTransaction(tr)
+	if string(copied.Ballot.Bytes()) != string(tr.Ballot.Bytes()) || copied.Hash() != tr.Hash() {
+		t.Error("Expected copies of a transaction to have the same encoding\n")
+	}
+
+	// a nil vote must not be encoded in the same way as zero
+	a := election.Ballot{Selections: []election.Selection{{Name: "a", Vote: nil}}}
+	b := election.Ballot{Selections: []election.Selection{{Name: "a", Vote: big.NewInt(0)}}}
+	if a.Hash() == b.Hash() {
+		t.Error("Expected ballots with a nil and a zero vote to have different hashes\n")
+	}
+
+	// fields must not be able to run into each other
+	a = election.Ballot{VoteToken: "ab", Selections: []election.Selection{{Name: "c"}}}
+	b = election.Ballot{VoteToken: "a", Selections: []election.Selection{{Name: "bc"}}}
+	if a.Hash() == b.Hash() {
+		t.Error("Expected ballots with different fields to have different hashes\n")
+	}
+
+	// the merkle hash of a block must cover each ballot
+	block := NewBlock()
+	block.addTransaction(tr)
+	before := merkleHash(block.Transactions)
+	block.Transactions[0].Ballot.Selections[0].Name = "changed"
+	block.Transactions[0].Header.BallotHash = block.Transactions[0].Ballot.Hash()
+	if merkleHash(block.Transactions) == before {
+		t.Error("Expected the merkle hash to change when a ballot is changed\n")
+	}
+}
+
+// CheckTamperedTransaction creates a valid transaction, changes
+// it with tamper, and checks that it is no longer valid.
+func CheckTamperedTransaction(c *Chain, tamper func(tr *Transaction) error) (success bool, err error) {
+
+	tr, err := NewTestTransaction(c)
+	if err != nil {
+		return false, err
+	}
+	if !c.ValidateSignature(tr) {
+		return false, nil
+	}
+
+	if err = tamper(tr); err != nil {
+		return false, err
+	}
+	return !c.ValidateSignature(tr), nil
+}
+
+// NewTestChain returns a Chain with a configuration and a genesis
+// block for an election with two selections and two voters.
+func NewTestChain() (c *Chain, err error) {
+
+	conf, err := NewTestConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return NewTestChainFrom(conf, NewMemoryStore())
+}
+
+// NewTestChainFrom returns a Chain with the configuration conf,
+// which keeps its blocks in store.
+func NewTestChainFrom(conf Configuration, store BlockStore) (c *Chain, err error) {
+	return NewTestChainWith(conf, store, nil)
+}
+
+// NewTestChainWith returns a chain like NewTestChainFrom, which uses
+// the Consensus cons, or the consensus of the election if nil.
+func NewTestChainWith(conf Configuration, store BlockStore, cons Consensus) (c *Chain, err error) {
+
+	c, err = NewChainWith(cons)
+	if err != nil {
+		return nil, err
+	}
+	c.conf = conf
+	c.store = store
+
+	if err = c.setGenesis(NewGenesisBlock(NewManifest(&c.conf))); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewTestConfiguration returns the configuration of an election
+// with two selections and two voters, who share a DSA key.
+func NewTestConfiguration() (conf Configuration, err error) {
+
+	// small keys keep the tests fast
+	params := new(dsa.Parameters)
+	if err = dsa.GenerateParameters(params, rand.Reader, dsa.L1024N160); err != nil {
+		return conf, err
+	}
+	priv := new(dsa.PrivateKey)
+	priv.PublicKey.Parameters = *params
+	if err = dsa.GenerateKey(priv, rand.Reader); err != nil {
+		return conf, err
+	}
+
+	key, err := crypto.GenerateKeyPair(256)
+	if err != nil {
+		return conf, err
+	}
+
+	conf = Configuration{
+		PrivateKey: *priv,
+		VoteTokens: map[string]dsa.PublicKey{
+			testToken:  priv.PublicKey,
+			otherToken: priv.PublicKey,
+		},
+		MyToken:    testToken,
+		ElectionID: "test",
+		ElectionFormat: election.Format{
+			NumSelections: 2,
+			Selections:    []election.Selection{{Name: "yes"}, {Name: "no"}},
+		},
+		ElectionKey: *key,
+	}
+	conf.Params = DefaultChainParams()
+	conf.Params.Difficulty = testDifficulty
+	return conf, nil
+}
+
+// NewTestTransaction returns a signed transaction containing a
+// ballot with a vote for the first selection.
+func NewTestTransaction(c *Chain) (tr *Transaction, err error) {
+	return NewTestTransactionFor(c, testToken)
+}
+
+// NewTestTransactionFor returns a signed transaction for the vote
+// token vt containing a ballot with a vote for the first selection.
+func NewTestTransactionFor(c *Chain, vt string) (tr *Transaction, err error) {
+
+	ballot := &election.Ballot{
+		VoteToken:     vt,
+		NumSelections: 2,
+		Selections: []election.Selection{
+			{Name: "yes", Vote: big.NewInt(1)},
+			{Name: "no", Vote: big.NewInt(0)},
+		},
+	}
+	return c.NewTransaction(vt, ballot)
+}
+
+// CopyTransaction returns a deep copy of tr.
+func CopyTransaction(tr *Transaction) (copied *Transaction) {
+
+	copied = &Transaction{Header: tr.Header, Ballot: tr.Ballot}
+	copied.Ballot.Selections = make([]election.Selection, len(tr.Ballot.Selections))
+	for i, s := range tr.Ballot.Selections {
+		copied.Ballot.Selections[i] = election.Selection{
+			Name:  s.Name,
+			Vote:  new(big.Int).Set(s.Vote),
+			Proof: append([]byte(nil), s.Proof...),
+		}
+	}
+	copied.Ballot.Proof = append([]byte(nil), tr.Ballot.Proof...)
+	return copied
+}
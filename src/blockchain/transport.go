@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+// Conn is a connection to the chain of a peer, on which its RPC
+// functions are called.
+type Conn interface {
+	// Call calls the RPC function method with args, and waits for
+	// its reply to be written to reply.
+	Call(method string, args, reply interface{}) error
+	Close() error
+}
+
+// Listener is the end of a Transport which serves the RPC
+// functions of a chain until it is closed.
+type Listener interface {
+	// Addr returns the address which the chain is served at.
+	Addr() string
+	Close() error
+}
+
+// Transport carries the RPC calls between the nodes of an election.
+type Transport interface {
+	// Dial opens a connection to the chain served at addr.
+	Dial(addr string) (Conn, error)
+
+	// Broadcast calls the RPC function method with args on each of
+	// the peers, without waiting for them to reply.
+	Broadcast(peers []string, method string, args interface{})
+
+	// Serve makes the RPC functions of the chain c available at
+	// addr, until the returned Listener is closed.
+	Serve(addr string, c *Chain) (Listener, error)
+}
+
+// HTTPTransport is a Transport which carries RPC calls over HTTP
+// with net/rpc, on TCP connections.
+type HTTPTransport struct{}
+
+// Dial opens a TCP connection to the chain served at addr.
+func (t HTTPTransport) Dial(addr string) (Conn, error) {
+	conn, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Broadcast calls the RPC function method with args on each of the
+// peers, without waiting for them to reply.
+func (t HTTPTransport) Broadcast(peers []string, method string, args interface{}) {
+	broadcast(t, peers, method, args)
+}
+
+// Serve makes the RPC functions of the chain c available over HTTP
+// on the TCP address addr, with an RPC server and mux of its own.
+func (t HTTPTransport) Serve(addr string, c *Chain) (Listener, error) {
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return serveRPC(ln, c)
+}
+
+// serveRPC will serve the RPC functions of the chain c over HTTP on
+// the listener ln, with an RPC server and mux of its own.
+func serveRPC(ln net.Listener, c *Chain) (Listener, error) {
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Chain", c); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return serveHTTP(ln, server), nil
+}
+
+// serveHTTP will serve the RPC handler over HTTP on the listener
+// ln, with a mux of its own.
+func serveHTTP(ln net.Listener, handler http.Handler) Listener {
+
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, handler)
+
+	go http.Serve(ln, mux)
+	return tcpListener{ln}
+}
+
+// tcpListener is the Listener of an HTTPTransport or TLSTransport.
+type tcpListener struct {
+	net.Listener
+}
+
+// Addr returns the TCP address which the chain is served at.
+func (l tcpListener) Addr() string {
+	return l.Listener.Addr().String()
+}
+
+// broadcast will dial each of the peers with the Transport t, and
+// call the RPC function method with args on them, each in its own
+// goroutine.
+func broadcast(t Transport, peers []string, method string, args interface{}) {
+	for _, p := range peers {
+		go func(p string) {
+			conn, err := t.Dial(p)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if err = conn.Call(method, args, nil); err != nil {
+				log.Println("Error calling", method, "on peer", p+":", err)
+			}
+		}(p)
+	}
+}
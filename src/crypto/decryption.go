@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// ProveDecryption returns a proof that the message m is the
+// decryption of the ciphertext c, which can be checked by anyone
+// holding the public key with VerifyDecryption. The proof is the
+// random value r for which c = g^m . r^n mod n^2, which is
+// recovered as r = (c.g^-m)^(n^-1 mod Lambda) mod n.
+func (key *PrivateKey) ProveDecryption(c, m *big.Int) (r *big.Int, err error) {
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	if c == nil || m == nil {
+		return nil, InvalidCiphertextError
+	}
+
+	u, err := key.PublicKey.residue(c, m)
+	if err != nil {
+		return nil, err
+	}
+	u.Mod(u, key.PublicKey.N)
+
+	e := new(big.Int).ModInverse(key.PublicKey.N, key.Lambda)
+	if e == nil {
+		return nil, InvalidPrivateKeyError
+	}
+	r = new(big.Int).Exp(u, e, key.PublicKey.N)
+
+	if !key.PublicKey.VerifyDecryption(c, m, r) {
+		return nil, InvalidCiphertextError
+	}
+	return r, nil
+}
+
+// VerifyDecryption checks that the message m is the decryption
+// of the ciphertext c, using the proof r from ProveDecryption.
+// This is true when c = g^m . r^n mod n^2.
+func (key *PublicKey) VerifyDecryption(c, m, r *big.Int) (valid bool) {
+
+	if key.Validate() != nil || c == nil || m == nil || r == nil {
+		return false
+	}
+	if c.Sign() <= 0 || c.Cmp(key.NSquared) >= 0 ||
+		m.Sign() < 0 || m.Cmp(key.N) >= 0 ||
+		r.Sign() <= 0 || r.Cmp(key.N) >= 0 {
+		return false
+	}
+
+	v := new(big.Int).Exp(key.Generator, m, key.NSquared)
+	v.Mul(v, new(big.Int).Exp(r, key.N, key.NSquared))
+	v.Mod(v, key.NSquared)
+	return v.Cmp(c) == 0
+}
+
+// VerificationKey returns the public verification key v^s mod n^2
+// for a share s of the decryption key, where v is a base derived
+// from n. The verification key of each share should be made
+// public so that partial decryptions created with the share can
+// be checked with VerifyPartialDecryption.
+func (key *PublicKey) VerificationKey(share Share) (vk *big.Int, err error) {
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	if share.X == nil || share.Y == nil {
+		return nil, InvalidShareError
+	}
+	return expMod(key.verificationBase(), share.Y, key.NSquared), nil
+}
+
+// VerifyPartialDecryption checks that part was created from the
+// ciphertext c with the share of the decryption key that has the
+// verification key vk. The proof shows that the discrete logs of
+// part.Value^2 to the base c^2 and of vk to the base v are equal.
+func (key *PublicKey) VerifyPartialDecryption(c *big.Int, part PartialDecryption, vk *big.Int) (valid bool) {
+
+	if key.Validate() != nil || c == nil || vk == nil ||
+		part.Value == nil || part.E == nil || part.Z == nil {
+		return false
+	}
+	if c.Sign() <= 0 || c.Cmp(key.NSquared) >= 0 ||
+		part.Value.Sign() <= 0 || part.Value.Cmp(key.NSquared) >= 0 ||
+		vk.Sign() <= 0 || vk.Cmp(key.NSquared) >= 0 ||
+		part.E.Sign() < 0 || part.E.BitLen() > challengeBits {
+		return false
+	}
+
+	v := key.verificationBase()
+	c2 := new(big.Int).Exp(c, two, key.NSquared)
+	value2 := new(big.Int).Exp(part.Value, two, key.NSquared)
+
+	// a = (c^2)^z . (ci^2)^-e and b = v^z . vk^-e
+	a := expMod(c2, part.Z, key.NSquared)
+	a.Mul(a, expMod(value2, new(big.Int).Neg(part.E), key.NSquared))
+	a.Mod(a, key.NSquared)
+	b := expMod(v, part.Z, key.NSquared)
+	b.Mul(b, expMod(vk, new(big.Int).Neg(part.E), key.NSquared))
+	b.Mod(b, key.NSquared)
+
+	e := key.partialChallenge(c, part.Value, vk, a, b)
+	return e.Cmp(part.E) == 0
+}
+
+// proveExponent sets the proof for a partial decryption which
+// was created with the share s. This is a Chaum-Pedersen proof
+// made non-interactive with the Fiat-Shamir heuristic. Since the
+// order of the group is not known, the response z = w + e.s is
+// computed over the integers with w large enough to hide s.
+func (key *PublicKey) proveExponent(c *big.Int, s *big.Int, part *PartialDecryption) (err error) {
+
+	bits := s.BitLen() + challengeBits + statisticalBits
+	w, err := rand.Int(rand.Reader, new(big.Int).Lsh(one, uint(bits)))
+	if err != nil {
+		return err
+	}
+
+	v := key.verificationBase()
+	vk := expMod(v, s, key.NSquared)
+	c2 := new(big.Int).Exp(c, two, key.NSquared)
+
+	a := new(big.Int).Exp(c2, w, key.NSquared)
+	b := new(big.Int).Exp(v, w, key.NSquared)
+
+	part.E = key.partialChallenge(c, part.Value, vk, a, b)
+	part.Z = new(big.Int).Mul(part.E, s)
+	part.Z.Add(part.Z, w)
+	return nil
+}
+
+// partialChallenge returns the Fiat-Shamir challenge for the
+// proof of a partial decryption.
+func (key *PublicKey) partialChallenge(c, value, vk, a, b *big.Int) (e *big.Int) {
+
+	var buf bytes.Buffer
+	WriteInts(&buf, key.N, key.Generator, key.verificationBase())
+	WriteInts(&buf, c, value, vk, a, b)
+
+	h := sha256.Sum256(buf.Bytes())
+	return new(big.Int).SetBytes(h[:])
+}
+
+// verificationBase returns the base v used for verification keys,
+// which is a square derived from a hash of n so that anyone can
+// compute it from the public key.
+func (key *PublicKey) verificationBase() (v *big.Int) {
+
+	var counter uint64
+	for {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], counter)
+		h := sha256.Sum256(append(key.N.Bytes(), buf[:]...))
+		counter++
+
+		v = new(big.Int).SetBytes(h[:])
+		v.Exp(v, two, key.NSquared)
+		if v.Cmp(one) > 0 && new(big.Int).GCD(nil, nil, v, key.N).Cmp(one) == 0 {
+			return v
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package crypto_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestDecryptionProofs(t *testing.T) {
+
+	var tests = []struct {
+		votes []int64
+		claim int64
+		valid bool
+	}{
+		{[]int64{1, 0, 1, 1}, 3, true},
+		{[]int64{0, 0, 0}, 0, true},
+		{[]int64{1, 0, 1, 1}, 2, false},
+		{[]int64{1, 1}, 3, false},
+	}
+
+	priv, err := crypto.GenerateKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range tests {
+		got, err := CheckDecryptionProof(priv, c.votes, c.claim)
+		if got != c.valid {
+			t.Error("For input", c.votes, "with claimed total", c.claim, "expected", c.valid,
+				"got", got, "with error", err, "\n")
+		}
+	}
+
+	got, err := CheckPartialDecryptionProofs(priv, 3, 5)
+	if got != true {
+		t.Error("Partial decryption proofs were not checked correctly", err, "\n")
+	}
+}
+
+func CheckDecryptionProof(priv *crypto.PrivateKey, votes []int64, claim int64) (valid bool, err error) {
+
+	key := &priv.PublicKey
+	sum, err := encryptSum(key, votes)
+	if err != nil {
+		return false, err
+	}
+
+	m, err := priv.Decrypt(sum)
+	if err != nil {
+		return false, err
+	}
+	r, err := priv.ProveDecryption(sum, m)
+	if err != nil {
+		return false, err
+	}
+
+	return key.VerifyDecryption(sum, big.NewInt(claim), r), nil
+}
+
+func CheckPartialDecryptionProofs(priv *crypto.PrivateKey, threshold, shares int) (success bool, err error) {
+
+	key := &priv.PublicKey
+	keyShares, err := priv.DivideKey(threshold, shares)
+	if err != nil {
+		return false, err
+	}
+
+	sum, err := encryptSum(key, []int64{1, 1, 0, 1})
+	if err != nil {
+		return false, err
+	}
+
+	var parts []crypto.PartialDecryption
+	var vks []*big.Int
+	for _, s := range keyShares {
+		part, err := key.PartialDecrypt(s, sum)
+		if err != nil {
+			return false, err
+		}
+		vk, err := key.VerificationKey(s)
+		if err != nil {
+			return false, err
+		}
+		if !key.VerifyPartialDecryption(sum, *part, vk) {
+			return false, nil
+		}
+		parts = append(parts, *part)
+		vks = append(vks, vk)
+	}
+
+	// a partial decryption should not verify against another share
+	if key.VerifyPartialDecryption(sum, parts[0], vks[1]) {
+		return false, nil
+	}
+
+	// or against another ciphertext
+	other, err := encryptSum(key, []int64{1})
+	if err != nil {
+		return false, err
+	}
+	if key.VerifyPartialDecryption(other, parts[0], vks[0]) {
+		return false, nil
+	}
+
+	// a partial decryption which has been altered should not verify
+	forged := parts[0]
+	forged.Value = new(big.Int).Mul(forged.Value, key.Generator)
+	forged.Value.Mod(forged.Value, key.NSquared)
+	if key.VerifyPartialDecryption(sum, forged, vks[0]) {
+		return false, nil
+	}
+
+	m, err := key.CombinePartialDecryptions(parts[:threshold], shares)
+	if err != nil {
+		return false, err
+	}
+	return m.Cmp(big.NewInt(3)) == 0, nil
+}
+
+// encryptSum returns the homomorphic sum of an encryption of each vote.
+func encryptSum(key *crypto.PublicKey, votes []int64) (sum *big.Int, err error) {
+
+	var ciphertexts []*big.Int
+	for _, v := range votes {
+		c, err := key.Encrypt(big.NewInt(v))
+		if err != nil {
+			return nil, err
+		}
+		ciphertexts = append(ciphertexts, c)
+	}
+	return key.SumCipherTexts(ciphertexts...)
+}
@@ -0,0 +1,713 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+var (
+	InvalidKeyGenParamsError  = errors.New("Invalid parameters for distributed key generation.")
+	InvalidKeyGenMessageError = errors.New("Invalid message received during distributed key generation.")
+	KeyGenFinishedError       = errors.New("Distributed key generation has already finished.")
+)
+
+const (
+	// biprimalityTests is the number of rounds of the distributed
+	// biprimality test that a candidate modulus must pass.
+	biprimalityTests = 32
+
+	// sieveBound is the bound on the small primes used in the
+	// trial division of candidate moduli.
+	sieveBound = 2000
+
+	// DefaultKeyGenCandidates is the default number of candidate
+	// moduli that are tested at the same time.
+	DefaultKeyGenCandidates = 2048
+
+	// MinKeyGenParties is the fewest parties which can generate a
+	// key together. The shares are polynomials of degree
+	// (Parties-1)/2, which must be at least 1 so that no party
+	// learns the factors of N, and the product of two of them
+	// needs 2.degree+1 parties to be interpolated.
+	MinKeyGenParties = 3
+)
+
+// phases of the distributed key generation protocol
+const (
+	phaseShareCandidates = iota
+	phaseComputeModuli
+	phaseTestModuli
+	phaseSelectModulus
+	phaseComputeMask
+	phaseShareExponent
+	phaseFinish
+	phaseVerificationKeys
+	phaseDone
+)
+
+// KeyGenParams contains the parameters of a distributed key
+// generation, which must be the same for every party.
+type KeyGenParams struct {
+	Parties    int // number of parties generating the key
+	Threshold  int // number of parties required to decrypt
+	Bits       int // size in bits of each of the primes of N
+	Candidates int // number of candidate moduli tested at once
+}
+
+// KeyGenMessage is sent from one party to another during a
+// distributed key generation. Parties are numbered from 1.
+// The meaning of Values depends on the Round of the protocol.
+type KeyGenMessage struct {
+	From   int
+	To     int
+	Round  int
+	Values []*big.Int
+}
+
+// KeyGenerator runs one party's side of a distributed key
+// generation, which creates a Paillier public key along with a
+// share of its decryption exponent for each party, without any
+// party ever learning the factors of N, Lambda or Mu.
+//
+// The modulus N = p.q is created using the protocol of Boneh
+// and Franklin. Each party i chooses pi and qi, where p and q
+// are the sums of these values. N is computed using the BGW
+// protocol on Shamir shares of each pi and qi, and candidates
+// are checked with trial division and a distributed biprimality
+// test. The parties then compute theta = phi.beta + N.R, where
+// beta and R are random values chosen by the parties, which
+// allows each party to derive an additive share of an exponent
+// d, where d = 0 mod phi and d = 1 mod N. Each of these shares
+// is then divided over the integers, so that the resulting key
+// shares work with PartialDecrypt and CombinePartialDecryptions.
+//
+// The protocol is secure against honest but curious parties,
+// as long as fewer than half of the parties collude. Each party
+// must send the messages returned by Start and Step to the
+// parties named in them, and must pass every message of the
+// current round to Step. Messages with To equal to the sending
+// party must also be passed back to it.
+type KeyGenerator struct {
+	params KeyGenParams
+	index  int
+	round  int
+	phase  int
+	degree int      // degree of the BGW polynomials
+	field  *big.Int // prime modulus of the BGW computations
+
+	// values for each of the current candidate moduli
+	p, q       []*big.Int // this party's pi and qi
+	pSum, qSum []*big.Int // shares of p and q
+	moduli     []*big.Int // candidate moduli
+	survivors  []int      // candidates which passed trial division
+
+	// values for the selected modulus
+	chosen int
+	n      *big.Int
+	r      *big.Int // this party's part of R
+	beta   *big.Int // share of beta
+
+	key          *PublicKey
+	share        Share
+	verification []*big.Int // verification keys of each party
+}
+
+// NewKeyGenerator returns a KeyGenerator for the party with the
+// given index, where 1 <= index <= params.Parties.
+func NewKeyGenerator(params KeyGenParams, index int) (kg *KeyGenerator, err error) {
+
+	if params.Candidates == 0 {
+		params.Candidates = DefaultKeyGenCandidates
+	}
+	if params.Parties < MinKeyGenParties || params.Threshold < 1 || params.Threshold > params.Parties ||
+		params.Bits < 16 || params.Candidates < 1 || index < 1 || index > params.Parties {
+		return nil, InvalidKeyGenParamsError
+	}
+
+	kg = &KeyGenerator{
+		params: params,
+		index:  index,
+		degree: (params.Parties - 1) / 2,
+	}
+
+	// theta = phi.beta + N.R must be smaller than the field
+	nBits := 2 * params.Bits
+	partyBits := big.NewInt(int64(params.Parties)).BitLen()
+	kg.field = nextPrime(new(big.Int).Lsh(one, uint(2*nBits+statisticalBits+2*partyBits+8)))
+
+	return kg, nil
+}
+
+// Round returns the current round of the protocol, starting at 0.
+func (kg *KeyGenerator) Round() int {
+	return kg.round
+}
+
+// Done returns whether the key generation has finished.
+func (kg *KeyGenerator) Done() bool {
+	return kg.phase == phaseDone
+}
+
+// Result returns the public key and this party's share of the
+// decryption exponent once the key generation has finished.
+func (kg *KeyGenerator) Result() (key *PublicKey, share Share) {
+	return kg.key, kg.share
+}
+
+// VerificationKeys returns the verification key of the share
+// held by each party once the key generation has finished, where
+// the key of party i is at index i-1. These allow the partial
+// decryptions of each party to be checked.
+func (kg *KeyGenerator) VerificationKeys() (keys []*big.Int) {
+	return kg.verification
+}
+
+// Start returns the messages for the first round of the protocol.
+func (kg *KeyGenerator) Start() (out []KeyGenMessage, err error) {
+	if kg.round != 0 || kg.phase != phaseShareCandidates {
+		return nil, KeyGenFinishedError
+	}
+	return kg.shareCandidates()
+}
+
+// Step takes the messages sent to this party in the current
+// round, one from each party, and returns the messages for the
+// next round. Once Done returns true, no messages are returned.
+func (kg *KeyGenerator) Step(in []KeyGenMessage) (out []KeyGenMessage, err error) {
+
+	if kg.phase == phaseDone {
+		return nil, KeyGenFinishedError
+	}
+
+	msgs := make([][]*big.Int, kg.params.Parties+1)
+	for _, m := range in {
+		if m.Round != kg.round || m.To != kg.index || m.From < 1 || m.From > kg.params.Parties {
+			return nil, InvalidKeyGenMessageError
+		}
+		if msgs[m.From] != nil {
+			return nil, InvalidKeyGenMessageError
+		}
+		for _, v := range m.Values {
+			if v == nil {
+				return nil, InvalidKeyGenMessageError
+			}
+		}
+		msgs[m.From] = m.Values
+	}
+	if len(in) != kg.params.Parties {
+		return nil, InvalidKeyGenMessageError
+	}
+	kg.round++
+
+	switch kg.phase {
+	case phaseComputeModuli:
+		return kg.computeModuli(msgs)
+	case phaseTestModuli:
+		return kg.testModuli(msgs)
+	case phaseSelectModulus:
+		return kg.selectModulus(msgs)
+	case phaseComputeMask:
+		return kg.computeMask(msgs)
+	case phaseShareExponent:
+		return kg.shareExponent(msgs)
+	case phaseFinish:
+		return kg.finish(msgs)
+	case phaseVerificationKeys:
+		return nil, kg.verificationKeys(msgs)
+	}
+	return nil, InvalidKeyGenMessageError
+}
+
+// shareCandidates chooses pi and qi for each candidate modulus,
+// and sends Shamir shares of them to each party, along with a
+// share of zero used to randomize the product.
+func (kg *KeyGenerator) shareCandidates() (out []KeyGenMessage, err error) {
+
+	count := kg.params.Candidates
+	kg.p = make([]*big.Int, count)
+	kg.q = make([]*big.Int, count)
+	kg.pSum = make([]*big.Int, count)
+	kg.qSum = make([]*big.Int, count)
+
+	values := make([][]*big.Int, kg.params.Parties+1)
+	for j := range values {
+		values[j] = make([]*big.Int, 0, 3*count)
+	}
+
+	for b := 0; b < count; b++ {
+		if kg.p[b], err = kg.primePart(); err != nil {
+			return nil, err
+		}
+		if kg.q[b], err = kg.primePart(); err != nil {
+			return nil, err
+		}
+
+		pPoly, err := randomPolynomial(kg.p[b], kg.degree+1, kg.field)
+		if err != nil {
+			return nil, err
+		}
+		qPoly, err := randomPolynomial(kg.q[b], kg.degree+1, kg.field)
+		if err != nil {
+			return nil, err
+		}
+		zPoly, err := randomPolynomial(new(big.Int), 2*kg.degree+1, kg.field)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 1; j <= kg.params.Parties; j++ {
+			x := big.NewInt(int64(j))
+			values[j] = append(values[j],
+				kg.reduce(pPoly.solve(x)),
+				kg.reduce(qPoly.solve(x)),
+				kg.reduce(zPoly.solve(x)))
+		}
+	}
+
+	kg.phase = phaseComputeModuli
+	return kg.messages(values), nil
+}
+
+// primePart returns a random value for this party's part of a
+// prime. The parts are chosen so that their sum has exactly
+// Bits bits, and is 3 mod 4, as required by the biprimality test.
+func (kg *KeyGenerator) primePart() (v *big.Int, err error) {
+
+	partyBits := big.NewInt(int64(kg.params.Parties)).BitLen()
+	bound := new(big.Int).Lsh(one, uint(kg.params.Bits-1-partyBits))
+
+	v, err = rand.Int(rand.Reader, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	if kg.index == 1 {
+		// 3 mod 4, with the top bit set
+		v.SetBit(v, 0, 1)
+		v.SetBit(v, 1, 1)
+		v.SetBit(v, kg.params.Bits-1, 1)
+	} else {
+		// 0 mod 4
+		v.SetBit(v, 0, 0)
+		v.SetBit(v, 1, 0)
+	}
+	return v, nil
+}
+
+// computeModuli computes a share of each candidate modulus
+// N = p.q, and sends it to every party.
+func (kg *KeyGenerator) computeModuli(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	count := kg.params.Candidates
+	moduli := make([]*big.Int, count)
+
+	for b := 0; b < count; b++ {
+		p, q, z := new(big.Int), new(big.Int), new(big.Int)
+		for i := 1; i <= kg.params.Parties; i++ {
+			if len(msgs[i]) != 3*count {
+				return nil, InvalidKeyGenMessageError
+			}
+			p.Add(p, msgs[i][3*b])
+			q.Add(q, msgs[i][3*b+1])
+			z.Add(z, msgs[i][3*b+2])
+		}
+		kg.pSum[b] = kg.reduce(p)
+		kg.qSum[b] = kg.reduce(q)
+
+		// a share of N on a polynomial of degree 2t
+		moduli[b] = kg.reduce(new(big.Int).Add(new(big.Int).Mul(kg.pSum[b], kg.qSum[b]), z))
+	}
+
+	kg.phase = phaseTestModuli
+	return kg.broadcast(moduli), nil
+}
+
+// testModuli reconstructs each candidate modulus, and performs
+// trial division on them. For each candidate which survives,
+// this party's values for the biprimality test are sent to
+// every party. If no candidates survive, new candidates are
+// created.
+func (kg *KeyGenerator) testModuli(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	count := kg.params.Candidates
+	kg.moduli = make([]*big.Int, count)
+	kg.survivors = kg.survivors[:0]
+
+	points := make([]Share, kg.params.Parties)
+	for b := 0; b < count; b++ {
+		for i := 1; i <= kg.params.Parties; i++ {
+			if len(msgs[i]) != count {
+				return nil, InvalidKeyGenMessageError
+			}
+			points[i-1] = Share{X: big.NewInt(int64(i)), Y: msgs[i][b]}
+		}
+		n, err := Interpolate(points, kg.field)
+		if err != nil {
+			return nil, err
+		}
+		kg.moduli[b] = n
+		if n.BitLen() >= 2*kg.params.Bits-1 && !hasSmallFactor(n) {
+			kg.survivors = append(kg.survivors, b)
+		}
+	}
+
+	if len(kg.survivors) == 0 {
+		return kg.shareCandidates()
+	}
+
+	values := make([]*big.Int, 0, len(kg.survivors)*biprimalityTests)
+	for _, b := range kg.survivors {
+		values = append(values, kg.biprimalityValues(b)...)
+	}
+
+	kg.phase = phaseSelectModulus
+	return kg.broadcast(values), nil
+}
+
+// biprimalityValues returns this party's values v = g^(phi_i/4)
+// mod N of the biprimality test for the candidate b, where phi_i
+// is this party's additive share of phi(N).
+func (kg *KeyGenerator) biprimalityValues(b int) (values []*big.Int) {
+
+	n := kg.moduli[b]
+	exp := kg.phiPart(b)
+	exp.Rsh(exp, 2)
+
+	for _, g := range biprimalityBases(n) {
+		values = append(values, expMod(g, exp, n))
+	}
+	return values
+}
+
+// phiPart returns this party's additive share of phi(N) for the
+// candidate b, where phi(N) = N - p - q + 1. Party 1 holds
+// N - p1 - q1 + 1, and all other parties hold -(pi + qi). Each
+// share is a multiple of 4.
+func (kg *KeyGenerator) phiPart(b int) (phi *big.Int) {
+
+	phi = new(big.Int).Add(kg.p[b], kg.q[b])
+	phi.Neg(phi)
+	if kg.index == 1 {
+		phi.Add(phi, kg.moduli[b])
+		phi.Add(phi, one)
+	}
+	return phi
+}
+
+// selectModulus chooses the first candidate modulus which passes
+// the biprimality test. Each party then chooses its parts of the
+// random values beta and R, and sends shares of them to every
+// party. If no candidate passes, new candidates are created.
+func (kg *KeyGenerator) selectModulus(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	size := len(kg.survivors) * biprimalityTests
+	for i := 1; i <= kg.params.Parties; i++ {
+		if len(msgs[i]) != size {
+			return nil, InvalidKeyGenMessageError
+		}
+	}
+
+	kg.chosen = -1
+	for s, b := range kg.survivors {
+		if kg.isBiprime(b, msgs, s*biprimalityTests) {
+			kg.chosen = b
+			break
+		}
+	}
+	if kg.chosen < 0 {
+		return kg.shareCandidates()
+	}
+	kg.n = kg.moduli[kg.chosen]
+
+	// beta is shared with degree t, and R with degree 2t
+	nBits := kg.n.BitLen()
+	partyBits := big.NewInt(int64(kg.params.Parties)).BitLen()
+
+	beta, err := rand.Int(rand.Reader, kg.n)
+	if err != nil {
+		return nil, err
+	}
+	kg.r, err = rand.Int(rand.Reader, new(big.Int).Lsh(one, uint(nBits+statisticalBits+partyBits)))
+	if err != nil {
+		return nil, err
+	}
+
+	betaPoly, err := randomPolynomial(beta, kg.degree+1, kg.field)
+	if err != nil {
+		return nil, err
+	}
+	rPoly, err := randomPolynomial(kg.r, 2*kg.degree+1, kg.field)
+	if err != nil {
+		return nil, err
+	}
+	zPoly, err := randomPolynomial(new(big.Int), 2*kg.degree+1, kg.field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]*big.Int, kg.params.Parties+1)
+	for j := 1; j <= kg.params.Parties; j++ {
+		x := big.NewInt(int64(j))
+		values[j] = []*big.Int{
+			kg.reduce(betaPoly.solve(x)),
+			kg.reduce(rPoly.solve(x)),
+			kg.reduce(zPoly.solve(x)),
+		}
+	}
+
+	kg.phase = phaseComputeMask
+	return kg.messages(values), nil
+}
+
+// isBiprime checks the biprimality test values of every party for
+// the candidate b, which start at offset in each message. For each
+// base g, the product of the values must be g^(phi/4) = +-1 mod N.
+func (kg *KeyGenerator) isBiprime(b int, msgs [][]*big.Int, offset int) bool {
+
+	n := kg.moduli[b]
+	minusOne := new(big.Int).Sub(n, one)
+
+	for t := 0; t < biprimalityTests; t++ {
+		v := big.NewInt(1)
+		for i := 1; i <= kg.params.Parties; i++ {
+			v.Mul(v, msgs[i][offset+t])
+			v.Mod(v, n)
+		}
+		if v.Cmp(one) != 0 && v.Cmp(minusOne) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// computeMask computes a share of theta = phi.beta + N.R, and
+// sends it to every party.
+func (kg *KeyGenerator) computeMask(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	beta, r, z := new(big.Int), new(big.Int), new(big.Int)
+	for i := 1; i <= kg.params.Parties; i++ {
+		if len(msgs[i]) != 3 {
+			return nil, InvalidKeyGenMessageError
+		}
+		beta.Add(beta, msgs[i][0])
+		r.Add(r, msgs[i][1])
+		z.Add(z, msgs[i][2])
+	}
+	kg.beta = kg.reduce(beta)
+
+	// a share of phi = N + 1 - p - q
+	phi := new(big.Int).Add(kg.n, one)
+	phi.Sub(phi, kg.pSum[kg.chosen])
+	phi.Sub(phi, kg.qSum[kg.chosen])
+
+	theta := new(big.Int).Mul(phi, kg.beta)
+	theta.Add(theta, new(big.Int).Mul(kg.n, r))
+	theta.Add(theta, z)
+
+	kg.phase = phaseShareExponent
+	return kg.broadcast([]*big.Int{kg.reduce(theta)}), nil
+}
+
+// shareExponent reconstructs theta, and derives this party's
+// additive share of the decryption exponent d = phi.beta.t, where
+// t = theta^-1 mod N, so that d = 1 mod N. The share is divided
+// over the integers and sent to every party.
+func (kg *KeyGenerator) shareExponent(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	points := make([]Share, kg.params.Parties)
+	for i := 1; i <= kg.params.Parties; i++ {
+		if len(msgs[i]) != 1 {
+			return nil, InvalidKeyGenMessageError
+		}
+		points[i-1] = Share{X: big.NewInt(int64(i)), Y: msgs[i][0]}
+	}
+	theta, err := Interpolate(points, kg.field)
+	if err != nil {
+		return nil, err
+	}
+
+	t := new(big.Int).ModInverse(new(big.Int).Mod(theta, kg.n), kg.n)
+	if t == nil {
+		// theta shares a factor with N, which is very unlikely
+		return kg.shareCandidates()
+	}
+
+	// phi.beta = theta - N.R, so party 1 holds theta - N.R1
+	// and all other parties hold -N.Ri
+	x := new(big.Int).Mul(kg.n, kg.r)
+	x.Neg(x)
+	if kg.index == 1 {
+		x.Add(x, theta)
+	}
+	x.Mul(x, t)
+
+	delta := factorial(kg.params.Parties)
+	x.Mul(x, delta)
+
+	bound := new(big.Int).Lsh(one, uint(x.BitLen()+statisticalBits))
+	shares, err := divideIntegerSecret(x, kg.params.Threshold, kg.params.Parties, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]*big.Int, kg.params.Parties+1)
+	for j := 1; j <= kg.params.Parties; j++ {
+		values[j] = []*big.Int{shares[j-1].Y}
+	}
+
+	kg.phase = phaseFinish
+	return kg.messages(values), nil
+}
+
+// finish sums the shares received from each party to get this
+// party's share of the decryption exponent, and sends the
+// verification key of the share to every party.
+func (kg *KeyGenerator) finish(msgs [][]*big.Int) (out []KeyGenMessage, err error) {
+
+	y := new(big.Int)
+	for i := 1; i <= kg.params.Parties; i++ {
+		if len(msgs[i]) != 1 {
+			return nil, InvalidKeyGenMessageError
+		}
+		y.Add(y, msgs[i][0])
+	}
+
+	kg.key = &PublicKey{
+		N:         kg.n,
+		NSquared:  new(big.Int).Mul(kg.n, kg.n),
+		Generator: new(big.Int).Add(kg.n, one),
+	}
+	kg.share = Share{X: big.NewInt(int64(kg.index)), Y: y}
+
+	// forget the secret values used during the protocol
+	kg.p, kg.q, kg.pSum, kg.qSum = nil, nil, nil, nil
+	kg.r, kg.beta = nil, nil
+
+	vk, err := kg.key.VerificationKey(kg.share)
+	if err != nil {
+		return nil, err
+	}
+
+	kg.phase = phaseVerificationKeys
+	return kg.broadcast([]*big.Int{vk}), nil
+}
+
+// verificationKeys records the verification key of each party.
+func (kg *KeyGenerator) verificationKeys(msgs [][]*big.Int) (err error) {
+
+	keys := make([]*big.Int, kg.params.Parties)
+	for i := 1; i <= kg.params.Parties; i++ {
+		if len(msgs[i]) != 1 || msgs[i][0].Sign() <= 0 || msgs[i][0].Cmp(kg.key.NSquared) >= 0 {
+			return InvalidKeyGenMessageError
+		}
+		keys[i-1] = msgs[i][0]
+	}
+	kg.verification = keys
+
+	kg.phase = phaseDone
+	return nil
+}
+
+// messages returns a message to each party j with values[j].
+func (kg *KeyGenerator) messages(values [][]*big.Int) (out []KeyGenMessage) {
+	for j := 1; j <= kg.params.Parties; j++ {
+		out = append(out, KeyGenMessage{
+			From:   kg.index,
+			To:     j,
+			Round:  kg.round,
+			Values: values[j],
+		})
+	}
+	return out
+}
+
+// broadcast returns a message to every party with the same values.
+func (kg *KeyGenerator) broadcast(values []*big.Int) (out []KeyGenMessage) {
+	all := make([][]*big.Int, kg.params.Parties+1)
+	for j := range all {
+		all[j] = values
+	}
+	return kg.messages(all)
+}
+
+// reduce returns v mod the field prime.
+func (kg *KeyGenerator) reduce(v *big.Int) *big.Int {
+	return new(big.Int).Mod(v, kg.field)
+}
+
+// biprimalityBases returns the bases used in the biprimality test
+// of the modulus n. They are derived from n so that each party
+// uses the same values, and each has a Jacobi symbol of 1.
+func biprimalityBases(n *big.Int) (bases []*big.Int) {
+
+	var counter uint64
+	for len(bases) < biprimalityTests {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], counter)
+		h := sha256.Sum256(append(n.Bytes(), buf[:]...))
+		counter++
+
+		g := new(big.Int).SetBytes(h[:])
+		g.Mod(g, n)
+		if g.Sign() == 0 || big.Jacobi(g, n) != 1 {
+			continue
+		}
+		bases = append(bases, g)
+	}
+	return bases
+}
+
+// expMod returns g^e mod n, where e may be negative.
+func expMod(g, e, n *big.Int) *big.Int {
+	if e.Sign() >= 0 {
+		return new(big.Int).Exp(g, e, n)
+	}
+	v := new(big.Int).Exp(g, new(big.Int).Neg(e), n)
+	if v.ModInverse(v, n) == nil {
+		return new(big.Int)
+	}
+	return v
+}
+
+// smallPrimes contains the odd primes below sieveBound.
+var smallPrimes = sieve(sieveBound)
+
+// hasSmallFactor returns whether n is divisible by any of the
+// small primes.
+func hasSmallFactor(n *big.Int) bool {
+	m := new(big.Int)
+	for _, p := range smallPrimes {
+		if m.Mod(n, p).Sign() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sieve returns the odd primes below bound.
+func sieve(bound int) (primes []*big.Int) {
+	composite := make([]bool, bound)
+	for i := 3; i < bound; i += 2 {
+		if composite[i] {
+			continue
+		}
+		primes = append(primes, big.NewInt(int64(i)))
+		for j := i * i; j < bound; j += 2 * i {
+			composite[j] = true
+		}
+	}
+	return primes
+}
+
+// nextPrime returns the smallest prime greater than n.
+func nextPrime(n *big.Int) (p *big.Int) {
+	p = new(big.Int).Add(n, one)
+	p.SetBit(p, 0, 1)
+	for !p.ProbablyPrime(20) {
+		p.Add(p, big.NewInt(2))
+	}
+	return p
+}
@@ -0,0 +1,147 @@
+package crypto_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestDistributedKeyGeneration(t *testing.T) {
+
+	var tests = []struct {
+		parties       int
+		threshold     int
+		collaborators int
+		decrypts      bool
+	}{
+		{3, 2, 2, true},
+		{3, 3, 3, true},
+		{4, 2, 3, true},
+		{5, 3, 2, false},
+	}
+
+	for i, c := range tests {
+		success, err := CheckDistributedKeyGeneration(c.parties, c.threshold, c.collaborators)
+		if success != c.decrypts {
+			t.Error("Test no:", i, "For", c.parties, "parties with threshold", c.threshold, "and",
+				c.collaborators, "collaborators expected", c.decrypts, "got", success, "with error", err, "\n")
+		}
+	}
+
+	_, err := crypto.NewKeyGenerator(crypto.KeyGenParams{Parties: 3, Threshold: 4, Bits: 64}, 1)
+	if err != crypto.InvalidKeyGenParamsError {
+		t.Error("Expected", crypto.InvalidKeyGenParamsError, "for threshold larger than parties, got", err, "\n")
+	}
+
+	// fewer than three parties would share polynomials of degree 0
+	for parties := 1; parties < crypto.MinKeyGenParties; parties++ {
+		_, err = crypto.NewKeyGenerator(crypto.KeyGenParams{Parties: parties, Threshold: 1, Bits: 64}, 1)
+		if err != crypto.InvalidKeyGenParamsError {
+			t.Error("Expected", crypto.InvalidKeyGenParamsError, "for", parties, "parties, got", err, "\n")
+		}
+	}
+}
+
+func CheckDistributedKeyGeneration(parties, threshold, collaborators int) (success bool, err error) {
+
+	params := crypto.KeyGenParams{
+		Parties:    parties,
+		Threshold:  threshold,
+		Bits:       64,
+		Candidates: 256,
+	}
+
+	keys, shares, verificationKeys, err := RunKeyGeneration(params)
+	if err != nil {
+		return false, err
+	}
+
+	// every party must agree on the public key
+	key := keys[0]
+	for _, k := range keys[1:] {
+		if k.N.Cmp(key.N) != 0 {
+			return false, nil
+		}
+	}
+
+	var ciphertexts []*big.Int
+	for _, v := range []int64{1, 1, 0, 1, 1} {
+		c, err := key.Encrypt(big.NewInt(v))
+		if err != nil {
+			return false, err
+		}
+		ciphertexts = append(ciphertexts, c)
+	}
+	sum, err := key.SumCipherTexts(ciphertexts...)
+	if err != nil {
+		return false, err
+	}
+
+	var parts []crypto.PartialDecryption
+	for _, s := range randomSlice(collaborators, shares) {
+		part, err := key.PartialDecrypt(s, sum)
+		if err != nil {
+			return false, err
+		}
+		// each party must agree on the verification keys
+		index := int(s.X.Int64()) - 1
+		for _, vks := range verificationKeys {
+			if !key.VerifyPartialDecryption(sum, *part, vks[index]) {
+				return false, nil
+			}
+		}
+		parts = append(parts, *part)
+	}
+
+	m, err := key.CombinePartialDecryptions(parts, parties)
+	if err != nil {
+		return false, err
+	}
+
+	return m.Cmp(big.NewInt(4)) == 0, nil
+}
+
+// RunKeyGeneration runs a distributed key generation between
+// each of the parties, passing messages between them directly.
+func RunKeyGeneration(params crypto.KeyGenParams) (keys []*crypto.PublicKey, shares []crypto.Share,
+	verificationKeys [][]*big.Int, err error) {
+
+	generators := make([]*crypto.KeyGenerator, params.Parties)
+	inboxes := make([][]crypto.KeyGenMessage, params.Parties)
+
+	for i := range generators {
+		generators[i], err = crypto.NewKeyGenerator(params, i+1)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		out, err := generators[i].Start()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, m := range out {
+			inboxes[m.To-1] = append(inboxes[m.To-1], m)
+		}
+	}
+
+	for !generators[0].Done() {
+		next := make([][]crypto.KeyGenMessage, params.Parties)
+		for i, kg := range generators {
+			out, err := kg.Step(inboxes[i])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			for _, m := range out {
+				next[m.To-1] = append(next[m.To-1], m)
+			}
+		}
+		inboxes = next
+	}
+
+	for _, kg := range generators {
+		key, share := kg.Result()
+		keys = append(keys, key)
+		shares = append(shares, share)
+		verificationKeys = append(verificationKeys, kg.VerificationKeys())
+	}
+	return keys, shares, verificationKeys, nil
+}
@@ -80,6 +80,30 @@
    of plaintext_a + plaintext_b mod N. To add ciphertexts, the key used
    can be either the public or private key.
 
+   Zero-knowledge proofs
+
+   A proof that a ciphertext encrypts either 0 or 1 can be created
+   without revealing the plaintext. The nonce used during encryption
+   is required to create the proof:
+
+       ciphertext, nonce, err := key.EncryptWithNonce(plaintext)
+       proof, err := key.ProveBinary(ciphertext, plaintext, nonce, context)
+
+   The proof is bound to the value of context, and is checked with:
+
+       valid := key.VerifyBinary(ciphertext, proof, context)
+
+   The more general ProveMembership and VerifyMembership functions
+   allow a proof that the plaintext is any one of a set of values.
+   Proofs can be stored using proof.Bytes() and ParseMembershipProof.
+
+   A proof that the homomorphic sum of a set of ciphertexts encrypts
+   a value between min and max is created from the plaintexts and
+   nonces of each ciphertext:
+
+       proof, err := key.ProveSumInRange(ciphertexts, plaintexts, nonces, min, max, context)
+       valid := key.VerifySumInRange(ciphertexts, min, max, proof, context)
+
    Secret sharing
 
    Secret sharing of a *big.Int can be performed as follows:
@@ -94,6 +118,18 @@
    redundancy of the system. The value of prime should be made public to allow
    the reconstruction of the polynomial.
 
+   Verifiable secret sharing
+
+   Shares can be created along with public commitments to the
+   polynomial used to create them:
+
+       shares, prime, commitments, err := crypto.DivideSecretVerifiable(secret, threshold, numShares)
+
+   The commitments should be made public along with the prime. Any
+   user can then check that a share is consistent with the others:
+
+       valid := crypto.VerifyShare(share, commitments)
+
    Interpolation
 
    Shares can be interpolated to reconstruct a secret as follows. Given:
@@ -107,5 +143,65 @@
 
    If the amount of shares used is not at least equal to the threshold,
    then the value of secret will not be correct.
+
+   Threshold decryption
+
+   Rather than sharing Lambda and Mu, the decryption exponent of a
+   key can be divided so that the private key is never reconstructed:
+
+       shares, err := priv.DivideKey(threshold, numShares)
+
+   Each holder of a share can partially decrypt a ciphertext, such as
+   the homomorphic sum of a set of votes:
+
+       part, err := key.PartialDecrypt(share, ciphertext)
+
+   At least threshold partial decryptions of the same ciphertext are
+   combined to get the plaintext:
+
+       plaintext, err := key.CombinePartialDecryptions(parts, numShares)
+
+   The ciphertext must be the same for every share holder, so sums
+   should be created with key.SumCipherTexts, which unlike
+   AddCipherTexts does not re-randomize the result.
+
+   Decryption proofs
+
+   Anyone with the public key can check that a plaintext is the
+   decryption of a ciphertext. With the private key, the proof is
+   the random value used to encrypt the ciphertext:
+
+       proof, err := priv.ProveDecryption(ciphertext, plaintext)
+       valid := key.VerifyDecryption(ciphertext, plaintext, proof)
+
+   In threshold decryption, each partial decryption contains a proof
+   that it was made with the correct share. The verification key of
+   each share should be made public when the key is divided:
+
+       vk, err := key.VerificationKey(share)
+       valid := key.VerifyPartialDecryption(ciphertext, *part, vk)
+
+   Distributed key generation
+
+   A key can be created by a group of parties so that no party ever
+   knows the private key. Each party creates a KeyGenerator with the
+   same parameters and its own index, starting at 1:
+
+       params := crypto.KeyGenParams{Parties: 3, Threshold: 2, Bits: 512}
+       kg, err := crypto.NewKeyGenerator(params, index)
+       out, err := kg.Start()
+
+   The messages in out are sent to the parties they are addressed to.
+   Once a party has received a message from every party for the
+   current round, it passes them to Step to get the messages for the
+   next round:
+
+       out, err = kg.Step(in)
+
+   When kg.Done() returns true, every party holds the same public key
+   and its own share for use with PartialDecrypt:
+
+       key, share := kg.Result()
+       vks := kg.VerificationKeys()
 */
 package crypto
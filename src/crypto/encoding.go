@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+var EncodingError = errors.New("Invalid canonical encoding.")
+
+// The canonical encoding is used for every value which is hashed or
+// signed, in this package and by the packages built on it, so that
+// two values have the same encoding only if they are the same.
+// Variable length values are prefixed by their length, and integers
+// also by their sign.
+
+// WriteBytes writes the slice v to buf, prefixed by its length.
+func WriteBytes(buf *bytes.Buffer, v []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(v)))
+	buf.Write(v)
+}
+
+// WriteString writes the string v to buf, prefixed by its length.
+func WriteString(buf *bytes.Buffer, v string) {
+	WriteBytes(buf, []byte(v))
+}
+
+// WriteInt writes v to buf as a sign byte followed by its
+// length prefixed big-endian magnitude. A nil value has a
+// different encoding to zero.
+func WriteInt(buf *bytes.Buffer, v *big.Int) {
+	switch {
+	case v == nil:
+		buf.WriteByte(0)
+		return
+	case v.Sign() < 0:
+		buf.WriteByte(2)
+	default:
+		buf.WriteByte(1)
+	}
+	WriteBytes(buf, v.Bytes())
+}
+
+// WriteInts writes each of the values to buf with WriteInt.
+func WriteInts(buf *bytes.Buffer, values ...*big.Int) {
+	for _, v := range values {
+		WriteInt(buf, v)
+	}
+}
+
+// ReadBytes reads a slice written by WriteBytes from buf.
+func ReadBytes(buf *bytes.Reader) (v []byte, err error) {
+
+	var l uint32
+	if err = binary.Read(buf, binary.BigEndian, &l); err != nil {
+		return nil, EncodingError
+	}
+	if uint64(l) > uint64(buf.Len()) {
+		return nil, EncodingError
+	}
+	v = make([]byte, l)
+	buf.Read(v)
+	return v, nil
+}
+
+// ReadInt reads a value written by WriteInt from buf. Only the
+// encoding written by WriteInt is accepted, so a magnitude with
+// leading zeros, or a negative zero, is an EncodingError.
+func ReadInt(buf *bytes.Reader) (v *big.Int, err error) {
+
+	sign, err := buf.ReadByte()
+	if err != nil || sign > 2 {
+		return nil, EncodingError
+	}
+	if sign == 0 {
+		return nil, nil
+	}
+	b, err := ReadBytes(buf)
+	if err != nil || (len(b) > 0 && b[0] == 0) || (sign == 2 && len(b) == 0) {
+		return nil, EncodingError
+	}
+	v = new(big.Int).SetBytes(b)
+	if sign == 2 {
+		v.Neg(v)
+	}
+	return v, nil
+}
@@ -0,0 +1,47 @@
+package crypto_test
+
+import (
+	"bytes"
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestIntEncoding(t *testing.T) {
+
+	var values = []*big.Int{nil, big.NewInt(0), big.NewInt(1), big.NewInt(-1), big.NewInt(256), big.NewInt(-70000)}
+
+	// each value is read back as it was written, and differs from
+	// the encodings of the other values
+	encodings := make(map[string]bool, len(values))
+	for _, v := range values {
+		var buf bytes.Buffer
+		crypto.WriteInt(&buf, v)
+		encodings[buf.String()] = true
+
+		read, err := crypto.ReadInt(bytes.NewReader(buf.Bytes()))
+		if err != nil || (read == nil) != (v == nil) || (v != nil && read.Cmp(v) != 0) {
+			t.Error("For input", v, "expected the same value, got", read, err, "\n")
+		}
+	}
+	if len(encodings) != len(values) {
+		t.Error("Expected a different encoding of each value, got", len(encodings), "\n")
+	}
+
+	var malformed = []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"unknown sign", []byte{3, 0, 0, 0, 0}},
+		{"leading zero", []byte{1, 0, 0, 0, 2, 0, 1}},
+		{"negative zero", []byte{2, 0, 0, 0, 0}},
+		{"truncated", []byte{1, 0, 0, 0, 2, 1}},
+	}
+
+	for _, test := range malformed {
+		if _, err := crypto.ReadInt(bytes.NewReader(test.data)); err != crypto.EncodingError {
+			t.Error("For input", test.name, "expected", crypto.EncodingError, "got", err, "\n")
+		}
+	}
+}
@@ -36,3 +36,22 @@ func (key *PublicKey) AddCipherTexts(ciphertexts ...*big.Int) (total *big.Int, e
 
 	return total, nil
 }
+
+// SumCipherTexts accepts one or more ciphertexts and returns
+// their homomorphic sum. Unlike AddCipherTexts, the sum is
+// not re-randomized, so any party summing the same ciphertexts
+// will get the same result. This allows a sum to be decrypted
+// by several parties using PartialDecrypt.
+func (key *PublicKey) SumCipherTexts(ciphertexts ...*big.Int) (total *big.Int, err error) {
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	for _, ciphertext := range ciphertexts {
+		if ciphertext == nil {
+			return nil, InvalidCiphertextError
+		}
+	}
+
+	return key.product(ciphertexts), nil
+}
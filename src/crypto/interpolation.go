@@ -49,3 +49,30 @@ func calculateProduct(j Share, points []Share, prime *big.Int) (product *big.Int
 
 	return
 }
+
+// integerCoefficient returns the value delta multiplied by the
+// Lagrange coefficient of the point j at x = 0, which is:
+// delta . the product from m = 0, m != j, to k-1 of:
+// xm /( xm - xj )
+// If delta is a multiple of each of the denominators, as is the
+// case when delta = l! and each x is in the range 1 to l, the
+// result is an integer and can be used without a modulus. If the
+// result is not an integer, nil is returned.
+func integerCoefficient(j Share, points []Share, delta *big.Int) (coeff *big.Int) {
+
+	num := new(big.Int).Set(delta)
+	den := big.NewInt(1)
+
+	for _, s := range points {
+		if s.X.Cmp(j.X) != 0 {
+			num.Mul(num, s.X)
+			den.Mul(den, new(big.Int).Sub(s.X, j.X))
+		}
+	}
+
+	coeff, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil
+	}
+	return coeff
+}
@@ -13,7 +13,10 @@ var (
 	InvalidPrivateKeyError = errors.New("Invalid private key.")
 )
 
-var one = big.NewInt(1)
+var (
+	one = big.NewInt(1)
+	two = big.NewInt(2)
+)
 
 // PrivateKey contains the private components Lambda and Mu,
 // and the public components in PublicKey.
@@ -44,17 +47,26 @@ func (key *PrivateKey) Encrypt(m *big.Int) (c *big.Int, err error) {
 // encrypting the message m with the PrivateKey key. The
 // encryption of a given message m is non-deterministic.
 func (key *PublicKey) Encrypt(m *big.Int) (c *big.Int, err error) {
+	c, _, err = key.EncryptWithNonce(m)
+	return
+}
+
+// EncryptWithNonce behaves like Encrypt, but also returns the
+// random value r used in the encryption. The value of r must be
+// kept secret, but is required to create proofs about the
+// contents of the ciphertext c.
+func (key *PublicKey) EncryptWithNonce(m *big.Int) (c, r *big.Int, err error) {
 
 	if m == nil {
-		return nil, InvalidPlaintextError
+		return nil, nil, InvalidPlaintextError
 	}
 	if err = key.Validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	r, err := rand.Int(rand.Reader, key.N)
+	r, err = randomUnit(key.N)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// c = ((g^m).(r^n)) mod (n^2)
@@ -63,7 +75,7 @@ func (key *PublicKey) Encrypt(m *big.Int) (c *big.Int, err error) {
 			new(big.Int).Exp(key.Generator, m, key.NSquared),
 			new(big.Int).Exp(r, key.N, key.NSquared)), key.NSquared)
 
-	return c, err
+	return c, r, err
 }
 
 // Decrypt returns the message m which is obtained from
@@ -178,6 +190,23 @@ func generatePrimePair(bits int) (n, phiN *big.Int, err error) {
 	return
 }
 
+// randomUnit returns a random value r where 0 < r < n
+// and gcd(r, n) = 1.
+func randomUnit(n *big.Int) (r *big.Int, err error) {
+
+	gcd := new(big.Int)
+
+	for gcd.Cmp(one) != 0 {
+		r, err = rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		gcd.GCD(nil, nil, r, n)
+	}
+
+	return r, nil
+}
+
 // getMu returns the modular inverse of phi mod n,
 // and is used to generate the value Mu for a
 // PrivateKey.
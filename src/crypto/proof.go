@@ -0,0 +1,339 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+var (
+	InvalidProofError = errors.New("Invalid zero-knowledge proof.")
+	InvalidSetError   = errors.New("Plaintext is not a member of the set of allowed values.")
+)
+
+// challengeBits is the size in bits of the challenges used in
+// the non-interactive zero-knowledge proofs.
+const challengeBits = 256
+
+var challengeModulus = new(big.Int).Lsh(one, challengeBits)
+
+// binarySet contains the allowed values of an encrypted vote.
+var binarySet = []*big.Int{big.NewInt(0), big.NewInt(1)}
+
+// MembershipProof is a non-interactive zero-knowledge proof
+// that a ciphertext encrypts one of a set of values, without
+// revealing which one. For each value s_i in the set there is
+// a commitment A_i, a challenge E_i and a response Z_i.
+type MembershipProof struct {
+	A []*big.Int
+	E []*big.Int
+	Z []*big.Int
+}
+
+// ProveBinary returns a proof that the ciphertext c, created
+// with the nonce r, is an encryption of either 0 or 1. The
+// value of m must be the plaintext of c. The context ctx is
+// bound to the proof, so that it cannot be reused elsewhere.
+func (key *PublicKey) ProveBinary(c, m, r *big.Int, ctx []byte) (proof *MembershipProof, err error) {
+	return key.ProveMembership(c, m, r, binarySet, ctx)
+}
+
+// VerifyBinary checks that proof shows that the ciphertext c is
+// an encryption of either 0 or 1 for the context ctx.
+func (key *PublicKey) VerifyBinary(c *big.Int, proof *MembershipProof, ctx []byte) (valid bool) {
+	return key.VerifyMembership(c, binarySet, proof, ctx)
+}
+
+// ProveMembership returns a proof that the ciphertext c, created
+// with the nonce r, is an encryption of one of the values in set.
+// The value of m must be the plaintext of c, and must be in set,
+// otherwise an InvalidSetError is returned.
+//
+// The proof is a disjunction of proofs that c.g^-s is an n-th
+// residue mod n^2 for each s in set. The branch for the real
+// plaintext is proven honestly while the others are simulated,
+// and the challenge is created using the Fiat-Shamir heuristic.
+func (key *PublicKey) ProveMembership(c, m, r *big.Int, set []*big.Int, ctx []byte) (proof *MembershipProof, err error) {
+
+	if c == nil || m == nil || r == nil {
+		return nil, InvalidProofError
+	}
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, s := range set {
+		if s.Cmp(m) == 0 {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, InvalidSetError
+	}
+
+	proof = &MembershipProof{
+		A: make([]*big.Int, len(set)),
+		E: make([]*big.Int, len(set)),
+		Z: make([]*big.Int, len(set)),
+	}
+
+	// simulate the proofs for all values other than m:
+	// a = z^n . u^-e mod n^2, for a random e and z
+	simulated := new(big.Int)
+	for i, s := range set {
+		if i == index {
+			continue
+		}
+		u, err := key.residue(c, s)
+		if err != nil {
+			return nil, err
+		}
+		if proof.E[i], err = rand.Int(rand.Reader, challengeModulus); err != nil {
+			return nil, err
+		}
+		if proof.Z[i], err = randomUnit(key.N); err != nil {
+			return nil, err
+		}
+		proof.A[i] = key.commitment(u, proof.E[i], proof.Z[i])
+		simulated.Add(simulated, proof.E[i])
+	}
+
+	// commit to the real proof: a = rho^n mod n^2
+	rho, err := randomUnit(key.N)
+	if err != nil {
+		return nil, err
+	}
+	proof.A[index] = new(big.Int).Exp(rho, key.N, key.NSquared)
+
+	// the real challenge is whatever is left over from the
+	// simulated challenges: e_m = e - sum(e_i) mod 2^t
+	e := key.challenge(c, set, proof.A, ctx)
+	proof.E[index] = new(big.Int).Sub(e, simulated)
+	proof.E[index].Mod(proof.E[index], challengeModulus)
+
+	// z = rho . r^e_m mod n
+	proof.Z[index] = new(big.Int).Exp(r, proof.E[index], key.N)
+	proof.Z[index].Mul(proof.Z[index], rho)
+	proof.Z[index].Mod(proof.Z[index], key.N)
+
+	return proof, nil
+}
+
+// VerifyMembership checks that proof shows that the ciphertext c
+// is an encryption of one of the values in set for the context ctx.
+func (key *PublicKey) VerifyMembership(c *big.Int, set []*big.Int, proof *MembershipProof, ctx []byte) (valid bool) {
+
+	if key.Validate() != nil || c == nil || proof == nil {
+		return false
+	}
+	if c.Sign() <= 0 || c.Cmp(key.NSquared) >= 0 {
+		return false
+	}
+	if len(proof.A) != len(set) || len(proof.E) != len(set) || len(proof.Z) != len(set) {
+		return false
+	}
+
+	sum := new(big.Int)
+	for i, s := range set {
+		a, e, z := proof.A[i], proof.E[i], proof.Z[i]
+		if a == nil || e == nil || z == nil {
+			return false
+		}
+		if a.Sign() <= 0 || a.Cmp(key.NSquared) >= 0 ||
+			e.Sign() < 0 || e.Cmp(challengeModulus) >= 0 ||
+			z.Sign() <= 0 || z.Cmp(key.N) >= 0 {
+			return false
+		}
+
+		u, err := key.residue(c, s)
+		if err != nil {
+			return false
+		}
+
+		// check that z^n = a . u^e mod n^2
+		lhs := new(big.Int).Exp(z, key.N, key.NSquared)
+		rhs := new(big.Int).Exp(u, e, key.NSquared)
+		rhs.Mul(rhs, a)
+		rhs.Mod(rhs, key.NSquared)
+		if lhs.Cmp(rhs) != 0 {
+			return false
+		}
+		sum.Add(sum, e)
+	}
+
+	// check that the challenges sum to the Fiat-Shamir challenge
+	sum.Mod(sum, challengeModulus)
+	return sum.Cmp(key.challenge(c, set, proof.A, ctx)) == 0
+}
+
+// ProveSumInRange returns a proof that the homomorphic sum of the
+// ciphertexts cs is an encryption of a value between min and max
+// inclusive. The values of ms and rs must be the plaintexts and
+// nonces used to create each of the ciphertexts in cs. If the sum
+// of ms is outside of the range, an InvalidSetError is returned.
+func (key *PublicKey) ProveSumInRange(cs, ms, rs []*big.Int, min, max int, ctx []byte) (proof *MembershipProof, err error) {
+
+	if len(cs) != len(ms) || len(cs) != len(rs) {
+		return nil, InvalidProofError
+	}
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+
+	// the sum of the ciphertexts is an encryption of the sum of
+	// the plaintexts with the product of the nonces:
+	// (g^m1.r1^n).(g^m2.r2^n) = g^(m1+m2).(r1.r2)^n
+	c := key.product(cs)
+	m := new(big.Int)
+	r := big.NewInt(1)
+	for i := range cs {
+		if ms[i] == nil || rs[i] == nil {
+			return nil, InvalidProofError
+		}
+		m.Add(m, ms[i])
+		r.Mul(r, rs[i])
+		r.Mod(r, key.N)
+	}
+
+	return key.ProveMembership(c, m, r, rangeSet(min, max), ctx)
+}
+
+// VerifySumInRange checks that proof shows that the homomorphic
+// sum of the ciphertexts cs is an encryption of a value between
+// min and max inclusive for the context ctx.
+func (key *PublicKey) VerifySumInRange(cs []*big.Int, min, max int, proof *MembershipProof, ctx []byte) (valid bool) {
+
+	if key.Validate() != nil {
+		return false
+	}
+	for _, c := range cs {
+		if c == nil {
+			return false
+		}
+	}
+	return key.VerifyMembership(key.product(cs), rangeSet(min, max), proof, ctx)
+}
+
+// product returns the product of the ciphertexts cs mod n^2.
+// Unlike AddCipherTexts, the result is not re-randomized, so
+// that any party can compute the same sum.
+func (key *PublicKey) product(cs []*big.Int) (total *big.Int) {
+
+	total = big.NewInt(1)
+	for _, c := range cs {
+		total.Mul(total, c)
+		total.Mod(total, key.NSquared)
+	}
+	return total
+}
+
+// rangeSet returns the set of values between min and max inclusive.
+func rangeSet(min, max int) (set []*big.Int) {
+	for i := min; i <= max; i++ {
+		set = append(set, big.NewInt(int64(i)))
+	}
+	return set
+}
+
+// residue returns the value u = c.g^-s mod n^2, which is an
+// n-th residue if and only if c is an encryption of s.
+func (key *PublicKey) residue(c, s *big.Int) (u *big.Int, err error) {
+
+	gs := new(big.Int).Exp(key.Generator, s, key.NSquared)
+	if gs.ModInverse(gs, key.NSquared) == nil {
+		return nil, InvalidPublicKeyError
+	}
+	u = new(big.Int).Mul(c, gs)
+	u.Mod(u, key.NSquared)
+	return u, nil
+}
+
+// commitment returns the value a = z^n . u^-e mod n^2, which is
+// used to simulate a proof for a given challenge e and response z.
+func (key *PublicKey) commitment(u, e, z *big.Int) (a *big.Int) {
+
+	ue := new(big.Int).Exp(u, e, key.NSquared)
+	if ue.ModInverse(ue, key.NSquared) == nil {
+		ue.SetInt64(0)
+	}
+	a = new(big.Int).Exp(z, key.N, key.NSquared)
+	a.Mul(a, ue)
+	a.Mod(a, key.NSquared)
+	return a
+}
+
+// challenge returns the Fiat-Shamir challenge for a proof, which
+// is a hash of the context, the public key, the ciphertext, the
+// set of allowed values and the commitments of the prover.
+func (key *PublicKey) challenge(c *big.Int, set, commitments []*big.Int, ctx []byte) (e *big.Int) {
+
+	var buf bytes.Buffer
+	WriteBytes(&buf, ctx)
+	WriteInts(&buf, key.N, key.Generator, c)
+	WriteInts(&buf, set...)
+	WriteInts(&buf, commitments...)
+
+	h := sha256.Sum256(buf.Bytes())
+	return new(big.Int).SetBytes(h[:])
+}
+
+// Bytes returns an encoding of the proof which can be stored
+// with a ballot and parsed with ParseMembershipProof.
+func (proof *MembershipProof) Bytes() []byte {
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(proof.A)))
+	WriteInts(&buf, proof.A...)
+	WriteInts(&buf, proof.E...)
+	WriteInts(&buf, proof.Z...)
+	return buf.Bytes()
+}
+
+// ParseMembershipProof decodes a proof created with Bytes. An
+// InvalidProofError is returned if the encoding is malformed.
+func ParseMembershipProof(data []byte) (proof *MembershipProof, err error) {
+
+	buf := bytes.NewReader(data)
+
+	var n uint32
+	if err = binary.Read(buf, binary.BigEndian, &n); err != nil {
+		return nil, InvalidProofError
+	}
+	// each value takes at least five bytes to encode
+	if uint64(n)*15 > uint64(buf.Len()) {
+		return nil, InvalidProofError
+	}
+
+	proof = new(MembershipProof)
+	if proof.A, err = readInts(buf, int(n)); err != nil {
+		return nil, err
+	}
+	if proof.E, err = readInts(buf, int(n)); err != nil {
+		return nil, err
+	}
+	if proof.Z, err = readInts(buf, int(n)); err != nil {
+		return nil, err
+	}
+	if buf.Len() != 0 {
+		return nil, InvalidProofError
+	}
+	return proof, nil
+}
+
+// readInts reads n values written by WriteInts from buf. Each
+// of the values of a proof must be set.
+func readInts(buf *bytes.Reader, n int) (values []*big.Int, err error) {
+
+	values = make([]*big.Int, n)
+	for i := range values {
+		if values[i], err = ReadInt(buf); err != nil || values[i] == nil {
+			return nil, InvalidProofError
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,157 @@
+package crypto_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestBinaryProofs(t *testing.T) {
+
+	var tests = []struct {
+		input *big.Int
+		valid bool
+	}{
+		{big.NewInt(0), true},
+		{big.NewInt(1), true},
+		{big.NewInt(2), false},
+		{big.NewInt(500), false},
+	}
+
+	priv, err := crypto.GenerateKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range tests {
+		got, err := CheckBinaryProof(&priv.PublicKey, c.input)
+		if got != c.valid {
+			t.Error("For input", c.input, "expected", c.valid, "got", got, "with error", err, "\n")
+		}
+	}
+
+	got, err := CheckForgedBinaryProof(&priv.PublicKey)
+	if got != true {
+		t.Error("Forged proof for an encryption of 2 was accepted", err, "\n")
+	}
+
+	got, err = CheckProofContext(&priv.PublicKey)
+	if got != true {
+		t.Error("Proof was accepted for a different context", err, "\n")
+	}
+}
+
+func CheckBinaryProof(key *crypto.PublicKey, input *big.Int) (success bool, err error) {
+
+	ctx := []byte("token")
+	c, r, err := key.EncryptWithNonce(input)
+	if err != nil {
+		return false, err
+	}
+
+	proof, err := key.ProveBinary(c, input, r, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// check the proof survives being encoded
+	parsed, err := crypto.ParseMembershipProof(proof.Bytes())
+	if err != nil {
+		return false, err
+	}
+
+	return key.VerifyBinary(c, parsed, ctx), nil
+}
+
+func CheckForgedBinaryProof(key *crypto.PublicKey) (success bool, err error) {
+
+	ctx := []byte("token")
+	c, r, err := key.EncryptWithNonce(big.NewInt(2))
+	if err != nil {
+		return false, err
+	}
+
+	// create an honest proof for {2, 1}, and try to pass
+	// it off as a proof for {0, 1}
+	set := []*big.Int{big.NewInt(2), big.NewInt(1)}
+	proof, err := key.ProveMembership(c, big.NewInt(2), r, set, ctx)
+	if err != nil {
+		return false, err
+	}
+	if !key.VerifyMembership(c, set, proof, ctx) {
+		return false, nil
+	}
+
+	return !key.VerifyBinary(c, proof, ctx), nil
+}
+
+func CheckProofContext(key *crypto.PublicKey) (success bool, err error) {
+
+	c, r, err := key.EncryptWithNonce(big.NewInt(1))
+	if err != nil {
+		return false, err
+	}
+	proof, err := key.ProveBinary(c, big.NewInt(1), r, []byte("token"))
+	if err != nil {
+		return false, err
+	}
+
+	return !key.VerifyBinary(c, proof, []byte("other token")), nil
+}
+
+func TestSumInRangeProofs(t *testing.T) {
+
+	var tests = []struct {
+		votes []int64
+		min   int
+		max   int
+		valid bool
+	}{
+		{[]int64{0, 0, 1, 0}, 1, 1, true},
+		{[]int64{0, 0, 0, 0}, 1, 1, false},
+		{[]int64{1, 0, 1, 0}, 1, 1, false},
+		{[]int64{1, 0, 1, 0}, 0, 2, true},
+		{[]int64{1, 1, 1, 0}, 0, 2, false},
+		{[]int64{0, 0, 0, 0}, 0, 4, true},
+	}
+
+	priv, err := crypto.GenerateKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range tests {
+		got, err := CheckSumInRangeProof(&priv.PublicKey, c.votes, c.min, c.max)
+		if got != c.valid {
+			t.Error("For votes", c.votes, "in range", c.min, "to", c.max, "expected", c.valid, "got", got, "with error", err, "\n")
+		}
+	}
+}
+
+func CheckSumInRangeProof(key *crypto.PublicKey, votes []int64, min, max int) (success bool, err error) {
+
+	ctx := []byte("token")
+	cs := make([]*big.Int, len(votes))
+	ms := make([]*big.Int, len(votes))
+	rs := make([]*big.Int, len(votes))
+
+	for i, v := range votes {
+		ms[i] = big.NewInt(v)
+		cs[i], rs[i], err = key.EncryptWithNonce(ms[i])
+		if err != nil {
+			return false, err
+		}
+	}
+
+	proof, err := key.ProveSumInRange(cs, ms, rs, min, max, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// the proof should not hold if a ciphertext is left out
+	if key.VerifySumInRange(cs[1:], min, max, proof, ctx) {
+		return false, nil
+	}
+
+	return key.VerifySumInRange(cs, min, max, proof, ctx), nil
+}
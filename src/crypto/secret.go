@@ -77,19 +77,10 @@ func DivideSecret(secret *big.Int, k, m int) (shares []Share, prime *big.Int, er
 	// generate a prime P > s
 	prime, _ = rand.Prime(rand.Reader, 1+secret.BitLen())
 
-	// We need k total parts for reconstruction, so
-	// we will use s as the first monomial, and k-1 extra parts.
-	poly := polynomial{
-		monomials: []monomial{{secret, new(big.Int)}},
-	}
-
-	for i := int64(1); i < int64(k); i++ {
-		// select ai where a < P, s < P
-		value, err := rand.Int(rand.Reader, prime)
-		if err != nil {
-			return nil, nil, err
-		}
-		poly.monomials = append(poly.monomials, monomial{value, big.NewInt(i)})
+	// select ai where a < P, s < P
+	poly, err := randomPolynomial(secret, k, prime)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Using the polynomial, construct n shares
@@ -103,3 +94,46 @@ func DivideSecret(secret *big.Int, k, m int) (shares []Share, prime *big.Int, er
 	// Return the set of shares.
 	return
 }
+
+// divideIntegerSecret creates a k-threshold secret and returns
+// a slice of m shares, in the same way as DivideSecret. The
+// shares are not reduced by any modulus, so that they can be
+// used as exponents in a group of unknown order. The random
+// coefficients of the polynomial are chosen in the range
+// [0, bound), which should be much larger than the secret so
+// that the shares do not reveal it.
+func divideIntegerSecret(secret *big.Int, k, m int, bound *big.Int) (shares []Share, err error) {
+
+	poly, err := randomPolynomial(secret, k, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := int64(1); i <= int64(m); i++ {
+		shares = append(shares, Share{big.NewInt(i), poly.solve(big.NewInt(i))})
+	}
+
+	return shares, nil
+}
+
+// randomPolynomial returns a polynomial of degree k-1 where
+// f(0) = secret, and the remaining k-1 coefficients are chosen
+// at random in the range [0, bound).
+func randomPolynomial(secret *big.Int, k int, bound *big.Int) (poly polynomial, err error) {
+
+	// We need k total parts for reconstruction, so
+	// we will use s as the first monomial, and k-1 extra parts.
+	poly = polynomial{
+		monomials: []monomial{{secret, new(big.Int)}},
+	}
+
+	for i := int64(1); i < int64(k); i++ {
+		value, err := rand.Int(rand.Reader, bound)
+		if err != nil {
+			return poly, err
+		}
+		poly.monomials = append(poly.monomials, monomial{value, big.NewInt(i)})
+	}
+
+	return poly, nil
+}
@@ -56,5 +56,8 @@ func SignHash(privateKey *dsa.PrivateKey, hash *[32]byte) (sig *Signature) {
 // public key and the hash value that was signed.
 func Verify(pubkey *dsa.PublicKey, hash *[32]byte, sig *Signature) (valid bool) {
 
+	if sig.R == nil || sig.S == nil {
+		return false
+	}
 	return dsa.Verify(pubkey, hash[:], sig.R, sig.S)
 }
@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	InvalidShareError               = errors.New("Invalid share of the decryption key.")
+	InvalidPartialDecryptionError   = errors.New("Invalid partial decryption was supplied.")
+	DuplicatePartialDecryptionError = errors.New("Duplicate partial decryptions were supplied.")
+)
+
+// statisticalBits is the number of extra random bits used to
+// hide a secret when it is shared over the integers.
+const statisticalBits = 128
+
+// PartialDecryption is the result of decrypting a ciphertext
+// using a single share of the decryption key. The value X is
+// the X value of the share that was used. The values E and Z are
+// a proof that the correct share was used, which can be checked
+// with VerifyPartialDecryption.
+type PartialDecryption struct {
+	X     *big.Int
+	Value *big.Int
+	E     *big.Int
+	Z     *big.Int
+}
+
+// DivideKey divides the decryption exponent of the PrivateKey
+// key into m shares, any k of which can be used together to
+// decrypt a ciphertext with PartialDecrypt and
+// CombinePartialDecryptions. Unlike dividing Lambda and Mu
+// with DivideSecret, the private key is never reconstructed
+// from these shares.
+//
+// The decryption exponent is d = Lambda.Mu, for which
+// d = 0 mod Lambda and d = 1 mod N. It is shared over the
+// integers as f(0) = delta.d, where delta = m!.
+func (key *PrivateKey) DivideKey(k, m int) (shares []Share, err error) {
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	if k < 1 || m < k {
+		return nil, InvalidShareError
+	}
+
+	delta := factorial(m)
+	secret := new(big.Int).Mul(key.Lambda, key.Mu)
+	secret.Mul(secret, delta)
+
+	bound := new(big.Int).Lsh(one, uint(secret.BitLen()+statisticalBits))
+	return divideIntegerSecret(secret, k, m, bound)
+}
+
+// PartialDecrypt returns the partial decryption c^s mod n^2 of
+// the ciphertext c, where s is the share of the decryption key,
+// along with a proof that it was created with that share.
+func (key *PublicKey) PartialDecrypt(share Share, c *big.Int) (part *PartialDecryption, err error) {
+
+	if c == nil {
+		return nil, InvalidCiphertextError
+	}
+	if share.X == nil || share.Y == nil {
+		return nil, InvalidShareError
+	}
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+
+	// shares created by a KeyGenerator may be negative
+	base, exp := c, share.Y
+	if exp.Sign() < 0 {
+		base = new(big.Int).ModInverse(c, key.NSquared)
+		if base == nil {
+			return nil, InvalidCiphertextError
+		}
+		exp = new(big.Int).Neg(exp)
+	}
+
+	part = &PartialDecryption{
+		X:     new(big.Int).Set(share.X),
+		Value: new(big.Int).Exp(base, exp, key.NSquared),
+	}
+	if err = key.proveExponent(c, share.Y, part); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// CombinePartialDecryptions returns the message m which is the
+// decryption of the ciphertext that each of the partial
+// decryptions in parts was created from. The value of parties
+// is the total number of shares that the key was divided into.
+// If fewer partial decryptions than the threshold of the key
+// are used, the value of m will not be correct.
+func (key *PublicKey) CombinePartialDecryptions(parts []PartialDecryption, parties int) (m *big.Int, err error) {
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 || parties < 1 {
+		return nil, InvalidPartialDecryptionError
+	}
+
+	points := make([]Share, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for i, p := range parts {
+		if p.X == nil || p.Value == nil || p.X.Sign() <= 0 || p.X.Cmp(big.NewInt(int64(parties))) > 0 {
+			return nil, InvalidPartialDecryptionError
+		}
+		if seen[p.X.String()] {
+			return nil, DuplicatePartialDecryptionError
+		}
+		seen[p.X.String()] = true
+		points[i] = Share{X: p.X, Y: p.Value}
+	}
+
+	delta := factorial(parties)
+
+	// combine the squares of the partial decryptions in the exponent:
+	// c' = product of ci^(2.delta.lambda_i) = c^(2.delta^2.d)
+	// squaring removes any factor of order 2 which would not be
+	// detected by the proof of a partial decryption
+	combined := big.NewInt(1)
+	for _, p := range points {
+		coeff := integerCoefficient(p, points, delta)
+		if coeff == nil {
+			return nil, InvalidPartialDecryptionError
+		}
+		base := new(big.Int).Exp(p.Y, two, key.NSquared)
+		if coeff.Sign() < 0 {
+			if base.ModInverse(base, key.NSquared) == nil {
+				return nil, InvalidPartialDecryptionError
+			}
+			coeff = new(big.Int).Neg(coeff)
+		}
+		combined.Mul(combined, new(big.Int).Exp(base, coeff, key.NSquared))
+		combined.Mod(combined, key.NSquared)
+	}
+
+	// since d = 0 mod Lambda and d = 1 mod N,
+	// c' = 1 + m.2.delta^2.N mod n^2, so
+	// m = L(c').(2.delta^2)^-1 mod n
+	scale := new(big.Int).Mul(delta, delta)
+	scale.Mul(scale, two)
+	if scale.ModInverse(scale, key.N) == nil {
+		return nil, InvalidPublicKeyError
+	}
+	m = getL(combined, key.N)
+	m.Mul(m, scale)
+	m.Mod(m, key.N)
+
+	return m, nil
+}
+
+// factorial returns the value n!.
+func factorial(n int) (f *big.Int) {
+	return new(big.Int).MulRange(1, int64(n))
+}
@@ -0,0 +1,81 @@
+package crypto_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestThresholdDecryption(t *testing.T) {
+
+	var tests = []struct {
+		threshold     int
+		shares        int
+		collaborators int
+		decrypts      bool
+	}{
+		{1, 1, 1, true},
+		{2, 3, 2, true},
+		{2, 3, 3, true},
+		{3, 5, 2, false},
+		{3, 5, 3, true},
+		{4, 10, 7, true},
+		{5, 10, 4, false},
+	}
+
+	priv, err := crypto.GenerateKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range tests {
+		success, err := CheckThresholdDecryption(priv, c.threshold, c.shares, c.collaborators)
+		if success != c.decrypts {
+			t.Error("Test no:", i, "For input", c.collaborators, "collaborators with pool of",
+				c.shares, "shares and threshold of", c.threshold, "expected", c.decrypts, "got", success, "with error", err, "\n")
+		}
+	}
+
+	_, err = priv.DivideKey(3, 2)
+	if err != crypto.InvalidShareError {
+		t.Error("Expected", crypto.InvalidShareError, "for threshold larger than shares, got", err, "\n")
+	}
+}
+
+func CheckThresholdDecryption(priv *crypto.PrivateKey, threshold, shares, collaborators int) (success bool, err error) {
+
+	keyShares, err := priv.DivideKey(threshold, shares)
+	if err != nil {
+		return false, err
+	}
+
+	// decrypt a homomorphic sum, as is done during a tally
+	var ciphertexts []*big.Int
+	for _, v := range []int64{1, 0, 1, 1} {
+		c, err := priv.PublicKey.Encrypt(big.NewInt(v))
+		if err != nil {
+			return false, err
+		}
+		ciphertexts = append(ciphertexts, c)
+	}
+	sum, err := priv.PublicKey.AddCipherTexts(ciphertexts...)
+	if err != nil {
+		return false, err
+	}
+
+	var parts []crypto.PartialDecryption
+	for _, s := range randomSlice(collaborators, keyShares) {
+		part, err := priv.PublicKey.PartialDecrypt(s, sum)
+		if err != nil {
+			return false, err
+		}
+		parts = append(parts, *part)
+	}
+
+	m, err := priv.PublicKey.CombinePartialDecryptions(parts, shares)
+	if err != nil {
+		return false, err
+	}
+
+	return m.Cmp(big.NewInt(3)) == 0, nil
+}
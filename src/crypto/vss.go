@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+// commitmentGroupBits is the minimum size in bits of the prime
+// modulus of the group used for commitments to a polynomial.
+const commitmentGroupBits = 2048
+
+// Commitments contains public commitments to each of the
+// coefficients of the polynomial used to divide a secret,
+// which allow the holder of a share to verify it without
+// learning anything about the other shares. The commitments
+// are made in the subgroup of order Prime of the integers
+// mod P, which is generated by G.
+type Commitments struct {
+	Prime  *big.Int   // prime modulus used to create the shares
+	P      *big.Int   // prime modulus of the commitment group
+	G      *big.Int   // generator of the subgroup of order Prime
+	Values []*big.Int // G^a mod P for each coefficient a
+}
+
+// DivideSecretVerifiable creates a k-threshold secret and returns
+// a slice of m shares along with the prime modulus, in the same way
+// as DivideSecret. Commitments to the polynomial are also returned,
+// which should be made public so that each share can be checked
+// with VerifyShare. This is Feldman's verifiable secret sharing
+// scheme.
+func DivideSecretVerifiable(secret *big.Int, k, m int) (shares []Share, prime *big.Int, commitments *Commitments, err error) {
+
+	// generate a prime P > s
+	prime, err = rand.Prime(rand.Reader, 1+secret.BitLen())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	poly, err := randomPolynomial(secret, k, prime)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	p, g, err := commitmentGroup(prime)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	commitments = &Commitments{
+		Prime:  prime,
+		P:      p,
+		G:      g,
+		Values: make([]*big.Int, len(poly.monomials)),
+	}
+	for i, mono := range poly.monomials {
+		commitments.Values[i] = new(big.Int).Exp(g, mono.Value, p)
+	}
+
+	for i := int64(1); i <= int64(m); i++ {
+		yVal := poly.solve(big.NewInt(i))
+		shares = append(shares, Share{big.NewInt(i), new(big.Int).Mod(yVal, prime)})
+	}
+
+	return shares, prime, commitments, nil
+}
+
+// VerifyShare checks that the Share s is a point on the polynomial
+// that the commitments were created from, which is true when
+// G^y = the product from i = 0 to k-1 of Ci^(x^i) mod P.
+func VerifyShare(s Share, commitments *Commitments) (valid bool) {
+
+	if commitments == nil || commitments.Prime == nil || commitments.P == nil ||
+		commitments.G == nil || len(commitments.Values) == 0 {
+		return false
+	}
+	if s.X == nil || s.Y == nil || s.X.Sign() <= 0 ||
+		s.Y.Sign() < 0 || s.Y.Cmp(commitments.Prime) >= 0 {
+		return false
+	}
+
+	p := commitments.P
+	lhs := new(big.Int).Exp(commitments.G, s.Y, p)
+
+	rhs := big.NewInt(1)
+	for i, c := range commitments.Values {
+		if c == nil {
+			return false
+		}
+		// exponents can be reduced mod Prime, the order of G
+		e := new(big.Int).Exp(s.X, big.NewInt(int64(i)), commitments.Prime)
+		rhs.Mul(rhs, new(big.Int).Exp(c, e, p))
+		rhs.Mod(rhs, p)
+	}
+
+	return lhs.Cmp(rhs) == 0
+}
+
+// commitmentGroup returns a prime p = q.t + 1, along with a
+// generator g of the subgroup of the integers mod p of order q.
+// The prime q must be odd.
+func commitmentGroup(q *big.Int) (p, g *big.Int, err error) {
+
+	tBits := commitmentGroupBits - q.BitLen()
+	if tBits < 64 {
+		tBits = 64
+	}
+	bound := new(big.Int).Lsh(one, uint(tBits))
+
+	p = new(big.Int)
+	for {
+		t, err := rand.Int(rand.Reader, bound)
+		if err != nil {
+			return nil, nil, err
+		}
+		// q is odd, so t must be even for p to be odd
+		t.SetBit(t, 0, 0)
+		t.SetBit(t, tBits-1, 1)
+
+		p.Mul(q, t)
+		p.Add(p, one)
+		if !p.ProbablyPrime(20) {
+			continue
+		}
+
+		// g = h^t mod p has order q unless it is 1
+		for {
+			h, err := rand.Int(rand.Reader, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			g = new(big.Int).Exp(h, t, p)
+			if g.Cmp(one) > 0 {
+				return p, g, nil
+			}
+		}
+	}
+}
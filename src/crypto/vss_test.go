@@ -0,0 +1,97 @@
+package crypto_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestVerifiableSecretSharing(t *testing.T) {
+
+	var tests = []struct {
+		threshold int
+		shares    int
+	}{
+		{1, 1},
+		{2, 3},
+		{4, 10},
+	}
+
+	for _, c := range tests {
+		success, err := CheckVerifiableSecretSharing(c.threshold, c.shares)
+		if !success {
+			t.Error("For threshold", c.threshold, "and", c.shares, "shares, expected shares to verify and interpolate",
+				"with error", err, "\n")
+		}
+
+		success, err = CheckForgedShares(c.threshold, c.shares)
+		if !success {
+			t.Error("For threshold", c.threshold, "and", c.shares, "shares, forged shares were accepted",
+				"with error", err, "\n")
+		}
+	}
+}
+
+func CheckVerifiableSecretSharing(threshold, shares int) (success bool, err error) {
+
+	priv, err := crypto.GenerateKeyPair(64)
+	if err != nil {
+		return false, err
+	}
+
+	secrets, prime, commitments, err := crypto.DivideSecretVerifiable(priv.Lambda, threshold, shares)
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range secrets {
+		if !crypto.VerifyShare(s, commitments) {
+			return false, nil
+		}
+	}
+
+	secret, err := crypto.Interpolate(secrets[:threshold], prime)
+	if err != nil {
+		return false, err
+	}
+
+	return secret.Cmp(priv.Lambda) == 0, nil
+}
+
+func CheckForgedShares(threshold, shares int) (success bool, err error) {
+
+	priv, err := crypto.GenerateKeyPair(64)
+	if err != nil {
+		return false, err
+	}
+
+	secrets, prime, commitments, err := crypto.DivideSecretVerifiable(priv.Lambda, threshold, shares)
+	if err != nil {
+		return false, err
+	}
+
+	forged := []crypto.Share{
+		{X: secrets[0].X, Y: new(big.Int).Mod(new(big.Int).Add(secrets[0].Y, big.NewInt(1)), prime)},
+		{X: secrets[0].X, Y: new(big.Int).Add(secrets[0].Y, prime)},
+		{X: secrets[0].X, Y: nil},
+	}
+
+	// a constant polynomial has the same value at every point
+	if threshold > 1 {
+		forged = append(forged, crypto.Share{X: new(big.Int).Add(secrets[0].X, big.NewInt(1000)), Y: secrets[0].Y})
+	}
+
+	for _, s := range forged {
+		if crypto.VerifyShare(s, commitments) {
+			return false, nil
+		}
+	}
+
+	// a share should not verify against another dealing
+	_, _, other, err := crypto.DivideSecretVerifiable(priv.Mu, threshold, shares)
+	if err != nil {
+		return false, err
+	}
+
+	return !crypto.VerifyShare(secrets[0], other), nil
+}
@@ -10,6 +10,12 @@ import (
 
 var (
 	InvalidFormatError = errors.New("Invalid format was supplied; bad number of selections.")
+	InvalidVoteError   = errors.New("Invalid vote was supplied; selections must be 0 or 1.")
+	InvalidCountError  = errors.New("Invalid ballot was supplied; too few or too many selections were made.")
+
+	InsufficientPartialsError = errors.New("Not enough partial tallies of the current ballots to calculate the tally.")
+	InvalidPartialTallyError  = errors.New("Partial tally is not a correct partial decryption of the ballots.")
+	InvalidTallyError         = errors.New("Tally is not the correct decryption of the ballots.")
 )
 
 // Ballot contains the structure of a ballot which a given
@@ -19,6 +25,7 @@ type Ballot struct {
 	VoteToken     string      // VT of the voter who owns ballot
 	NumSelections int         // number of selections in the ballot
 	Selections    []Selection // list of selections on the ballot
+	Proof         []byte      // proof that the number of selections made is allowed
 }
 
 // Selection contains details on particular selection by
@@ -31,12 +38,40 @@ type Selection struct {
 
 // Format defines the format of a ballot, and should be used
 // to ensure that ballots follow the format defined for a
-// vote.
+// vote. MinSelections and MaxSelections limit how many of
+// the selections a voter may vote for. If MaxSelections is
+// zero, there is no upper limit.
 type Format struct {
 	NumSelections int
+	MinSelections int
+	MaxSelections int
 	Selections    []Selection
 }
 
+// SelectionRange returns the minimum and maximum number of
+// selections which a voter may vote for on a ballot. If
+// MaxSelections is zero, a voter may vote for every selection.
+func (f *Format) SelectionRange() (min, max int) {
+	min, max = f.MinSelections, f.MaxSelections
+	if max == 0 {
+		max = f.NumSelections
+	}
+	return min, max
+}
+
+// Validate returns an InvalidFormatError if the Format f does not
+// have NumSelections selections, or if no number of selections is
+// allowed by its limits.
+func (f *Format) Validate() (err error) {
+	if f.NumSelections < 0 || len(f.Selections) != f.NumSelections {
+		return InvalidFormatError
+	}
+	if min, max := f.SelectionRange(); min < 0 || min > max || max > f.NumSelections {
+		return InvalidFormatError
+	}
+	return nil
+}
+
 // Fill uses the defined Format f and the VoteToken vt and
 // takes a user through the prompts to fill out a ballot.
 // The ballot is returned in the value of b.
@@ -67,9 +102,116 @@ func (b *Ballot) Fill(f Format, vt string) (err error) {
 
 	// TODO: let user review inputs before returning
 
+	votes := 0
+	for _, s := range b.Selections {
+		votes += int(s.Vote.Int64())
+	}
+	if min, max := f.SelectionRange(); votes < min || votes > max {
+		fmt.Printf("You must vote for between %v and %v selections\n", min, max)
+		return InvalidCountError
+	}
+
+	return nil
+}
+
+// Encrypt will encrypt each of the selections in the ballot
+// with the public election key. Each encrypted selection is
+// given a zero-knowledge proof that its vote is either 0 or 1,
+// and the ballot is given a proof that the number of votes
+// is allowed by the Format f. The proofs are bound to the
+// VoteToken of the ballot. If any vote is not 0 or 1, an
+// InvalidVoteError is returned, and if too few or too many
+// votes were made, an InvalidCountError is returned.
+func (b *Ballot) Encrypt(f Format, key *crypto.PublicKey) (err error) {
+
+	if len(b.Selections) != b.NumSelections {
+		return InvalidFormatError
+	}
+
+	votes := make([]*big.Int, len(b.Selections))
+	nonces := make([]*big.Int, len(b.Selections))
+	encrypted := make([]*big.Int, len(b.Selections))
+
+	for i, s := range b.Selections {
+		c, r, err := key.EncryptWithNonce(s.Vote)
+		if err != nil {
+			return err
+		}
+
+		proof, err := key.ProveBinary(c, s.Vote, r, b.selectionContext(i))
+		if err == crypto.InvalidSetError {
+			return InvalidVoteError
+		} else if err != nil {
+			return err
+		}
+
+		votes[i], nonces[i], encrypted[i] = s.Vote, r, c
+		b.Selections[i].Proof = proof.Bytes()
+	}
+
+	min, max := f.SelectionRange()
+	proof, err := key.ProveSumInRange(encrypted, votes, nonces, min, max, b.ballotContext())
+	if err == crypto.InvalidSetError {
+		return InvalidCountError
+	} else if err != nil {
+		return err
+	}
+	b.Proof = proof.Bytes()
+
+	for i := range b.Selections {
+		b.Selections[i].Vote = encrypted[i]
+	}
+
 	return nil
 }
 
+// VerifyProofs checks that every selection in an encrypted
+// ballot carries a valid proof that its vote is either 0 or 1,
+// and that the ballot carries a valid proof that the number of
+// votes made is allowed by the Format f.
+func (b *Ballot) VerifyProofs(f Format, key *crypto.PublicKey) (valid bool) {
+
+	if b.NumSelections != f.NumSelections || len(b.Selections) != f.NumSelections {
+		return false
+	}
+
+	encrypted := make([]*big.Int, len(b.Selections))
+
+	for i, s := range b.Selections {
+		if s.Name != f.Selections[i].Name {
+			return false
+		}
+		proof, err := crypto.ParseMembershipProof(s.Proof)
+		if err != nil {
+			return false
+		}
+		if !key.VerifyBinary(s.Vote, proof, b.selectionContext(i)) {
+			return false
+		}
+		encrypted[i] = s.Vote
+	}
+
+	proof, err := crypto.ParseMembershipProof(b.Proof)
+	if err != nil {
+		return false
+	}
+	min, max := f.SelectionRange()
+	return key.VerifySumInRange(encrypted, min, max, proof, b.ballotContext())
+}
+
+// ballotContext returns the context which the proof of the
+// number of votes on a ballot is bound to.
+func (b *Ballot) ballotContext() []byte {
+	return []byte(fmt.Sprintf("%v/sum", b.VoteToken))
+}
+
+// selectionContext returns the context which the proof of the
+// i-th selection is bound to, so that a proof cannot be copied
+// to another ballot or selection.
+func (b *Ballot) selectionContext(i int) []byte {
+	return []byte(fmt.Sprintf("%v/%v/%v", b.VoteToken, i, b.Selections[i].Name))
+}
+
 // CreateFormat allows for a defined Format to be created
 // for an election, and takes a user through defining the
 // selections available on a ballot.
@@ -83,6 +225,15 @@ func CreateFormat() (f *Format) {
 		Selections:    make([]Selection, input),
 	}
 
+	fmt.Printf("Minimum number of selections a voter must vote for? ")
+	fmt.Scanf("%v\n", &f.MinSelections)
+
+	fmt.Printf("Maximum number of selections a voter may vote for? (0 for any) ")
+	fmt.Scanf("%v\n", &f.MaxSelections)
+	if f.MaxSelections == 0 {
+		f.MaxSelections = input
+	}
+
 	fmt.Println("Use double quotes for description entries")
 	for i := 0; i < input; i++ {
 		fmt.Printf("Enter user description for selection %v: ", i+1)
@@ -104,9 +255,20 @@ func CreateFormat() (f *Format) {
 }
 
 // Tally represents a map of selection names to their
-// total counts.
+// total counts. It also contains the encrypted sums which were
+// decrypted, along with a proof that each total is the correct
+// decryption of its sum, so that it can be checked by anyone
+// with VerifyTally.
 type Tally struct {
 	Totals map[string]*big.Int
+	Sums   map[string]*big.Int // encrypted sum of each selection
+
+	// When the tally is decrypted with the private key, Proofs
+	// contains the random value of each sum. In threshold
+	// decryption mode, the partial tallies that were combined
+	// are kept instead.
+	Proofs   map[string]*big.Int
+	Partials []PartialTally
 }
 
 // String representation of a Tally.
@@ -119,39 +281,289 @@ func (t Tally) String() (str string) {
 
 // Tally creates a Tally of the Ballots in bs, according
 // to the Format in f. The final results are decrypted using
-// the PrivateKey provided.
+// the PrivateKey provided, and a proof of each decryption is
+// included in the Tally.
 func (f *Format) Tally(bs *[]Ballot, key *crypto.PrivateKey) (t *Tally, err error) {
 
+	sums, err := f.Sum(bs, &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
 	t = &Tally{
 		Totals: make(map[string]*big.Int, 0),
+		Sums:   sums,
+		Proofs: make(map[string]*big.Int, 0),
+	}
+
+	for name, sum := range sums {
+		result, err := key.Decrypt(sum)
+		if err != nil {
+			return t, err
+		}
+		proof, err := key.ProveDecryption(sum, result)
+		if err != nil {
+			return t, err
+		}
+		t.Totals[name] = result
+		t.Proofs[name] = proof
 	}
 
-	selectionCounts := make(map[string][]*big.Int, 0)
+	return t, nil
+}
+
+// selectionCounts gathers the encrypted votes of each
+// selection in the Ballots in bs, according to the Format f.
+func (f *Format) selectionCounts(bs *[]Ballot) (selectionCounts map[string][]*big.Int) {
+
+	selectionCounts = make(map[string][]*big.Int, 0)
 
 	for _, s := range f.Selections {
-		selectionCounts[s.Name] = make([]*big.Int, len(*bs))
+		selectionCounts[s.Name] = make([]*big.Int, 0, len(*bs))
 	}
 
-	for i, b := range *bs {
+	for _, b := range *bs {
 		for _, s := range b.Selections {
 			if _, ok := selectionCounts[s.Name]; ok {
-				selectionCounts[s.Name][i] = s.Vote
+				selectionCounts[s.Name] = append(selectionCounts[s.Name], s.Vote)
 			}
 		}
 	}
 
-	// TODO: decrypt each sub tally
-	for name, count := range selectionCounts {
-		sum, err := key.AddCipherTexts(count...)
+	return selectionCounts
+}
+
+// Sum returns the homomorphic sum of the encrypted votes
+// for each selection in the Ballots in bs. Any node which
+// sums the same ballots will get the same encrypted sums.
+func (f *Format) Sum(bs *[]Ballot, key *crypto.PublicKey) (sums map[string]*big.Int, err error) {
+
+	sums = make(map[string]*big.Int, 0)
+
+	for name, count := range f.selectionCounts(bs) {
+		sums[name], err = key.SumCipherTexts(count...)
 		if err != nil {
-			return t, err
+			return nil, err
 		}
-		result, err := key.Decrypt(sum)
+	}
+
+	return sums, nil
+}
+
+// PartialTally contains the encrypted sums of the votes for
+// each selection, along with the partial decryption of each
+// sum created with a single share of the election key.
+type PartialTally struct {
+	X        *big.Int                            // X value of the share used
+	Sums     map[string]*big.Int                 // encrypted sum of each selection
+	Partials map[string]crypto.PartialDecryption // partial decryption of each sum
+}
+
+// PartialTally creates a PartialTally of the Ballots in bs,
+// according to the Format in f, using a share of the election
+// key created with crypto.DivideKey. Only the sums of the votes
+// are partially decrypted, never an individual ballot.
+func (f *Format) PartialTally(bs *[]Ballot, key *crypto.PublicKey, share crypto.Share) (pt *PartialTally, err error) {
+
+	sums, err := f.Sum(bs, key)
+	if err != nil {
+		return nil, err
+	}
+
+	pt = &PartialTally{
+		X:        share.X,
+		Sums:     sums,
+		Partials: make(map[string]crypto.PartialDecryption, 0),
+	}
+
+	for name, sum := range sums {
+		part, err := key.PartialDecrypt(share, sum)
 		if err != nil {
-			return t, err
+			return nil, err
+		}
+		pt.Partials[name] = *part
+	}
+
+	return pt, nil
+}
+
+// VerifyPartialTally checks that the PartialTally pt is a partial
+// decryption of the homomorphic sum of the votes in the Ballots in
+// bs, with a valid proof for the verification key of its share in
+// vks. An InvalidPartialTallyError is returned if it is not.
+func (f *Format) VerifyPartialTally(bs *[]Ballot, key *crypto.PublicKey, pt *PartialTally, vks []*big.Int,
+	parties int) (err error) {
+
+	sums, err := f.Sum(bs, key)
+	if err != nil {
+		return err
+	}
+	if pt == nil || !validPartialTally(sums, key, *pt, vks, parties) {
+		return InvalidPartialTallyError
+	}
+	return nil
+}
+
+// ThresholdTally creates a Tally of the Ballots in bs, according
+// to the Format in f, by combining the PartialTallies in pts. Only
+// the partial tallies of the same encrypted sums as the Ballots in
+// bs, with valid proofs for the verification keys in vks, are used,
+// and only the first of those for each share. The verification key
+// of the share with X value i is vks[i-1].
+// If fewer than threshold partial tallies can be used, an
+// InsufficientPartialsError is returned. The value of parties is
+// the total number of shares the election key was divided into.
+func (f *Format) ThresholdTally(bs *[]Ballot, key *crypto.PublicKey, pts []PartialTally, vks []*big.Int,
+	threshold, parties int) (t *Tally, err error) {
+
+	sums, err := f.Sum(bs, key)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make([]PartialTally, 0, len(pts))
+	for _, pt := range pts {
+		if validPartialTally(sums, key, pt, vks, parties) {
+			used = append(used, pt)
+		}
+	}
+	used = distinctPartialTallies(used)
+
+	t, err = combinePartialTallies(sums, key, used, threshold, parties)
+	if err != nil {
+		return nil, err
+	}
+	t.Partials = used
+	return t, nil
+}
+
+// VerifyTally checks that each total in the Tally t is the correct
+// decryption of the homomorphic sum of the votes in the Ballots in
+// bs, using only the public election key. If the tally was created
+// with threshold decryption, the verification keys vks of each
+// share, the threshold and the number of parties are also used.
+// The encrypted sums published with the tally must also be the
+// sums of the ballots. An InvalidTallyError is returned if it is
+// not correct.
+func (f *Format) VerifyTally(bs *[]Ballot, key *crypto.PublicKey, t *Tally, vks []*big.Int,
+	threshold, parties int) (err error) {
+
+	sums, err := f.Sum(bs, key)
+	if err != nil {
+		return err
+	}
+	if t == nil || len(t.Totals) != len(sums) || len(t.Sums) != len(sums) {
+		return InvalidTallyError
+	}
+	for name, sum := range sums {
+		if other, ok := t.Sums[name]; !ok || other == nil || other.Cmp(sum) != 0 {
+			return InvalidTallyError
+		}
+	}
+
+	if len(t.Partials) == 0 {
+		for name, sum := range sums {
+			if !key.VerifyDecryption(sum, t.Totals[name], t.Proofs[name]) {
+				return InvalidTallyError
+			}
+		}
+		return nil
+	}
+
+	for _, pt := range t.Partials {
+		if !validPartialTally(sums, key, pt, vks, parties) {
+			return InvalidTallyError
+		}
+	}
+	combined, err := combinePartialTallies(sums, key, distinctPartialTallies(t.Partials), threshold, parties)
+	if err != nil {
+		return InvalidTallyError
+	}
+	for name, total := range combined.Totals {
+		if other, ok := t.Totals[name]; !ok || other == nil || other.Cmp(total) != 0 {
+			return InvalidTallyError
+		}
+	}
+	return nil
+}
+
+// distinctPartialTallies returns the first of the PartialTallies in
+// pts for each share, so that a partial tally which was received or
+// published twice is only counted once.
+func distinctPartialTallies(pts []PartialTally) (distinct []PartialTally) {
+
+	seen := make(map[string]bool, len(pts))
+	distinct = make([]PartialTally, 0, len(pts))
+	for _, pt := range pts {
+		x := pt.X.String()
+		if seen[x] {
+			continue
+		}
+		seen[x] = true
+		distinct = append(distinct, pt)
+	}
+	return distinct
+}
+
+// combinePartialTallies returns a Tally of the encrypted sums in
+// sums by combining the partial decryptions of each sum in pts.
+func combinePartialTallies(sums map[string]*big.Int, key *crypto.PublicKey, pts []PartialTally,
+	threshold, parties int) (t *Tally, err error) {
+
+	if len(pts) < threshold {
+		return nil, InsufficientPartialsError
+	}
+
+	parts := make(map[string][]crypto.PartialDecryption, 0)
+	for _, pt := range pts {
+		for name := range sums {
+			parts[name] = append(parts[name], pt.Partials[name])
+		}
+	}
+
+	t = &Tally{
+		Totals: make(map[string]*big.Int, 0),
+		Sums:   sums,
+	}
+
+	for name := range sums {
+		result, err := key.CombinePartialDecryptions(parts[name], parties)
+		if err != nil {
+			return nil, err
 		}
 		t.Totals[name] = result
 	}
 
-	return t, err
+	return t, nil
+}
+
+// validPartialTally returns whether the PartialTally pt contains a
+// partial decryption of each of the encrypted sums in sums, each of
+// which has a valid proof for the verification key of its share.
+func validPartialTally(sums map[string]*big.Int, key *crypto.PublicKey, pt PartialTally,
+	vks []*big.Int, parties int) bool {
+
+	if pt.X == nil || pt.X.Sign() <= 0 || pt.X.Cmp(big.NewInt(int64(parties))) > 0 ||
+		pt.X.Cmp(big.NewInt(int64(len(vks)))) > 0 {
+		return false
+	}
+	vk := vks[pt.X.Int64()-1]
+
+	if len(pt.Sums) != len(sums) {
+		return false
+	}
+	for name, sum := range sums {
+		other, ok := pt.Sums[name]
+		if !ok || other == nil || other.Cmp(sum) != 0 {
+			return false
+		}
+		p, ok := pt.Partials[name]
+		if !ok || p.X == nil || p.X.Cmp(pt.X) != 0 {
+			return false
+		}
+		if !key.VerifyPartialDecryption(sum, p, vk) {
+			return false
+		}
+	}
+	return true
 }
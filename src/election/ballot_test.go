@@ -0,0 +1,296 @@
+package election_test
+
+import (
+	"github.com/CPSSD/voting/src/crypto"
+	"github.com/CPSSD/voting/src/election"
+	"math/big"
+	"testing"
+)
+
+func TestFormatLimits(t *testing.T) {
+
+	var tests = []struct {
+		min, max int
+		valid    bool
+		expMin   int
+		expMax   int
+	}{
+		{0, 0, true, 0, 3},
+		{1, 0, true, 1, 3},
+		{0, 2, true, 0, 2},
+		{2, 2, true, 2, 2},
+		{3, 2, false, 3, 2},
+		{0, 4, false, 0, 4},
+		{-1, 2, false, -1, 2},
+	}
+
+	for _, test := range tests {
+		f := NewTestFormat(3)
+		f.MinSelections, f.MaxSelections = test.min, test.max
+		if min, max := f.SelectionRange(); min != test.expMin || max != test.expMax {
+			t.Error("For input", test.min, test.max, "expected the range", test.expMin, test.expMax,
+				"got", min, max, "\n")
+		}
+		if err := f.Validate(); (err == nil) != test.valid {
+			t.Error("For input", test.min, test.max, "expected the format to be valid:", test.valid,
+				"got", err, "\n")
+		}
+	}
+
+	// the number of selections must match the selections listed
+	f := NewTestFormat(3)
+	f.NumSelections = 2
+	if err := f.Validate(); err != election.InvalidFormatError {
+		t.Error("Expected", election.InvalidFormatError, "for a bad number of selections, got", err, "\n")
+	}
+}
+
+func TestThresholdTally(t *testing.T) {
+
+	priv, err := crypto.GenerateKeyPair(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &priv.PublicKey
+	f := NewTestFormat(2)
+	bs, err := NewTestBallots(f, key, [][]int64{{1, 0}, {1, 1}, {1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is divided into three shares, any two of which
+	// decrypt the tally
+	shares, err := priv.DivideKey(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vks := make([]*big.Int, len(shares))
+	pts := make([]election.PartialTally, len(shares))
+	for i, share := range shares {
+		if vks[i], err = key.VerificationKey(share); err != nil {
+			t.Fatal(err)
+		}
+		pt, err := f.PartialTally(bs, key, share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pts[i] = *pt
+	}
+
+	tamperedValue := TamperTestPartialTally(pts[0], func(p *crypto.PartialDecryption) {
+		p.Value = new(big.Int).Add(p.Value, big.NewInt(1))
+	})
+	tamperedProof := TamperTestPartialTally(pts[0], func(p *crypto.PartialDecryption) {
+		p.Z = new(big.Int).Add(p.Z, big.NewInt(1))
+	})
+
+	var tests = []struct {
+		name     string
+		pts      []election.PartialTally
+		expected error
+	}{
+		{"every share", pts, nil},
+		{"threshold shares", pts[1:], nil},
+		{"fewer than threshold shares", pts[:1], election.InsufficientPartialsError},
+		{"tampered decryption", []election.PartialTally{tamperedValue, pts[1]}, election.InsufficientPartialsError},
+		{"tampered proof", []election.PartialTally{tamperedProof, pts[1]}, election.InsufficientPartialsError},
+		{"tampered and honest shares", []election.PartialTally{tamperedValue, pts[1], pts[2]}, nil},
+		{"repeated share", []election.PartialTally{pts[0], pts[0], pts[1]}, nil},
+		{"repeated share below threshold", []election.PartialTally{pts[0], pts[0]}, election.InsufficientPartialsError},
+	}
+
+	for _, test := range tests {
+		tally, err := f.ThresholdTally(bs, key, test.pts, vks, 2, 3)
+		if err != test.expected {
+			t.Error("For input", test.name, "expected", test.expected, "got", err, "\n")
+			continue
+		}
+		if err == nil && (tally.Totals["a"].Int64() != 3 || tally.Totals["b"].Int64() != 1) {
+			t.Error("For input", test.name, "expected the totals 3 and 1, got", tally.Totals, "\n")
+		}
+	}
+
+	// a partial tally is only verified if it is correct
+	var partials = []struct {
+		name  string
+		pt    election.PartialTally
+		valid bool
+	}{
+		{"honest partial tally", pts[0], true},
+		{"tampered decryption", tamperedValue, false},
+		{"tampered proof", tamperedProof, false},
+	}
+
+	for _, test := range partials {
+		err = f.VerifyPartialTally(bs, key, &test.pt, vks, 3)
+		if (err == nil) != test.valid {
+			t.Error("For input", test.name, "expected the partial tally to be valid:", test.valid, "got", err, "\n")
+		}
+	}
+}
+
+func TestVerifyTally(t *testing.T) {
+
+	priv, err := crypto.GenerateKeyPair(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &priv.PublicKey
+	f := NewTestFormat(2)
+	bs, err := NewTestBallots(f, key, [][]int64{{1, 0}, {1, 1}, {0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tally is published both decrypted with the private key,
+	// and combined from partial tallies of shares of the key
+	tally, err := f.Tally(bs, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := priv.DivideKey(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vks := make([]*big.Int, len(shares))
+	pts := make([]election.PartialTally, 0, len(shares))
+	for i, share := range shares {
+		if vks[i], err = key.VerificationKey(share); err != nil {
+			t.Fatal(err)
+		}
+		pt, err := f.PartialTally(bs, key, share)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pts = append(pts, *pt)
+	}
+	threshold, err := f.ThresholdTally(bs, key, pts[:2], vks, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := big.NewInt(1)
+	var tests = []struct {
+		name  string
+		tally *election.Tally
+		valid bool
+	}{
+		{"published tally", tally, true},
+		{"published threshold tally", threshold, true},
+		{"modified total", TamperTestTally(tally, func(t *election.Tally) {
+			t.Totals["a"] = new(big.Int).Add(t.Totals["a"], one)
+		}), false},
+		{"modified sum", TamperTestTally(tally, func(t *election.Tally) {
+			t.Sums["a"] = new(big.Int).Add(t.Sums["a"], one)
+		}), false},
+		{"modified proof", TamperTestTally(tally, func(t *election.Tally) {
+			t.Proofs["a"] = new(big.Int).Add(t.Proofs["a"], one)
+		}), false},
+		{"missing proof", TamperTestTally(tally, func(t *election.Tally) {
+			delete(t.Proofs, "b")
+		}), false},
+		{"modified threshold total", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Totals["b"] = new(big.Int).Add(t.Totals["b"], one)
+		}), false},
+		{"modified threshold sum", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Sums["b"] = new(big.Int).Add(t.Sums["b"], one)
+		}), false},
+		{"modified decryption proof", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Partials[0] = TamperTestPartialTally(t.Partials[0], func(p *crypto.PartialDecryption) {
+				p.Z = new(big.Int).Add(p.Z, one)
+			})
+		}), false},
+		{"fewer than threshold partial tallies", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Partials = t.Partials[:1]
+		}), false},
+		{"repeated partial tally", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Partials = append(t.Partials, t.Partials[0])
+		}), true},
+		{"only a repeated partial tally", TamperTestTally(threshold, func(t *election.Tally) {
+			t.Partials = []election.PartialTally{t.Partials[0], t.Partials[0]}
+		}), false},
+	}
+
+	for _, test := range tests {
+		err = f.VerifyTally(bs, key, test.tally, vks, 2, 3)
+		if (err == nil) != test.valid {
+			t.Error("For input", test.name, "expected the tally to be valid:", test.valid, "got", err, "\n")
+		}
+	}
+
+	// a tally is only valid for the ballots it was made from
+	others, err := NewTestBallots(f, key, [][]int64{{1, 0}, {1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.VerifyTally(others, key, tally, vks, 2, 3); err != election.InvalidTallyError {
+		t.Error("Expected", election.InvalidTallyError, "for the tally of other ballots, got", err, "\n")
+	}
+}
+
+// NewTestFormat returns a Format with n selections, and no limits
+// on how many of them a voter may vote for.
+func NewTestFormat(n int) (f *election.Format) {
+
+	f = &election.Format{NumSelections: n, Selections: make([]election.Selection, n)}
+	for i := range f.Selections {
+		f.Selections[i].Name = string('a' + rune(i))
+	}
+	return f
+}
+
+// NewTestBallots returns a ballot of the Format f for each of the
+// lists of votes, encrypted with key.
+func NewTestBallots(f *election.Format, key *crypto.PublicKey, votes [][]int64) (bs *[]election.Ballot, err error) {
+
+	ballots := make([]election.Ballot, len(votes))
+	for i, vs := range votes {
+		b := &ballots[i]
+		b.VoteToken = string('a' + rune(i))
+		b.NumSelections = f.NumSelections
+		b.Selections = make([]election.Selection, f.NumSelections)
+		for j, v := range vs {
+			b.Selections[j] = election.Selection{Name: f.Selections[j].Name, Vote: big.NewInt(v)}
+		}
+		if err = b.Encrypt(*f, key); err != nil {
+			return nil, err
+		}
+	}
+	return &ballots, nil
+}
+
+// TamperTestPartialTally returns a copy of the PartialTally pt in
+// which each partial decryption has been changed by tamper.
+func TamperTestPartialTally(pt election.PartialTally, tamper func(p *crypto.PartialDecryption)) (tampered election.PartialTally) {
+
+	tampered = pt
+	tampered.Partials = make(map[string]crypto.PartialDecryption, len(pt.Partials))
+	for name, p := range pt.Partials {
+		tamper(&p)
+		tampered.Partials[name] = p
+	}
+	return tampered
+}
+
+// TamperTestTally returns a copy of the Tally t which has been
+// changed by tamper.
+func TamperTestTally(t *election.Tally, tamper func(t *election.Tally)) (tampered *election.Tally) {
+
+	tampered = &election.Tally{
+		Totals:   make(map[string]*big.Int, len(t.Totals)),
+		Sums:     make(map[string]*big.Int, len(t.Sums)),
+		Proofs:   make(map[string]*big.Int, len(t.Proofs)),
+		Partials: append([]election.PartialTally(nil), t.Partials...),
+	}
+	for name, v := range t.Totals {
+		tampered.Totals[name] = v
+	}
+	for name, v := range t.Sums {
+		tampered.Sums[name] = v
+	}
+	for name, v := range t.Proofs {
+		tampered.Proofs[name] = v
+	}
+	tamper(tampered)
+	return tampered
+}
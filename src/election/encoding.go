@@ -0,0 +1,63 @@
+package election
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/CPSSD/voting/src/crypto"
+)
+
+// ballotTag and formatTag separate the encodings of a Ballot
+// and a Format from the encodings of other values which may
+// be hashed.
+const (
+	ballotTag = "voting/ballot/v1"
+	formatTag = "voting/format/v1"
+)
+
+// Bytes returns the canonical binary encoding of the Ballot,
+// which covers every field. Two ballots have the same encoding
+// only if they have the same contents, so the encoding can be
+// hashed and signed.
+func (b *Ballot) Bytes() []byte {
+
+	var buf bytes.Buffer
+	crypto.WriteString(&buf, ballotTag)
+	crypto.WriteString(&buf, b.VoteToken)
+	binary.Write(&buf, binary.BigEndian, int64(b.NumSelections))
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(b.Selections)))
+	for _, s := range b.Selections {
+		crypto.WriteString(&buf, s.Name)
+		crypto.WriteInt(&buf, s.Vote)
+		crypto.WriteBytes(&buf, s.Proof)
+	}
+	crypto.WriteBytes(&buf, b.Proof)
+
+	return buf.Bytes()
+}
+
+// Hash returns the SHA-256 hash of the canonical encoding
+// of the Ballot.
+func (b *Ballot) Hash() [32]byte {
+	return sha256.Sum256(b.Bytes())
+}
+
+// Bytes returns the canonical binary encoding of the Format,
+// which covers the limits on selections and the name of each
+// selection, in order.
+func (f *Format) Bytes() []byte {
+
+	var buf bytes.Buffer
+	crypto.WriteString(&buf, formatTag)
+	binary.Write(&buf, binary.BigEndian, int64(f.NumSelections))
+	binary.Write(&buf, binary.BigEndian, int64(f.MinSelections))
+	binary.Write(&buf, binary.BigEndian, int64(f.MaxSelections))
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(f.Selections)))
+	for _, s := range f.Selections {
+		crypto.WriteString(&buf, s.Name)
+	}
+
+	return buf.Bytes()
+}
@@ -1,15 +1,27 @@
 // Generate is used to create a set of user configs for the
 // system, along with the public and private key of the
-// election. The private key is shared amongst the nodes.
+// election. The private key is shared amongst the nodes,
+// either as shares of its values or, in threshold mode, as
+// shares of its decryption exponent.
 // This program will also create DSA keys for users. The
 // completed configuration files will be named json files
 // in the range 0 to N-1, where N is the number of users to
-// generate.
+// generate. The genesis block of the election is saved to
+// genesis.json, and its hash is printed so that it can be
+// published. With proof of authority, the first nodes are also
+// given the keys of the authorities which sign blocks. The chain
+// parameters of the election, such as the size of blocks, are
+// part of the genesis block, so every node uses the same ones.
+// Nodes may also be given certificates, so that they connect to
+// each other over TLS. The fingerprints of the certificates are
+// registered in the genesis block, and peers are authenticated
+// against them.
 package main
 
 import (
 	"crypto/dsa"
 	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/CPSSD/voting/src/blockchain"
@@ -19,16 +31,29 @@ import (
 	"math/big"
 	mrand "math/rand"
 	"strconv"
+	"time"
 )
 
+// keyBits is the size in bits of the primes of the election key.
+const keyBits = 512
+
+// genesisFile is the name of the file the genesis block is saved to.
+const genesisFile = "genesis.json"
 
 func main() {
 	var numVoters int      // how many "registered" voterList are there
 	var shareThreshold int // amount of collaborators to recreate election key
 	var allowPeerSync bool // are peers allowed to discover new peers
+	var threshold bool     // is the tally decrypted without reconstructing the key
+	var distributed bool   // is the election key created by the trustees
+	var numTrustees int    // how many nodes create the election key
 	var portNumber int     // first port to use for the network
 	var tokenLen int       // number of characters in a vote token
 	var degree int         // minimum number of known peers per node
+	var votingHours int    // length of the voting window
+	var numAuthorities int // how many nodes sign blocks with proof of authority
+	var useTLS bool        // do nodes connect over TLS with certificates
+	var requireNodes bool  // may only registered nodes connect to a node
 	var input string
 
 	fmt.Printf("Number of voters to generate: ")
@@ -48,6 +73,26 @@ func main() {
 		fmt.Println("Allowing peers to sync by default")
 		allowPeerSync = true
 	}
+	fmt.Printf("Create the election key without a dealer? (y/n): ")
+	fmt.Scanf("%v\n", &input)
+	distributed = len(input) > 0 && input[0] == 'y'
+
+	if distributed {
+		// the key can only be used for threshold decryption
+		threshold = true
+		fmt.Printf("Number of trustees to create the election key (min = %d): ", crypto.MinKeyGenParties)
+		fmt.Scanf("%v\n", &numTrustees)
+		if numTrustees < crypto.MinKeyGenParties || numTrustees < shareThreshold || numTrustees > numVoters {
+			fmt.Println("Number of trustees must be at least", crypto.MinKeyGenParties,
+				"and between the threshold and the number of voters")
+			return
+		}
+	} else {
+		fmt.Printf("Use threshold decryption of the tally? (y/n): ")
+		fmt.Scanf("%v\n", &input)
+		threshold = len(input) > 0 && input[0] == 'y'
+	}
+
 	fmt.Printf("Minimum known starting peers? (min recommended = 1): ")
 	fmt.Scanf("%v\n", &degree)
 
@@ -57,28 +102,120 @@ func main() {
 	fmt.Printf("Number of characters in a vote node: ")
 	fmt.Scanf("%v\n", &tokenLen)
 
+	fmt.Printf("Hours that voting is open for (0 for no limit): ")
+	fmt.Scanf("%v\n", &votingHours)
+	votingStart := uint32(time.Now().Unix())
+	var votingEnd uint32
+	if votingHours > 0 {
+		votingEnd = votingStart + uint32(votingHours*60*60)
+	}
+
+	consensus := blockchain.ProofOfWork
+	fmt.Printf("Sign blocks by authorities instead of proof of work? (y/n): ")
+	fmt.Scanf("%v\n", &input)
+	if len(input) > 0 && input[0] == 'y' {
+		consensus = blockchain.ProofOfAuthority
+		fmt.Printf("Number of authorities to sign blocks (min recommended = 3): ")
+		fmt.Scanf("%v\n", &numAuthorities)
+		if numAuthorities < 1 || numAuthorities > numVoters {
+			fmt.Println("Number of authorities must be between 1 and the number of voters")
+			return
+		}
+	}
+
+	params := blockchain.DefaultChainParams()
+	var blockSize, difficulty int
+	fmt.Printf("Transactions per block (0 for the default of %v): ", params.BlockSize)
+	fmt.Scanf("%v\n", &blockSize)
+	if blockSize > 0 {
+		params.BlockSize = blockSize
+	}
+	if consensus == blockchain.ProofOfWork {
+		fmt.Printf("Initial proof of work difficulty (0 for the default of %v): ", params.Difficulty)
+		fmt.Scanf("%v\n", &difficulty)
+		if difficulty > 0 {
+			params.Difficulty = difficulty
+		}
+	}
+
+	fmt.Printf("Connect nodes over TLS with certificates? (y/n): ")
+	fmt.Scanf("%v\n", &input)
+	useTLS = len(input) > 0 && input[0] == 'y'
+	if useTLS {
+		fmt.Printf("Only allow registered nodes to connect? (y/n): ")
+		fmt.Scanf("%v\n", &input)
+		requireNodes = len(input) > 0 && input[0] == 'y'
+	}
+
 	format := election.CreateFormat()
 
 	fmt.Println("Building election config...")
-	// create the election key
-	priv, err := crypto.GenerateKeyPair(512)
-	if err != nil {
-		panic(err)
-	}
 
-	// create the shares of the election key lambda value
-	lambdaShares, lambdaPrimeModulus, err := crypto.DivideSecret(priv.Lambda, shareThreshold, numVoters)
-	if err != nil {
-		panic(err)
+	var lambdaShares, muShares, decryptionShares []crypto.Share
+	var lambdaPrimeModulus, muPrimeModulus *big.Int
+	var lambdaCommitments, muCommitments *crypto.Commitments
+	var trustees []string
+	var verificationKeys []*big.Int
+
+	// create the election key, unless the trustees will create it
+	priv := &crypto.PrivateKey{}
+	var err error
+	if !distributed {
+		priv, err = crypto.GenerateKeyPair(keyBits)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	// create the shares of the election key's mu value
-	muShares, muPrimeModulus, err := crypto.DivideSecret(priv.Mu, shareThreshold, numVoters)
-	if err != nil {
-		panic(err)
+	if distributed {
+		// shares are created by the trustees during key generation
+		lambdaShares = make([]crypto.Share, numVoters)
+		muShares = make([]crypto.Share, numVoters)
+		decryptionShares = make([]crypto.Share, numVoters)
+		for i := 0; i < numTrustees; i++ {
+			trustees = append(trustees, "localhost:"+strconv.Itoa(portNumber+i))
+		}
+	} else if threshold {
+		// create the shares of the election key's decryption exponent
+		decryptionShares, err = priv.DivideKey(shareThreshold, numVoters)
+		if err != nil {
+			panic(err)
+		}
+		// publish a verification key for each share, so that the
+		// partial decryptions of the tally can be checked
+		for _, s := range decryptionShares {
+			vk, err := priv.PublicKey.VerificationKey(s)
+			if err != nil {
+				panic(err)
+			}
+			verificationKeys = append(verificationKeys, vk)
+		}
+		lambdaShares = make([]crypto.Share, numVoters)
+		muShares = make([]crypto.Share, numVoters)
+	} else {
+		// create the shares of the election key lambda value
+		lambdaShares, lambdaPrimeModulus, lambdaCommitments, err = crypto.DivideSecretVerifiable(priv.Lambda, shareThreshold, numVoters)
+		if err != nil {
+			panic(err)
+		}
+
+		// create the shares of the election key's mu value
+		muShares, muPrimeModulus, muCommitments, err = crypto.DivideSecretVerifiable(priv.Mu, shareThreshold, numVoters)
+		if err != nil {
+			panic(err)
+		}
+		decryptionShares = make([]crypto.Share, numVoters)
+	}
+	parties := numVoters
+	if distributed {
+		parties = numTrustees
 	}
 
+	electionID := createElectionID()
 	voteTokens := make(map[string]dsa.PublicKey, numVoters)
+	authorities := make([]dsa.PublicKey, 0, numAuthorities)
+	trusteeKeys := make([]dsa.PublicKey, 0, len(trustees))
+	nodes := make([]string, 0)
 
 	voterList := make([]blockchain.Configuration, numVoters)
 	var i int
@@ -102,6 +239,7 @@ func main() {
 			PrivateKey: *privateKey,
 			MyToken:    vt,
 
+			ElectionID:     electionID,
 			ElectionFormat: *format,
 
 			ElectionKey: crypto.PrivateKey{
@@ -116,6 +254,53 @@ func main() {
 			},
 			ElectionLambdaModulus: lambdaPrimeModulus,
 			ElectionMuModulus:     muPrimeModulus,
+
+			ElectionLambdaCommitments: lambdaCommitments,
+			ElectionMuCommitments:     muCommitments,
+
+			ThresholdDecryption:     threshold,
+			ElectionDecryptionShare: decryptionShares[i],
+			ElectionThreshold:       shareThreshold,
+			ElectionParties:         parties,
+
+			ElectionVerificationKeys: verificationKeys,
+
+			VotingStart: votingStart,
+			VotingEnd:   votingEnd,
+
+			StoreDir: strconv.Itoa(i) + ".store",
+
+			Consensus: consensus,
+			Params:    params,
+
+			DistributedKeyGeneration: distributed,
+			Trustees:                 trustees,
+			KeyGenerationBits:        keyBits,
+		}
+
+		// the first nodes are the trustees, which sign their key
+		// generation messages with their own keys
+		if i < len(trustees) {
+			trusteeKeys = append(trusteeKeys, privateKey.PublicKey)
+		}
+
+		// the first nodes are the authorities which sign blocks
+		if i < numAuthorities {
+			authorityKey := createKey()
+			conf.AuthorityKey = *authorityKey
+			authorities = append(authorities, authorityKey.PublicKey)
+		}
+
+		// each node has its own certificate, which is registered
+		// for the election
+		if useTLS {
+			conf.TLSCertificate, conf.TLSKey = createCertificate(conf.MyAddr)
+			conf.RequireRegisteredPeers = requireNodes
+			fp, err := blockchain.CertificateFingerprint(conf.TLSCertificate)
+			if err != nil {
+				panic(err)
+			}
+			nodes = append(nodes, fp)
 		}
 
 		voterList[i] = conf
@@ -124,6 +309,27 @@ func main() {
 
 	for i, _ := range voterList {
 		voterList[i].VoteTokens = voteTokens
+		voterList[i].Authorities = authorities
+		voterList[i].TrusteeKeys = trusteeKeys
+		voterList[i].Nodes = nodes
+	}
+
+	// with a dealer, the genesis block can be created now, and its
+	// hash published. Otherwise each node creates it once the
+	// trustees have generated the election key.
+	if !distributed {
+		genesis := blockchain.NewGenesisBlock(blockchain.NewManifest(&voterList[0]))
+		bytes, err := json.MarshalIndent(genesis, "", "    ")
+		err = ioutil.WriteFile(genesisFile, bytes, 0777)
+		if err != nil {
+			fmt.Println("Could not save the genesis block to json file")
+			panic(err)
+		}
+		fmt.Println("Genesis block hash:", hex.EncodeToString(genesis.Proof[:]))
+		for i, _ := range voterList {
+			voterList[i].GenesisFile = genesisFile
+			voterList[i].GenesisHash = hex.EncodeToString(genesis.Proof[:])
+		}
 	}
 
 	voterList = generateUndirectedGraph(voterList, degree)
@@ -181,6 +387,28 @@ func createVoteToken(n int) string {
 	return string(b)
 }
 
+// createElectionID returns a random identifier for an election.
+func createElectionID() string {
+	b := make([]byte, 16)
+	_, err := crand.Read(b)
+	if err != nil {
+		fmt.Println("Could not generate the election identifier")
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// createCertificate returns a new certificate and private key, PEM
+// encoded, for a node at host.
+func createCertificate(host string) (cert, key string) {
+	cert, key, err := blockchain.NewNodeCertificate(host)
+	if err != nil {
+		fmt.Println("Could not generate a node certificate")
+		panic(err)
+	}
+	return cert, key
+}
+
 func createKey() (privateKey *dsa.PrivateKey) {
 	params := new(dsa.Parameters)
 
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/CPSSD/voting/src/blockchain"
 	"github.com/CPSSD/voting/src/election"
+	"io/ioutil"
 	"log"
 	"os"
-	"sync"
+	"strings"
 )
 
 var (
@@ -14,6 +19,8 @@ var (
 	voteMsg     string = "Please enter your ballot message"
 	badInputMsg string = "Unrecognised input"
 	waitMsg     string = "Waiting for processes to quit"
+	tallyMsg    string = "Please enter the name of the tally file"
+	receiptMsg  string = "Please enter the name of the receipt file"
 )
 
 func main() {
@@ -35,25 +42,26 @@ func main() {
 	log.Println("Setting up network config")
 	filename := string(os.Args[1])
 
-	c.Init(filename)
-
-	// to quit entirely
-	quit := make(chan bool, 1)
-
-	// to signal to stop mining
-	stop := make(chan bool, 1)
-
-	// to signal to start mining
-	start := make(chan bool, 1)
+	if err = c.Init(filename); err != nil {
+		log.Fatalln(err)
+	}
 
-	// to signal to confirm stopped mining
-	confirm := make(chan bool, 1)
+	// with distributed key generation, the genesis block is only
+	// created once the trustees have generated the election key,
+	// which must finish before the chain is run
+	if c.GenesisHash() == *new([32]byte) {
+		fmt.Println("Generating the election key with the trustees, this may take some time")
+		if err = c.GenerateElectionKey(); err != nil {
+			log.Fatalln("Error generating the election key:", err)
+		}
+		fmt.Println("The election key has been generated")
+	}
 
-	var syncDelay int = 10
-	var wg sync.WaitGroup
-	wg.Add(4)
-	c.Start(syncDelay, quit, stop, start, confirm, &wg)
-	start <- true
+	// the chain runs until we quit
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- c.Run(context.Background())
+	}()
 
 	fmt.Println("Welcome to voting system.")
 	vt := c.GetVoteToken()
@@ -73,9 +81,13 @@ loop:
 			fmt.Printf("\tchain\t\tPrint current chain\n")
 			fmt.Printf("\tv\t\tCast a vote\n")
 			fmt.Printf("\tq\t\tQuit program\n")
-			fmt.Printf("\tb\t\tBroadcast share\n")
+			fmt.Printf("\tb\t\tBroadcast share (or partial tally in threshold mode)\n")
 			fmt.Printf("\tr\t\tReconstruct election key\n")
+			fmt.Printf("\tflush\t\tMine every pending transaction when the election closes\n")
 			fmt.Printf("\ttally\t\tTally the votes\n")
+			fmt.Printf("\tverify\t\tVerify a tally against the chain\n")
+			fmt.Printf("\treceipt\t\tShow and verify the receipt for our vote\n")
+			fmt.Printf("\tcheck\t\tVerify a saved receipt against the chain\n")
 		case "peers":
 			c.PrintPeers()
 		case "pool":
@@ -85,9 +97,16 @@ loop:
 			fmt.Println(c)
 			fmt.Println("Exited print chain")
 		case "q":
-			quit <- true
+			c.Stop()
 			break loop
 		case "b":
+			if c.UsesThresholdDecryption() {
+				fmt.Printf("Broadcasting our partial decryption of the tally\n")
+				if err := c.BroadcastPartialTally(); err != nil {
+					fmt.Println("Error calculating partial tally:", err)
+				}
+				break
+			}
 			fmt.Printf("Broadcasting our share of the election key\n")
 			c.BroadcastShare()
 		case "r":
@@ -99,23 +118,102 @@ loop:
 			token := vt
 
 			ballot := new(election.Ballot)
-			err := ballot.Fill(c.GetFormat(), tokenMsg)
+			err := ballot.Fill(c.GetFormat(), token)
 			if err != nil {
 				log.Printf("Error filling out the ballot")
-			} else {
-				tr := c.NewTransaction(token, ballot)
-				go c.ReceiveTransaction(tr, nil)
+				break
+			}
+			tr, err := c.NewTransaction(token, ballot)
+			if err != nil {
+				fmt.Println("Error creating the transaction:", err)
+				break
+			}
+			// the receipt is for the vote we cast, so its hash
+			// is kept rather than looked up in the chain later
+			if err = saveVoteHash(tr.Hash(), filename+".vote"); err != nil {
+				fmt.Println("Error saving the hash of the vote:", err)
 			}
+			go c.ReceiveTransaction(tr, nil)
+		case "flush":
+			c.Flush()
+			fmt.Println("Every pending transaction will be mined into a block")
 		case "tally":
-			ballots := c.CollectBallots()
-			format := c.GetFormat()
-			key := c.GetElectionKey()
 			fmt.Println("Calculating the tally...")
-			tally, err := format.Tally(ballots, &key)
+			var tally *election.Tally
+			if c.UsesThresholdDecryption() {
+				tally, err = c.ThresholdTally()
+			} else {
+				ballots := c.CollectBallots()
+				format := c.GetFormat()
+				key := c.GetElectionKey()
+				tally, err = format.Tally(ballots, &key)
+			}
+			if err != nil {
+				fmt.Println("Error calculating tally:", err)
+				break
+			}
+			fmt.Println(tally)
+			if err = c.VerifyTally(tally); err != nil {
+				fmt.Println("Error verifying tally:", err)
+				break
+			}
+			if err = saveTally(tally, filename+".tally"); err != nil {
+				fmt.Println("Error saving tally:", err)
+				break
+			}
+			fmt.Println("Tally and proofs saved to", filename+".tally")
+		case "verify":
+			fmt.Println(tallyMsg)
+			var name string
+			fmt.Scanf("%v\n", &name)
+			tally, err := loadTally(name)
 			if err != nil {
-				fmt.Println("Error calculating tally")
+				fmt.Println("Error reading tally:", err)
+				break
 			}
 			fmt.Println(tally)
+			if err = c.VerifyTally(tally); err != nil {
+				fmt.Println("Error verifying tally:", err)
+				break
+			}
+			fmt.Println("The tally is the correct decryption of the ballots in the chain")
+		case "receipt":
+			hash, err := loadVoteHash(filename + ".vote")
+			if err != nil {
+				fmt.Println("Error reading the hash of our vote, which is saved when it is cast:", err)
+				break
+			}
+			r, err := c.GetReceipt(hash)
+			if err != nil {
+				fmt.Println("Error getting the receipt:", err)
+				break
+			}
+			fmt.Println(r)
+			if err = c.VerifyReceipt(r); err != nil {
+				fmt.Println("Error verifying the receipt:", err)
+				break
+			}
+			fmt.Println("The receipt proves that the vote is in the chain")
+			if err = saveReceipt(r, filename+".receipt"); err != nil {
+				fmt.Println("Error saving the receipt:", err)
+				break
+			}
+			fmt.Println("Receipt saved to", filename+".receipt")
+		case "check":
+			fmt.Println(receiptMsg)
+			var name string
+			fmt.Scanf("%v\n", &name)
+			r, err := loadReceipt(name)
+			if err != nil {
+				fmt.Println("Error reading the receipt:", err)
+				break
+			}
+			fmt.Println(r)
+			if err = c.VerifyReceipt(r); err != nil {
+				fmt.Println("Error verifying the receipt:", err)
+				break
+			}
+			fmt.Println("The receipt proves that the vote is in the chain")
 		default:
 			fmt.Println(badInputMsg)
 		}
@@ -124,6 +222,77 @@ loop:
 
 	fmt.Printf("%v\n", waitMsg)
 	log.Printf("%v\n", waitMsg)
-	wg.Wait()
+	if err = <-stopped; err != nil {
+		log.Println("Error stopping the chain:", err)
+	}
 	log.Println(c)
 }
+
+// saveTally writes the tally, along with its proofs, to a file
+// so that it can be checked by other nodes.
+func saveTally(t *election.Tally, name string) (err error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// loadTally reads a tally written by saveTally.
+func loadTally(name string) (t *election.Tally, err error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	t = new(election.Tally)
+	if err = json.Unmarshal(b, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// saveVoteHash writes the hash of the transaction with our vote
+// to a file, so that its receipt can be found after a restart.
+func saveVoteHash(hash [32]byte, name string) (err error) {
+	return ioutil.WriteFile(name, []byte(hex.EncodeToString(hash[:])), 0644)
+}
+
+// loadVoteHash reads a hash written by saveVoteHash.
+func loadVoteHash(name string) (hash [32]byte, err error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return hash, err
+	}
+	decoded, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return hash, err
+	}
+	if len(decoded) != len(hash) {
+		return hash, errors.New("The saved hash of the vote has the wrong length.")
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// saveReceipt writes the receipt for a vote to a file, so that
+// the voter can check it against the chain later.
+func saveReceipt(r *blockchain.Receipt, name string) (err error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// loadReceipt reads a receipt written by saveReceipt.
+func loadReceipt(name string) (r *blockchain.Receipt, err error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r = new(blockchain.Receipt)
+	if err = json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}